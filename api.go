@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	APIKindStruct          = "struct"
+	APIKindField           = "field"
+	APIKindInterface       = "interface"
+	APIKindInterfaceMethod = "interface-method"
+	APIKindFunc            = "func"
+	APIKindMethod          = "method"
+	APIKindConst           = "const"
+	APIKindVar             = "var"
+	APIKindType            = "type"
+)
+
+// APIEntry is one element of the exported surface. Fields, methods and
+// interface methods are named Type.Name. Signatures leave out parameter
+// names, so renaming a parameter is not a change.
+type APIEntry struct {
+	Package   string `json:"package"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Signature string `json:"signature,omitempty"`
+}
+
+func (ae APIEntry) key() string {
+	return ae.Package + "\t" + ae.Kind + "\t" + ae.Name
+}
+
+type APISnapshot struct {
+	Entries []APIEntry `json:"entries"`
+}
+
+// BuildAPISnapshot collects the exported surface of the analyzed library
+// packages. Commands, internal packages and test files are not part of it.
+func BuildAPISnapshot(opts AnalysisOptions, resolver PackageResolver) APISnapshot {
+	return buildDeclarationSnapshot(opts, resolver, true)
+}
+
+// buildDeclarationSnapshot lists the declarations of the analyzed
+// packages, or only their exported surface when exportedOnly is set.
+func buildDeclarationSnapshot(opts AnalysisOptions, resolver PackageResolver, exportedOnly bool) APISnapshot {
+	entries := make(map[string]APIEntry)
+	add := func(entry APIEntry) {
+		entries[entry.key()] = entry
+	}
+	// Blank declarations such as var _ Interface = (*T)(nil) can't be
+	// referred to and would all share one key
+	include := func(name string) bool {
+		return name != "_" && (!exportedOnly || isExportedName(name))
+	}
+	packageOf := func(file, dir, pkgName string) (string, bool) {
+		if strings.HasSuffix(file, "_test.go") {
+			return "", false
+		}
+		path := resolver.ImportPath(dir, pkgName)
+		if !exportedOnly {
+			return path, true
+		}
+		if pkgName == "main" || path == "internal" || strings.HasPrefix(path, "internal/") || strings.Contains(path, "/internal/") || strings.HasSuffix(path, "/internal") {
+			return "", false
+		}
+		return path, true
+	}
+
+	if opts.StructSources != nil {
+		for _, src := range opts.StructSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, st := range src.Items {
+				if !include(st.Name) {
+					continue
+				}
+				add(APIEntry{Package: path, Kind: APIKindStruct, Name: st.Name, Signature: strings.Join(st.TypeParams, ", ")})
+				for _, field := range st.Fields {
+					name, typ := splitAPIField(field)
+					// Embedded fields are named by their type without package
+					base := receiverBaseName(name)
+					if idx := strings.LastIndex(base, "."); idx >= 0 {
+						base = base[idx+1:]
+					}
+					if include(base) {
+						add(APIEntry{Package: path, Kind: APIKindField, Name: st.Name + "." + name, Signature: typ})
+					}
+				}
+			}
+		}
+	}
+
+	if opts.InterfaceSources != nil {
+		for _, src := range opts.InterfaceSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, iface := range src.Items {
+				if !include(iface.Name) {
+					continue
+				}
+				add(APIEntry{Package: path, Kind: APIKindInterface, Name: iface.Name, Signature: strings.Join(iface.TypeParams, ", ")})
+				for _, method := range iface.Methods {
+					// Embedded interfaces are listed by type, methods by name
+					name, signature := method, "embedded"
+					if idx := strings.Index(method, "("); idx > 0 && isValidIdentifier(method[:idx]) {
+						name, signature = method[:idx], method[idx:]
+					}
+					add(APIEntry{Package: path, Kind: APIKindInterfaceMethod, Name: iface.Name + "." + name, Signature: signature})
+				}
+			}
+		}
+	}
+
+	// Other named types are recorded with their underlying type, aliases
+	// with "= " before it
+	if opts.TypeSources != nil {
+		for _, src := range opts.TypeSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, typ := range src.Items {
+				if !include(typ.Name) {
+					continue
+				}
+				signature := typ.Underlying
+				if typ.Alias {
+					signature = "= " + signature
+				}
+				if len(typ.TypeParams) > 0 {
+					signature = "[" + strings.Join(typ.TypeParams, ", ") + "] " + signature
+				}
+				add(APIEntry{Package: path, Kind: APIKindType, Name: typ.Name, Signature: signature})
+			}
+		}
+	}
+
+	if opts.FunctionSources != nil {
+		for _, src := range opts.FunctionSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, fn := range src.Items {
+				if !include(fn.Name) {
+					continue
+				}
+				if fn.Receiver == "" {
+					add(APIEntry{Package: path, Kind: APIKindFunc, Name: fn.Name, Signature: apiFuncSignature(fn)})
+					continue
+				}
+				if recv := receiverBaseName(fn.Receiver); include(recv) {
+					add(APIEntry{Package: path, Kind: APIKindMethod, Name: recv + "." + fn.Name, Signature: apiFuncSignature(fn)})
+				}
+			}
+		}
+	}
+
+	if opts.ConstantSources != nil {
+		for _, src := range opts.ConstantSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, c := range src.Items {
+				if !include(c.Name) {
+					continue
+				}
+				signature := c.Type
+				if c.Value != "" {
+					signature = strings.TrimSpace(signature + " = " + c.Value)
+				}
+				add(APIEntry{Package: path, Kind: APIKindConst, Name: c.Name, Signature: signature})
+			}
+		}
+	}
+
+	if opts.VariableSources != nil {
+		for _, src := range opts.VariableSources.CollectResults() {
+			path, ok := packageOf(src.File, src.Dir, src.Package)
+			if !ok {
+				continue
+			}
+			for _, v := range src.Items {
+				if include(v.Name) {
+					add(APIEntry{Package: path, Kind: APIKindVar, Name: v.Name, Signature: v.Type})
+				}
+			}
+		}
+	}
+
+	snapshot := APISnapshot{Entries: make([]APIEntry, 0, len(entries))}
+	for _, entry := range entries {
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+	sort.Slice(snapshot.Entries, func(i, j int) bool {
+		return snapshot.Entries[i].key() < snapshot.Entries[j].key()
+	})
+	return snapshot
+}
+
+func isExportedName(name string) bool {
+	return name != "" && name[0] >= 'A' && name[0] <= 'Z'
+}
+
+// splitAPIField splits "Name Type" into its parts. An embedded field is
+// named by its type.
+func splitAPIField(field string) (string, string) {
+	if idx := strings.Index(field, " "); idx > 0 && isValidIdentifier(field[:idx]) {
+		return field[:idx], field[idx+1:]
+	}
+	return field, "embedded"
+}
+
+// apiParamType strips the name from a "name type" parameter or result.
+func apiParamType(param string) string {
+	idx := strings.Index(param, " ")
+	if idx <= 0 || !isValidIdentifier(param[:idx]) {
+		return param
+	}
+	switch param[:idx] {
+	case "chan", "func", "map", "struct", "interface":
+		return param
+	}
+	return param[idx+1:]
+}
+
+func apiFuncSignature(fn GoFunction) string {
+	params := make([]string, 0, len(fn.Parameters))
+	for _, param := range fn.Parameters {
+		params = append(params, apiParamType(param))
+	}
+	returns := make([]string, 0, len(fn.Returns))
+	for _, ret := range fn.Returns {
+		returns = append(returns, apiParamType(ret))
+	}
+
+	signature := "func "
+	if fn.Receiver != "" {
+		signature += "(" + fn.Receiver + ") "
+	}
+	signature += fn.Name + "(" + strings.Join(params, ", ") + ")"
+	switch {
+	case len(returns) == 1:
+		signature += " " + returns[0]
+	case len(returns) > 1:
+		signature += " (" + strings.Join(returns, ", ") + ")"
+	}
+	return signature
+}
+
+// WriteAPISnapshot writes the canonical text form, one tab-separated
+// "package kind name signature" line per entry, or JSON.
+func WriteAPISnapshot(w io.Writer, snapshot APISnapshot, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(snapshot)
+	case "text", "":
+		fmt.Fprintln(w, "# astro API snapshot: package, kind, name and signature, tab-separated")
+		for _, entry := range snapshot.Entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Package, entry.Kind, entry.Name, entry.Signature)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
+
+// LoadAPISnapshot reads a snapshot in either format.
+func LoadAPISnapshot(path string) (APISnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return APISnapshot{}, err
+	}
+
+	snapshot := APISnapshot{Entries: make([]APIEntry, 0)}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return APISnapshot{}, fmt.Errorf("%s: %v", path, err)
+		}
+		return snapshot, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 3 {
+			return APISnapshot{}, fmt.Errorf("%s:%d: expected package, kind, name and signature separated by tabs", path, lineNo)
+		}
+		entry := APIEntry{Package: fields[0], Kind: fields[1], Name: fields[2]}
+		if len(fields) == 4 {
+			entry.Signature = fields[3]
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+	return snapshot, scanner.Err()
+}
+
+const (
+	SemverMajor = "major"
+	SemverMinor = "minor"
+	SemverPatch = "patch"
+)
+
+type APIChange struct {
+	Package  string `json:"package"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Change   string `json:"change"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Breaking bool   `json:"breaking"`
+}
+
+type APIDiff struct {
+	Changes []APIChange `json:"changes"`
+	Bump    string      `json:"bump"`
+}
+
+// DiffAPISnapshots compares two snapshots. Removing or changing anything
+// is breaking. Additions are compatible, except methods added to an
+// existing interface, which its implementations outside the module lack.
+func DiffAPISnapshots(old, new APISnapshot) APIDiff {
+	oldEntries := make(map[string]APIEntry, len(old.Entries))
+	for _, entry := range old.Entries {
+		oldEntries[entry.key()] = entry
+	}
+	newEntries := make(map[string]APIEntry, len(new.Entries))
+	for _, entry := range new.Entries {
+		newEntries[entry.key()] = entry
+	}
+
+	diff := APIDiff{Changes: make([]APIChange, 0), Bump: SemverPatch}
+	for key, entry := range oldEntries {
+		current, ok := newEntries[key]
+		switch {
+		case !ok:
+			diff.Changes = append(diff.Changes, APIChange{Package: entry.Package, Kind: entry.Kind, Name: entry.Name, Change: "removed", Old: entry.Signature, Breaking: true})
+		case current.Signature != entry.Signature:
+			diff.Changes = append(diff.Changes, APIChange{Package: entry.Package, Kind: entry.Kind, Name: entry.Name, Change: "changed", Old: entry.Signature, New: current.Signature, Breaking: true})
+		}
+	}
+	for key, entry := range newEntries {
+		if _, ok := oldEntries[key]; ok {
+			continue
+		}
+		breaking := false
+		if entry.Kind == APIKindInterfaceMethod {
+			iface := strings.SplitN(entry.Name, ".", 2)[0]
+			_, breaking = oldEntries[APIEntry{Package: entry.Package, Kind: APIKindInterface, Name: iface}.key()]
+		}
+		diff.Changes = append(diff.Changes, APIChange{Package: entry.Package, Kind: entry.Kind, Name: entry.Name, Change: "added", New: entry.Signature, Breaking: breaking})
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		a, b := diff.Changes[i], diff.Changes[j]
+		if a.Breaking != b.Breaking {
+			return a.Breaking
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+
+	for _, change := range diff.Changes {
+		if change.Breaking {
+			diff.Bump = SemverMajor
+			break
+		}
+		diff.Bump = SemverMinor
+	}
+	return diff
+}
+
+// describeAPIEntry formats an entry as "kind name signature". Function
+// signatures already name the function.
+func describeAPIEntry(kind, name, signature string) string {
+	switch {
+	case kind == APIKindFunc || kind == APIKindMethod:
+		return signature
+	case signature != "":
+		return kind + " " + name + " " + signature
+	}
+	return kind + " " + name
+}
+
+func WriteAPIDiff(w io.Writer, diff APIDiff, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(diff)
+	case "text", "":
+		fmt.Fprintln(w, "--- API Changes ---")
+		if len(diff.Changes) == 0 {
+			fmt.Fprintln(w, "No API changes")
+		}
+		heading := ""
+		for _, change := range diff.Changes {
+			section := "Compatible:"
+			if change.Breaking {
+				section = "Breaking:"
+			}
+			if section != heading {
+				heading = section
+				fmt.Fprintln(w, heading)
+			}
+
+			line := fmt.Sprintf("  %s: %s %s", change.Package, change.Change, describeAPIEntry(change.Kind, change.Name, change.New))
+			switch change.Change {
+			case "changed":
+				line = fmt.Sprintf("  %s: changed %s %s: %s -> %s", change.Package, change.Kind, change.Name, change.Old, change.New)
+			case "removed":
+				line = fmt.Sprintf("  %s: removed %s", change.Package, describeAPIEntry(change.Kind, change.Name, change.Old))
+			}
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintf(w, "Suggested version bump: %s\n", diff.Bump)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffAPISnapshots(t *testing.T) {
+	entry := func(kind, name, signature string) APIEntry {
+		return APIEntry{Package: "example.com/lib", Kind: kind, Name: name, Signature: signature}
+	}
+	base := []APIEntry{
+		entry(APIKindFunc, "New", "func New(string) *Store"),
+		entry(APIKindStruct, "Store", ""),
+		entry(APIKindField, "Store.Dir", "string"),
+		entry(APIKindInterface, "Set", "T any"),
+		entry(APIKindInterfaceMethod, "Set.Add", "(T) bool"),
+	}
+	with := func(entries ...APIEntry) []APIEntry {
+		return append(append([]APIEntry{}, base...), entries...)
+	}
+	without := func(name string) []APIEntry {
+		entries := make([]APIEntry, 0)
+		for _, e := range base {
+			if e.Name != name {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	replaced := func(replacement APIEntry) []APIEntry {
+		entries := without(replacement.Name)
+		return append(entries, replacement)
+	}
+
+	tests := []struct {
+		name    string
+		new     []APIEntry
+		changes []string // "change name breaking"
+		bump    string
+	}{
+		{
+			name: "unchanged",
+			new:  base,
+			bump: SemverPatch,
+		},
+		{
+			name:    "added function",
+			new:     with(entry(APIKindFunc, "Open", "func Open() *Store")),
+			changes: []string{"added Open false"},
+			bump:    SemverMinor,
+		},
+		{
+			name:    "added field",
+			new:     with(entry(APIKindField, "Store.Size", "int")),
+			changes: []string{"added Store.Size false"},
+			bump:    SemverMinor,
+		},
+		{
+			name:    "removed field",
+			new:     without("Store.Dir"),
+			changes: []string{"removed Store.Dir true"},
+			bump:    SemverMajor,
+		},
+		{
+			name:    "changed signature",
+			new:     replaced(entry(APIKindFunc, "New", "func New(string) (*Store, error)")),
+			changes: []string{"changed New true"},
+			bump:    SemverMajor,
+		},
+		{
+			name:    "changed interface type parameters",
+			new:     replaced(entry(APIKindInterface, "Set", "T comparable")),
+			changes: []string{"changed Set true"},
+			bump:    SemverMajor,
+		},
+		{
+			name:    "method added to an existing interface",
+			new:     with(entry(APIKindInterfaceMethod, "Set.Len", "() int")),
+			changes: []string{"added Set.Len true"},
+			bump:    SemverMajor,
+		},
+		{
+			name:    "new interface with its methods",
+			new:     with(entry(APIKindInterface, "Getter", ""), entry(APIKindInterfaceMethod, "Getter.Get", "() string")),
+			changes: []string{"added Getter false", "added Getter.Get false"},
+			bump:    SemverMinor,
+		},
+		{
+			name:    "breaking changes come first",
+			new:     append(without("Store.Dir"), entry(APIKindFunc, "Open", "func Open() *Store")),
+			changes: []string{"removed Store.Dir true", "added Open false"},
+			bump:    SemverMajor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffAPISnapshots(APISnapshot{Entries: base}, APISnapshot{Entries: tt.new})
+			changes := make([]string, 0)
+			for _, change := range diff.Changes {
+				changes = append(changes, fmt.Sprintf("%s %s %v", change.Change, change.Name, change.Breaking))
+			}
+			if len(tt.changes) == 0 {
+				tt.changes = []string{}
+			}
+			if !reflect.DeepEqual(changes, tt.changes) || diff.Bump != tt.bump {
+				t.Errorf("DiffAPISnapshots() = %v, bump %s, want %v, bump %s", changes, diff.Bump, tt.changes, tt.bump)
+			}
+		})
+	}
+}
+
+func TestBuildAPISnapshotTypeParams(t *testing.T) {
+	opts := AnalysisOptions{
+		InterfaceSources: NewSourceItemsCollector[GoInterface](),
+		StructSources:    NewSourceItemsCollector[GoStruct](),
+	}
+	opts.InterfaceSources.AddResult(SourceItems[GoInterface]{File: "lib/set.go", Dir: "lib", Package: "lib", Items: []GoInterface{
+		{Name: "Set", TypeParams: []string{"T comparable"}, Methods: []string{"Add(T) bool"}},
+	}})
+	opts.StructSources.AddResult(SourceItems[GoStruct]{File: "lib/set.go", Dir: "lib", Package: "lib", Items: []GoStruct{
+		{Name: "Pair", TypeParams: []string{"K comparable", "V any"}},
+	}})
+
+	want := []APIEntry{
+		{Package: "lib", Kind: APIKindInterface, Name: "Set", Signature: "T comparable"},
+		{Package: "lib", Kind: APIKindInterfaceMethod, Name: "Set.Add", Signature: "(T) bool"},
+		{Package: "lib", Kind: APIKindStruct, Name: "Pair", Signature: "K comparable, V any"},
+	}
+	if got := BuildAPISnapshot(opts, NewSuffixPackageResolver()).Entries; !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildAPISnapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildDeclarationSnapshotSkipsBlank(t *testing.T) {
+	opts := AnalysisOptions{
+		StructSources:   NewSourceItemsCollector[GoStruct](),
+		VariableSources: NewSourceItemsCollector[GoVariable](),
+	}
+	opts.StructSources.AddResult(SourceItems[GoStruct]{File: "lib/lib.go", Dir: "lib", Package: "lib", Items: []GoStruct{
+		{Name: "header", Fields: []string{"size int", "_ [4]byte"}},
+	}})
+	opts.VariableSources.AddResult(SourceItems[GoVariable]{File: "lib/lib.go", Dir: "lib", Package: "lib", Items: []GoVariable{
+		{Name: "_", Type: "io.Reader"},
+		{Name: "_", Type: "inferred"},
+		{Name: "debug", Type: "bool"},
+	}})
+
+	want := []APIEntry{
+		{Package: "lib", Kind: APIKindField, Name: "header.size", Signature: "int"},
+		{Package: "lib", Kind: APIKindStruct, Name: "header"},
+		{Package: "lib", Kind: APIKindVar, Name: "debug", Signature: "bool"},
+	}
+	if got := buildDeclarationSnapshot(opts, NewSuffixPackageResolver(), false).Entries; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildDeclarationSnapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildAPISnapshotNamedTypes(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod": "module example.com/lib\n\ngo 1.21\n",
+		"lib.go": `package lib
+
+import "io"
+
+type ID string
+
+type HandlerFunc func(w io.Writer, id ID) error
+
+type Reader = io.Reader
+
+type List[T any] []T
+
+type Options struct {
+	Logger interface{ Log(string) }
+	Limits struct{ Max int }
+	Events <-chan ID
+}
+
+type Source interface {
+	Watch(done chan<- struct{}) error
+}
+
+type internalID int
+`,
+	})
+	opts, resolver := analyzeTestModule(t, root, "structs", "interfaces", "types")
+
+	want := []APIEntry{
+		{Package: "example.com/lib", Kind: APIKindField, Name: "Options.Events", Signature: "<-chan ID"},
+		{Package: "example.com/lib", Kind: APIKindField, Name: "Options.Limits", Signature: "struct{Max int}"},
+		{Package: "example.com/lib", Kind: APIKindField, Name: "Options.Logger", Signature: "interface{Log(string)}"},
+		{Package: "example.com/lib", Kind: APIKindInterface, Name: "Source", Signature: ""},
+		{Package: "example.com/lib", Kind: APIKindInterfaceMethod, Name: "Source.Watch", Signature: "(chan<- struct{}) error"},
+		{Package: "example.com/lib", Kind: APIKindStruct, Name: "Options", Signature: ""},
+		{Package: "example.com/lib", Kind: APIKindType, Name: "HandlerFunc", Signature: "func(io.Writer, ID) error"},
+		{Package: "example.com/lib", Kind: APIKindType, Name: "ID", Signature: "string"},
+		{Package: "example.com/lib", Kind: APIKindType, Name: "List", Signature: "[T any] []T"},
+		{Package: "example.com/lib", Kind: APIKindType, Name: "Reader", Signature: "= io.Reader"},
+	}
+	if got := BuildAPISnapshot(opts, resolver).Entries; !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildAPISnapshot() =\n%v\nwant\n%v", got, want)
+	}
+
+	changed := BuildAPISnapshot(opts, resolver)
+	for i, entry := range changed.Entries {
+		if entry.Name == "ID" {
+			changed.Entries[i].Signature = "int"
+		}
+	}
+	diff := DiffAPISnapshots(BuildAPISnapshot(opts, resolver), changed)
+	if len(diff.Changes) != 1 || diff.Changes[0].Name != "ID" || diff.Changes[0].Change != "changed" || diff.Bump != SemverMajor {
+		t.Errorf("DiffAPISnapshots() = %+v, want ID changed and a major bump", diff)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ArchitectureSnapshot is what astro diff compares between revisions: all
+// declarations, the analyzed packages of the import graph and its cycles.
+type ArchitectureSnapshot struct {
+	Declarations APISnapshot
+	Packages     map[string]GoPackage
+	Cycles       map[string][]string
+}
+
+// runGit runs git in dir and returns its trimmed output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// RevisionScope is what astro diff analyzes in every revision: directories
+// and patterns relative to the working directory, which is found at the
+// same place in each revision's tree.
+type RevisionScope struct {
+	top      string
+	prefix   string
+	dirs     []string
+	patterns []string
+}
+
+// NewRevisionScope locates dirs and the local patterns, relative to the
+// working directory or absolute, in the repository the working directory
+// is in. Paths outside of the repository are rejected.
+func NewRevisionScope(dirs, patterns []string) (*RevisionScope, error) {
+	top, err := runGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := runGit(".", "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &RevisionScope{top: top, prefix: prefix, dirs: make([]string, 0, len(dirs)), patterns: make([]string, 0, len(patterns))}
+	for _, dir := range dirs {
+		rel, err := scope.relative(dir)
+		if err != nil {
+			return nil, err
+		}
+		scope.dirs = append(scope.dirs, rel)
+	}
+	for _, pattern := range patterns {
+		base, recursive := splitPattern(pattern)
+		if !isLocalPattern(base) {
+			scope.patterns = append(scope.patterns, pattern)
+			continue
+		}
+		rel, err := scope.relative(base)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(rel, "..") {
+			rel = "./" + rel
+		}
+		if recursive {
+			rel = strings.TrimSuffix(rel, "/.") + "/..."
+		}
+		scope.patterns = append(scope.patterns, rel)
+	}
+	return scope, nil
+}
+
+// relative returns path relative to the working directory, with slashes,
+// if it is in the repository.
+func (rs *RevisionScope) relative(path string) (string, error) {
+	wd := filepath.Join(rs.top, filepath.FromSlash(rs.prefix))
+	abs := filepath.Join(wd, path)
+	if filepath.IsAbs(path) {
+		// The top-level directory has its symbolic links resolved
+		abs = filepath.Clean(path)
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+	}
+	if _, ok := relativeTo(rs.top, abs); !ok {
+		return "", fmt.Errorf("%s is outside the repository %s", path, rs.top)
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// extractRevision writes the tree at rev of the repository whose top-level
+// directory is top to a new temporary directory with git archive.
+func extractRevision(top, rev string) (string, error) {
+	if _, err := runGit(top, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return "", fmt.Errorf("unknown revision %s", rev)
+	}
+
+	root, err := os.MkdirTemp("", "astro-diff-")
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("git", "archive", "--format=tar", rev)
+	cmd.Dir = top
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(root)
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(root)
+		return "", err
+	}
+
+	extractErr := extractTar(stdout, root)
+	if err := cmd.Wait(); err != nil && extractErr == nil {
+		extractErr = fmt.Errorf("git archive %s: %v: %s", rev, err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		os.RemoveAll(root)
+		return "", extractErr
+	}
+	return root, nil
+}
+
+// extractTar writes the directories and regular files of a tar stream
+// below root. Links are skipped.
+func extractTar(r io.Reader, root string) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		if _, ok := relativeTo(root, target); !ok {
+			return fmt.Errorf("archive entry %s is outside the tree", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, reader)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// AnalyzeRevision runs the analysis of scope on the tree at rev.
+func AnalyzeRevision(ctx context.Context, rev string, scope *RevisionScope, base AnalysisOptions) (*ArchitectureSnapshot, error) {
+	root, err := extractRevision(scope.top, rev)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+	return analyzeArchitecture(ctx, filepath.Join(root, filepath.FromSlash(scope.prefix)), scope.dirs, scope.patterns, base)
+}
+
+// analyzeArchitecture analyzes dirs and patterns relative to workDir, the
+// working directory of the analysis.
+func analyzeArchitecture(ctx context.Context, workDir string, dirs, patterns []string, base AnalysisOptions) (*ArchitectureSnapshot, error) {
+	local := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		local = append(local, filepath.Join(workDir, filepath.FromSlash(dir)))
+	}
+	localPatterns := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if base, _ := splitPattern(pattern); isLocalPattern(base) {
+			pattern = filepath.Join(workDir, filepath.FromSlash(pattern))
+		}
+		localPatterns = append(localPatterns, pattern)
+	}
+
+	// Modules are looked up within the tree analyzed
+	resolver, err := NewPackageResolver(workspaceRoots(local, localPatterns))
+	if err != nil {
+		return nil, err
+	}
+	// Selection patterns and directory-based import paths are relative to
+	// workDir, so they are the same for every revision
+	if suffix, ok := resolver.(*SuffixPackageResolver); ok {
+		suffix.base = workDir
+	}
+	opts := base
+	if opts.Files != nil {
+		opts.Files = opts.Files.RelativeTo(workDir)
+	}
+
+	if len(patterns) > 0 {
+		local = make([]string, 0)
+		for i, pattern := range localPatterns {
+			expanded, err := expandPatterns([]string{pattern}, resolver)
+			if err != nil {
+				return nil, err
+			}
+			packages := 0
+			for _, dir := range expanded {
+				if hasGoFiles(dir) {
+					packages++
+					local = appendUnique(local, dir)
+				}
+			}
+			if packages == 0 {
+				return nil, fmt.Errorf("pattern %s matches no packages", patterns[i])
+			}
+		}
+		sort.Strings(local)
+		opts.NonRecursive = true
+	}
+
+	opts.SelectedTypes = map[string]bool{
+		"structs":    true,
+		"interfaces": true,
+		"functions":  true,
+		"variables":  true,
+		"constants":  true,
+	}
+	opts.UseTopologicalSort = true
+	opts.FunctionSort = SortByDependency
+	opts.StructSources = NewSourceItemsCollector[GoStruct]()
+	opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	opts.VariableSources = NewSourceItemsCollector[GoVariable]()
+	opts.ConstantSources = NewSourceItemsCollector[GoConstant]()
+	opts.Output = io.Discard
+
+	graph, err := BuildImportGraph(local, opts, resolver)
+	if err != nil {
+		return nil, err
+	}
+	opts.Graph = graph
+
+	for _, dir := range local {
+		if err := walkDirectory(ctx, dir, opts); err != nil {
+			if rel, ok := relativeTo(workDir, dir); ok {
+				dir = rel
+			}
+			return nil, fmt.Errorf("analyzing %s: %v", dir, err)
+		}
+	}
+
+	return newArchitectureSnapshot(opts, resolver), nil
+}
+
+// newArchitectureSnapshot records the declarations collected in opts and
+// the packages and cycles of opts.Graph.
+func newArchitectureSnapshot(opts AnalysisOptions, resolver PackageResolver) *ArchitectureSnapshot {
+	snapshot := &ArchitectureSnapshot{
+		Declarations: buildDeclarationSnapshot(opts, resolver, false),
+		Packages:     make(map[string]GoPackage),
+		Cycles:       make(map[string][]string),
+	}
+	for _, pkg := range opts.Graph.Sorted() {
+		if pkg.Dir != "" {
+			snapshot.Packages[pkg.ImportPath] = pkg
+		}
+	}
+	for _, cycle := range opts.Graph.Cycles() {
+		snapshot.Cycles[cycleKey(cycle)] = cycle
+	}
+	return snapshot
+}
+
+func cycleKey(cycle []string) string {
+	members := append([]string{}, cycle...)
+	sort.Strings(members)
+	return strings.Join(members, " ")
+}
+
+type DependencyChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type LevelChange struct {
+	Package string `json:"package"`
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+}
+
+type ArchitectureDiff struct {
+	From            string             `json:"from"`
+	To              string             `json:"to"`
+	Declarations    []APIChange        `json:"declarations"`
+	NewEdges        []DependencyChange `json:"newEdges"`
+	RemovedEdges    []DependencyChange `json:"removedEdges"`
+	LevelChanges    []LevelChange      `json:"levelChanges"`
+	NewCycles       [][]string         `json:"newCycles"`
+	ResolvedCycles  [][]string         `json:"resolvedCycles"`
+	AddedPackages   []string           `json:"addedPackages"`
+	RemovedPackages []string           `json:"removedPackages"`
+}
+
+// DiffArchitecture compares two snapshots. Level changes are only
+// reported for packages present in both.
+func DiffArchitecture(from, to string, old, new *ArchitectureSnapshot) ArchitectureDiff {
+	diff := ArchitectureDiff{
+		From:            from,
+		To:              to,
+		Declarations:    DiffAPISnapshots(old.Declarations, new.Declarations).Changes,
+		NewEdges:        make([]DependencyChange, 0),
+		RemovedEdges:    make([]DependencyChange, 0),
+		LevelChanges:    make([]LevelChange, 0),
+		NewCycles:       make([][]string, 0),
+		ResolvedCycles:  make([][]string, 0),
+		AddedPackages:   make([]string, 0),
+		RemovedPackages: make([]string, 0),
+	}
+	// Whether a change would break users doesn't matter here
+	for i := range diff.Declarations {
+		diff.Declarations[i].Breaking = false
+	}
+	sort.SliceStable(diff.Declarations, func(i, j int) bool {
+		a, b := diff.Declarations[i], diff.Declarations[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Name < b.Name
+	})
+
+	edges := func(snapshot *ArchitectureSnapshot) map[DependencyChange]bool {
+		set := make(map[DependencyChange]bool)
+		for _, pkg := range snapshot.Packages {
+			for _, dep := range pkg.Imports {
+				set[DependencyChange{From: pkg.ImportPath, To: dep}] = true
+			}
+		}
+		return set
+	}
+	oldEdges, newEdges := edges(old), edges(new)
+	for edge := range newEdges {
+		if !oldEdges[edge] {
+			diff.NewEdges = append(diff.NewEdges, edge)
+		}
+	}
+	for edge := range oldEdges {
+		if !newEdges[edge] {
+			diff.RemovedEdges = append(diff.RemovedEdges, edge)
+		}
+	}
+	sortDependencyChanges(diff.NewEdges)
+	sortDependencyChanges(diff.RemovedEdges)
+
+	for path, pkg := range new.Packages {
+		previous, ok := old.Packages[path]
+		if !ok {
+			diff.AddedPackages = append(diff.AddedPackages, path)
+			continue
+		}
+		if previous.Level != pkg.Level {
+			diff.LevelChanges = append(diff.LevelChanges, LevelChange{Package: path, From: previous.Level, To: pkg.Level})
+		}
+	}
+	for path := range old.Packages {
+		if _, ok := new.Packages[path]; !ok {
+			diff.RemovedPackages = append(diff.RemovedPackages, path)
+		}
+	}
+	sort.Strings(diff.AddedPackages)
+	sort.Strings(diff.RemovedPackages)
+	sort.Slice(diff.LevelChanges, func(i, j int) bool {
+		return diff.LevelChanges[i].Package < diff.LevelChanges[j].Package
+	})
+
+	for key, cycle := range new.Cycles {
+		if _, ok := old.Cycles[key]; !ok {
+			diff.NewCycles = append(diff.NewCycles, cycle)
+		}
+	}
+	for key, cycle := range old.Cycles {
+		if _, ok := new.Cycles[key]; !ok {
+			diff.ResolvedCycles = append(diff.ResolvedCycles, cycle)
+		}
+	}
+	sortCycles(diff.NewCycles)
+	sortCycles(diff.ResolvedCycles)
+	return diff
+}
+
+func sortDependencyChanges(changes []DependencyChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].From != changes[j].From {
+			return changes[i].From < changes[j].From
+		}
+		return changes[i].To < changes[j].To
+	})
+}
+
+func sortCycles(cycles [][]string) {
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycleKey(cycles[i]) < cycleKey(cycles[j])
+	})
+}
+
+func WriteArchitectureDiff(w io.Writer, diff ArchitectureDiff, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(diff)
+	case "text", "":
+		fmt.Fprintf(w, "--- Architecture Diff (%s..%s) ---\n", diff.From, diff.To)
+
+		added, removed, changed := 0, 0, 0
+		if len(diff.Declarations) > 0 {
+			fmt.Fprintln(w, "Declarations:")
+		}
+		for _, change := range diff.Declarations {
+			switch change.Change {
+			case "added":
+				added++
+				fmt.Fprintf(w, "  + %s: %s\n", change.Package, describeAPIEntry(change.Kind, change.Name, change.New))
+			case "removed":
+				removed++
+				fmt.Fprintf(w, "  - %s: %s\n", change.Package, describeAPIEntry(change.Kind, change.Name, change.Old))
+			case "changed":
+				changed++
+				fmt.Fprintf(w, "  ~ %s: %s %s: %s -> %s\n", change.Package, change.Kind, change.Name, change.Old, change.New)
+			}
+		}
+
+		if len(diff.AddedPackages)+len(diff.RemovedPackages) > 0 {
+			fmt.Fprintln(w, "Packages:")
+			for _, path := range diff.AddedPackages {
+				fmt.Fprintf(w, "  + %s\n", path)
+			}
+			for _, path := range diff.RemovedPackages {
+				fmt.Fprintf(w, "  - %s\n", path)
+			}
+		}
+
+		if len(diff.NewEdges)+len(diff.RemovedEdges) > 0 {
+			fmt.Fprintln(w, "Dependencies:")
+			for _, edge := range diff.NewEdges {
+				fmt.Fprintf(w, "  + %s -> %s\n", edge.From, edge.To)
+			}
+			for _, edge := range diff.RemovedEdges {
+				fmt.Fprintf(w, "  - %s -> %s\n", edge.From, edge.To)
+			}
+		}
+
+		if len(diff.LevelChanges) > 0 {
+			fmt.Fprintln(w, "Levels:")
+			for _, change := range diff.LevelChanges {
+				fmt.Fprintf(w, "  %s: %d -> %d\n", change.Package, change.From, change.To)
+			}
+		}
+
+		if len(diff.NewCycles) > 0 {
+			fmt.Fprintln(w, "New cycles:")
+			for _, cycle := range diff.NewCycles {
+				fmt.Fprintf(w, "  among %s\n", strings.Join(cycle, ", "))
+			}
+		}
+		if len(diff.ResolvedCycles) > 0 {
+			fmt.Fprintln(w, "Resolved cycles:")
+			for _, cycle := range diff.ResolvedCycles {
+				fmt.Fprintf(w, "  among %s\n", strings.Join(cycle, ", "))
+			}
+		}
+
+		fmt.Fprintf(w, "%d added, %d removed, %d changed declaration(s); %d new, %d removed dependency edge(s); %d level change(s); %d new cycle(s)\n",
+			added, removed, changed, len(diff.NewEdges), len(diff.RemovedEdges), len(diff.LevelChanges), len(diff.NewCycles))
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
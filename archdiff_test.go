@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// commitTestRepository writes each set of files over the previous one in a
+// new git repository, committing after each.
+func commitTestRepository(t *testing.T, commits ...map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=astro", "-c", "user.email=astro@example.com"}, args...)...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "-q")
+	for _, files := range commits {
+		for name, content := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", "commit")
+	}
+	return root
+}
+
+func TestAnalyzeRevision(t *testing.T) {
+	repo := commitTestRepository(t,
+		map[string]string{
+			"go.mod":         "module example.com/r\n",
+			"docs/README":    "docs\n",
+			"store/store.go": "package store\n\ntype Store interface{ Get() }\n",
+			"app/app.go":     "package app\n\nfunc Run() {}\n",
+		},
+		map[string]string{
+			"app/app.go": "package app\n\nimport \"example.com/r/store\"\n\nfunc Run(s store.Store) {}\n",
+		},
+	)
+	// Uncommitted changes are not part of either revision
+	if err := os.WriteFile(filepath.Join(repo, "app", "extra.go"), []byte("package app\n\nfunc Extra() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name     string
+		wd       string
+		dirs     []string
+		patterns []string
+		err      string
+	}{
+		{name: "working directory", wd: ".", dirs: []string{"."}},
+		{name: "absolute directory", wd: ".", dirs: []string{repo}},
+		{name: "directory from a subdirectory", wd: "docs", dirs: []string{".."}},
+		{name: "patterns", wd: ".", patterns: []string{"./...", "example.com/r/store"}},
+		{name: "patterns from a subdirectory", wd: "store", patterns: []string{"../app", "."}},
+		{name: "absolute pattern", wd: ".", patterns: []string{repo + "/..."}},
+		{name: "outside the repository", wd: ".", dirs: []string{filepath.Dir(repo)}, err: "is outside the repository"},
+		{name: "pattern outside the repository", wd: "app", patterns: []string{"../../..."}, err: "is outside the repository"},
+		{name: "pattern without packages", wd: ".", patterns: []string{"./docs"}, err: "pattern ./docs matches no packages"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chdir(filepath.Join(repo, tt.wd)); err != nil {
+				t.Fatal(err)
+			}
+			scope, err := NewRevisionScope(tt.dirs, tt.patterns)
+			var old, current *ArchitectureSnapshot
+			if err == nil {
+				old, err = AnalyzeRevision(context.Background(), "HEAD~1", scope, AnalysisOptions{})
+			}
+			if err == nil {
+				current, err = AnalyzeRevision(context.Background(), "HEAD", scope, AnalysisOptions{})
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := DiffArchitecture("HEAD~1", "HEAD", old, current)
+			if want := []DependencyChange{{From: "example.com/r/app", To: "example.com/r/store"}}; !reflect.DeepEqual(diff.NewEdges, want) {
+				t.Errorf("new edges %v, want %v", diff.NewEdges, want)
+			}
+			if len(diff.Declarations) != 1 || diff.Declarations[0].Name != "Run" || diff.Declarations[0].Change != "changed" {
+				t.Errorf("declaration changes %+v, want Run changed", diff.Declarations)
+			}
+		})
+	}
+}
+
+func TestAnalyzeRevisionWithoutModule(t *testing.T) {
+	repo := commitTestRepository(t,
+		map[string]string{"src/store/store.go": "package store\n\ntype Store struct{}\n"},
+		map[string]string{"src/store/store.go": "package store\n\ntype Store struct{ ID int }\n"},
+	)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Join(repo, "src")); err != nil {
+		t.Fatal(err)
+	}
+
+	scope, err := NewRevisionScope([]string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := make([]*ArchitectureSnapshot, 0, 2)
+	for _, rev := range []string{"HEAD~1", "HEAD"} {
+		snapshot, err := AnalyzeRevision(context.Background(), rev, scope, AnalysisOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Directory-based import paths are relative to the working directory
+	// in every extracted tree
+	diff := DiffArchitecture("HEAD~1", "HEAD", snapshots[0], snapshots[1])
+	if len(diff.AddedPackages)+len(diff.RemovedPackages) != 0 || len(diff.Declarations) != 1 || diff.Declarations[0].Package != "store" {
+		t.Errorf("diff %+v, want only store.Store changed", diff)
+	}
+}
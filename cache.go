@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cacheFormat changes whenever FileDeclarations or what the visitors
+// extract changes, so entries written by other builds are never read.
+const cacheFormat = 3
+
+// FileDeclarations is what processFile extracts from one file. Kinds lists
+// the declaration kinds extracted; imports are always present. Positions
+// are stored as line:column and refer to the file they are loaded for.
+type FileDeclarations struct {
+	Package    string        `json:"package"`
+	Kinds      []string      `json:"kinds"`
+	Imports    []GoImport    `json:"imports"`
+	Structs    []GoStruct    `json:"structs,omitempty"`
+	Interfaces []GoInterface `json:"interfaces,omitempty"`
+	Functions  []GoFunction  `json:"functions,omitempty"`
+	Variables  []GoVariable  `json:"variables,omitempty"`
+	Constants  []GoConstant  `json:"constants,omitempty"`
+	Types      []GoType      `json:"types,omitempty"`
+}
+
+// Has reports whether every selected kind was extracted.
+func (fd *FileDeclarations) Has(selected map[string]bool) bool {
+	for kind, ok := range selected {
+		if ok && !containsString(fd.Kinds, kind) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AnalysisCache stores FileDeclarations on disk keyed by a hash of the file
+// content, the Go version and the astro build, so unchanged files are not
+// parsed again. Entries hold no paths and are shared by identical files.
+type AnalysisCache struct {
+	dir      string
+	salt     string
+	warnOnce sync.Once
+}
+
+// DefaultCacheDir is astro's directory in the user cache directory.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "astro")
+}
+
+func OpenAnalysisCache(dir string) (*AnalysisCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	version, err := astroVersion()
+	if err != nil {
+		return nil, err
+	}
+	return &AnalysisCache{
+		dir:  dir,
+		salt: cacheSalt(runtime.Version(), version),
+	}, nil
+}
+
+// cacheSalt is hashed into every key, so entries written with another
+// cache format, Go version or astro build are never read. Analysis options
+// are not part of it: sorting and filtering happen after loading.
+func cacheSalt(goVersion, build string) string {
+	return fmt.Sprintf("astro-cache %d\n%s\n%s\n", cacheFormat, goVersion, build)
+}
+
+// ClearAnalysisCache removes dir and everything cached in it.
+func ClearAnalysisCache(dir string) error {
+	if dir == "" || dir == "/" {
+		return fmt.Errorf("refusing to clear cache directory %q", dir)
+	}
+	return os.RemoveAll(dir)
+}
+
+// astroVersion identifies the running build: its module version and VCS
+// revision, or a hash of the executable for builds with local changes.
+func astroVersion() (string, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return executableHash()
+	}
+
+	version := info.Main.Version
+	modified := strings.HasSuffix(version, "+dirty") || version == "(devel)" || version == ""
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version += " " + setting.Value
+		case "vcs.modified":
+			modified, _ = strconv.ParseBool(setting.Value)
+		}
+	}
+	if modified {
+		return executableHash()
+	}
+	return version, nil
+}
+
+func executableHash() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to identify astro build: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to identify astro build: %v", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to identify astro build: %v", err)
+	}
+	return "executable " + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (ac *AnalysisCache) path(content []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(ac.salt))
+	hash.Write(content)
+	key := hex.EncodeToString(hash.Sum(nil))
+	return filepath.Join(ac.dir, key[:2], key+".json")
+}
+
+// Load returns the declarations cached for content, with positions in
+// filename, or nil. Unreadable entries are misses.
+func (ac *AnalysisCache) Load(filename string, content []byte) *FileDeclarations {
+	data, err := os.ReadFile(ac.path(content))
+	if err != nil {
+		return nil
+	}
+	var decls FileDeclarations
+	if err := json.Unmarshal(data, &decls); err != nil {
+		return nil
+	}
+	decls.relocate(func(position string) string {
+		return filename + ":" + position
+	})
+	return &decls
+}
+
+// Store caches decls, extracted from filename, for content. Entries are
+// written to a temporary file and renamed, so concurrent runs and workers
+// never see partial entries.
+func (ac *AnalysisCache) Store(filename string, content []byte, decls *FileDeclarations) error {
+	stored := *decls
+	stored.relocate(func(position string) string {
+		return strings.TrimPrefix(position, filename+":")
+	})
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	path := ac.path(content)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Warn reports a failure to write the cache, once per run; the analysis
+// itself is unaffected.
+func (ac *AnalysisCache) Warn(err error) {
+	ac.warnOnce.Do(func() {
+		log.Printf("Failed to write analysis cache: %v", err)
+	})
+}
+
+// relocate rewrites every position, copying the slices so decls can be
+// rewritten without touching the slices it was copied from.
+func (fd *FileDeclarations) relocate(move func(string) string) {
+	fd.Imports = append([]GoImport(nil), fd.Imports...)
+	for i := range fd.Imports {
+		fd.Imports[i].Position = move(fd.Imports[i].Position)
+	}
+	fd.Structs = append([]GoStruct(nil), fd.Structs...)
+	for i := range fd.Structs {
+		fd.Structs[i].Position = move(fd.Structs[i].Position)
+	}
+	fd.Interfaces = append([]GoInterface(nil), fd.Interfaces...)
+	for i := range fd.Interfaces {
+		fd.Interfaces[i].Position = move(fd.Interfaces[i].Position)
+	}
+	fd.Functions = append([]GoFunction(nil), fd.Functions...)
+	for i := range fd.Functions {
+		fd.Functions[i].Position = move(fd.Functions[i].Position)
+	}
+	fd.Variables = append([]GoVariable(nil), fd.Variables...)
+	for i := range fd.Variables {
+		fd.Variables[i].Position = move(fd.Variables[i].Position)
+	}
+	fd.Constants = append([]GoConstant(nil), fd.Constants...)
+	for i := range fd.Constants {
+		fd.Constants[i].Position = move(fd.Constants[i].Position)
+	}
+	fd.Types = append([]GoType(nil), fd.Types...)
+	for i := range fd.Types {
+		fd.Types[i].Position = move(fd.Types[i].Position)
+	}
+}
+
+// classifyImports sets the kind of imports loaded from the cache, which
+// depends on the packages analyzed in this run.
+func classifyImports(imports []GoImport, graph *ImportGraph) {
+	for i := range imports {
+		imports[i].Kind = ""
+		if graph != nil {
+			if unquoted, err := strconv.Unquote(imports[i].Path); err == nil {
+				imports[i].Kind = graph.Kind(unquoted)
+			}
+		}
+	}
+}
+
+// extractFrom records the results of the engines, keeping kinds extracted
+// by earlier runs that no engine ran for.
+func (fd *FileDeclarations) extractFrom(engines map[string]interface{}) {
+	if engine, ok := engines["structs"].(*AnalysisEngine[GoStruct]); ok {
+		fd.Structs = engine.Results()
+	}
+	if engine, ok := engines["interfaces"].(*AnalysisEngine[GoInterface]); ok {
+		fd.Interfaces = engine.Results()
+	}
+	if engine, ok := engines["functions"].(*AnalysisEngine[GoFunction]); ok {
+		fd.Functions = engine.Results()
+	}
+	if engine, ok := engines["variables"].(*AnalysisEngine[GoVariable]); ok {
+		fd.Variables = engine.Results()
+	}
+	if engine, ok := engines["constants"].(*AnalysisEngine[GoConstant]); ok {
+		fd.Constants = engine.Results()
+	}
+	if engine, ok := engines["types"].(*AnalysisEngine[GoType]); ok {
+		fd.Types = engine.Results()
+	}
+	for kind := range engines {
+		if !containsString(fd.Kinds, kind) {
+			fd.Kinds = append(fd.Kinds, kind)
+		}
+	}
+	if !containsString(fd.Kinds, "imports") {
+		fd.Kinds = append(fd.Kinds, "imports")
+	}
+	sort.Strings(fd.Kinds)
+}
+
+func (fd *FileDeclarations) loadInto(engines map[string]interface{}) {
+	if engine, ok := engines["structs"].(*AnalysisEngine[GoStruct]); ok {
+		engine.Load(fd.Structs)
+	}
+	if engine, ok := engines["interfaces"].(*AnalysisEngine[GoInterface]); ok {
+		engine.Load(fd.Interfaces)
+	}
+	if engine, ok := engines["functions"].(*AnalysisEngine[GoFunction]); ok {
+		engine.Load(fd.Functions)
+	}
+	if engine, ok := engines["variables"].(*AnalysisEngine[GoVariable]); ok {
+		engine.Load(fd.Variables)
+	}
+	if engine, ok := engines["constants"].(*AnalysisEngine[GoConstant]); ok {
+		engine.Load(fd.Constants)
+	}
+	if engine, ok := engines["types"].(*AnalysisEngine[GoType]); ok {
+		engine.Load(fd.Types)
+	}
+	if engine, ok := engines["imports"].(*AnalysisEngine[GoImport]); ok {
+		engine.Load(fd.Imports)
+	}
+}
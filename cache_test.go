@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const cachedSource = `package store
+
+import "fmt"
+
+type ID string
+
+type Store interface {
+	Get(id ID) (string, error)
+}
+
+type Memory struct {
+	items map[ID]string
+}
+
+func (m *Memory) Get(id ID) (string, error) {
+	if item, ok := m.items[id]; ok {
+		return item, nil
+	}
+	return "", fmt.Errorf("%s not found", id)
+}
+
+var Default = &Memory{}
+
+const Limit = 10
+`
+
+// analyzeCachedFile runs processFile on filename with every kind selected
+// and returns the collected results.
+func analyzeCachedFile(t *testing.T, filename string, cache *AnalysisCache, topological bool) AnalysisOptions {
+	t.Helper()
+	opts := AnalysisOptions{SelectedTypes: allKinds(), UseTopologicalSort: topological, FunctionSort: SortByDependency, Cache: cache, Output: io.Discard}
+	opts.ensureCollectors("structs", "interfaces", "functions", "variables", "constants", "types")
+	if err := processFile(filename, opts); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func collectedItems(opts AnalysisOptions) []interface{} {
+	return []interface{}{
+		opts.StructSources.CollectResults(),
+		opts.InterfaceSources.CollectResults(),
+		opts.FunctionSources.CollectResults(),
+		opts.VariableSources.CollectResults(),
+		opts.ConstantSources.CollectResults(),
+		opts.TypeSources.CollectResults(),
+	}
+}
+
+func writeCachedSource(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacheEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAnalysisCacheMovedFile(t *testing.T) {
+	root := t.TempDir()
+	cache, err := OpenAnalysisCache(filepath.Join(root, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := filepath.Join(root, "store", "store.go")
+	moved := filepath.Join(root, "internal", "memory", "renamed.go")
+	writeCachedSource(t, original, cachedSource)
+	writeCachedSource(t, moved, cachedSource)
+
+	analyzeCachedFile(t, original, cache, true)
+	if entries := cacheEntries(t, cache.dir); len(entries) != 1 {
+		t.Fatalf("cache holds %v, want one entry", entries)
+	}
+
+	// The entry stored for the original file is loaded for the moved one,
+	// with positions in the moved file
+	decls := cache.Load(moved, []byte(cachedSource))
+	if decls == nil {
+		t.Fatal("Load() of the moved file missed")
+	}
+	for _, fn := range decls.Functions {
+		if !strings.HasPrefix(fn.Position, moved+":") {
+			t.Errorf("function %s at %s, want a position in %s", fn.Name, fn.Position, moved)
+		}
+	}
+
+	for _, topological := range []bool{true, false} {
+		cached := analyzeCachedFile(t, moved, cache, topological)
+		parsed := analyzeCachedFile(t, moved, nil, topological)
+		if got, want := collectedItems(cached), collectedItems(parsed); !reflect.DeepEqual(got, want) {
+			t.Errorf("topological %v: cached results\n%v\nwant\n%v", topological, got, want)
+		}
+	}
+	if entries := cacheEntries(t, cache.dir); len(entries) != 1 {
+		t.Errorf("cache holds %v after the hits, want one entry", entries)
+	}
+}
+
+func TestCacheSalt(t *testing.T) {
+	base := cacheSalt("go1.21.1", "v1.0.0 abc")
+	for name, salt := range map[string]string{
+		"Go version":  cacheSalt("go1.22.0", "v1.0.0 abc"),
+		"astro build": cacheSalt("go1.21.1", "executable 0123"),
+	} {
+		if salt == base {
+			t.Errorf("salt unchanged with another %s", name)
+		}
+	}
+
+	dir := t.TempDir()
+	content := []byte(cachedSource)
+	a := &AnalysisCache{dir: dir, salt: base}
+	b := &AnalysisCache{dir: dir, salt: cacheSalt("go1.22.0", "v1.0.0 abc")}
+	if a.path(content) == b.path(content) {
+		t.Error("entries of different Go versions share a path")
+	}
+	if err := a.Store("x.go", content, &FileDeclarations{Package: "store", Kinds: []string{"imports"}}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Load("x.go", content) != nil {
+		t.Error("entry written by another Go version was read")
+	}
+}
+
+func TestAnalysisCacheCorruptEntry(t *testing.T) {
+	root := t.TempDir()
+	cache, err := OpenAnalysisCache(filepath.Join(root, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "store.go")
+	writeCachedSource(t, file, cachedSource)
+
+	path := cache.path([]byte(cachedSource))
+	for name, data := range map[string]string{
+		"truncated": `{"package":"store","kinds":["structs"`,
+		"garbage":   "\x00\x01not json",
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeCachedSource(t, path, data)
+			got := analyzeCachedFile(t, file, cache, true)
+			want := analyzeCachedFile(t, file, nil, true)
+			if !reflect.DeepEqual(collectedItems(got), collectedItems(want)) {
+				t.Errorf("results with a corrupt entry\n%v\nwant\n%v", collectedItems(got), collectedItems(want))
+			}
+			// Parsing replaced the entry
+			if cache.Load(file, []byte(cachedSource)) == nil {
+				t.Error("corrupt entry was not replaced")
+			}
+		})
+	}
+}
+
+func TestClearCache(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	stale := filepath.Join(dir, "ab", "stale.json")
+	writeCachedSource(t, stale, "{}")
+
+	newRun := func(args ...string) (*analysisRun, error) {
+		cfg := &cliConfig{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		addAnalyzeFlags(fs, cfg)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return newAnalysisRun(cfg)
+	}
+
+	run, err := newRun("-cache-dir", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); err != nil || run.opts.Cache == nil {
+		t.Fatalf("cache was cleared without -clear-cache: %v", err)
+	}
+
+	run, err = newRun("-cache-dir", dir, "-clear-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("-clear-cache kept %s: %v", stale, err)
+	}
+	if _, err := os.Stat(dir); err != nil || run.opts.Cache == nil {
+		t.Errorf("cache not reopened after clearing: %v", err)
+	}
+
+	if _, err := newRun("-cache-dir", "", "-clear-cache"); err == nil {
+		t.Error("-clear-cache with an empty -cache-dir succeeded")
+	}
+}
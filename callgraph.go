@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CallGraphNode is a function or method. Nodes of packages that were not
+// analyzed are external and have no position.
+type CallGraphNode struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Package  string `json:"package"`
+	Position string `json:"position,omitempty"`
+	External bool   `json:"external,omitempty"`
+}
+
+// CallEdge is a call site. Dynamic edges come from an interface method call
+// and point to the interface method and to each of its known
+// implementations.
+type CallEdge struct {
+	Caller   string `json:"caller"`
+	Callee   string `json:"callee"`
+	Position string `json:"position"`
+	Dynamic  bool   `json:"dynamic,omitempty"`
+}
+
+type CallGraph struct {
+	nodes   map[string]*CallGraphNode
+	callees map[string][]CallEdge
+	callers map[string][]CallEdge
+}
+
+func NewCallGraph() *CallGraph {
+	return &CallGraph{
+		nodes:   make(map[string]*CallGraphNode),
+		callees: make(map[string][]CallEdge),
+		callers: make(map[string][]CallEdge),
+	}
+}
+
+func (cg *CallGraph) addNode(node CallGraphNode) {
+	if existing, ok := cg.nodes[node.Key]; ok && !existing.External {
+		return
+	}
+	cg.nodes[node.Key] = &node
+}
+
+func (cg *CallGraph) addEdge(edge CallEdge) {
+	for _, existing := range cg.callees[edge.Caller] {
+		if existing == edge {
+			return
+		}
+	}
+	cg.callees[edge.Caller] = append(cg.callees[edge.Caller], edge)
+	cg.callers[edge.Callee] = append(cg.callers[edge.Callee], edge)
+}
+
+// BuildCallGraph resolves the calls in the function bodies of every
+// package under dirs with go/types. Calls whose target can't be typed, for
+// example because a dependency is missing, are resolved by name where
+// possible.
+func BuildCallGraph(ctx context.Context, dirs []string, graph *ImportGraph, opts AnalysisOptions, resolver PackageResolver) (*CallGraph, error) {
+	fset, ordered, err := loadTypedPackages(ctx, dirs, graph, opts, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &callGraphBuilder{
+		fset:     fset,
+		graph:    NewCallGraph(),
+		named:    namedTypes(ordered),
+		analyzed: make(map[*types.Package]bool),
+	}
+	for _, pkg := range ordered {
+		builder.analyzed[pkg.checked] = true
+	}
+	for _, pkg := range ordered {
+		builder.addPackage(pkg)
+	}
+	return builder.graph, nil
+}
+
+type callGraphBuilder struct {
+	fset     *token.FileSet
+	graph    *CallGraph
+	named    []*types.TypeName
+	analyzed map[*types.Package]bool
+}
+
+func (cgb *callGraphBuilder) addPackage(pkg *typedPackage) {
+	info, checked := pkg.info, pkg.checked
+	for _, file := range pkg.files {
+		imports := make(map[string]string)
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := importLocalName(GoImport{Path: spec.Path.Value})
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = path
+		}
+
+		// caller is only called once a call is found
+		addCalls := func(caller func() string, node ast.Node) {
+			ast.Inspect(node, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				position := cgb.fset.Position(call.Lparen).String()
+				for _, callee := range cgb.resolveCall(call, info, checked, imports) {
+					cgb.graph.addEdge(CallEdge{Caller: caller(), Callee: callee.key, Position: position, Dynamic: callee.dynamic})
+				}
+				return true
+			})
+		}
+
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				obj, ok := info.Defs[d.Name].(*types.Func)
+				if !ok {
+					continue
+				}
+				caller := cgb.addFunc(obj, false)
+				if d.Body != nil {
+					addCalls(func() string { return caller }, d.Body)
+				}
+			case *ast.GenDecl:
+				// Package-level variables are initialized by the package's
+				// init, which their initializers' calls are attributed to
+				if d.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range d.Specs {
+					spec := spec
+					for _, value := range spec.(*ast.ValueSpec).Values {
+						addCalls(func() string { return cgb.addPackageInit(checked, spec) }, value)
+					}
+				}
+			}
+		}
+	}
+}
+
+// addPackageInit registers the initializer of pkg and returns its key. Init
+// functions share the key, so the node is positioned at whichever of them
+// or of the variables calling something comes first.
+func (cgb *callGraphBuilder) addPackageInit(pkg *types.Package, spec ast.Spec) string {
+	key := pkg.Path() + ".init"
+	if _, ok := cgb.graph.nodes[key]; !ok {
+		cgb.graph.addNode(CallGraphNode{
+			Key:      key,
+			Name:     pkg.Name() + ".init",
+			Package:  pkg.Path(),
+			Position: cgb.fset.Position(spec.Pos()).String(),
+		})
+	}
+	return key
+}
+
+type resolvedCallee struct {
+	key     string
+	dynamic bool
+}
+
+func (cgb *callGraphBuilder) resolveCall(call *ast.CallExpr, info *types.Info, checked *types.Package, imports map[string]string) []resolvedCallee {
+	fun := call.Fun
+	for paren, ok := fun.(*ast.ParenExpr); ok; paren, ok = fun.(*ast.ParenExpr) {
+		fun = paren.X
+	}
+	if index, ok := fun.(*ast.IndexExpr); ok {
+		fun = index.X
+	} else if index, ok := fun.(*ast.IndexListExpr); ok {
+		fun = index.X
+	}
+
+	var ident *ast.Ident
+	switch f := fun.(type) {
+	case *ast.Ident:
+		ident = f
+	case *ast.SelectorExpr:
+		ident = f.Sel
+	default:
+		return nil
+	}
+
+	if obj, ok := info.Uses[ident].(*types.Func); ok {
+		obj = obj.Origin()
+		sig, _ := obj.Type().(*types.Signature)
+		if sig != nil && sig.Recv() != nil && types.IsInterface(sig.Recv().Type()) {
+			method := resolvedCallee{key: cgb.addFunc(obj, !cgb.analyzed[obj.Pkg()]), dynamic: true}
+			return append([]resolvedCallee{method}, cgb.implementations(obj)...)
+		}
+		return []resolvedCallee{{key: cgb.addFunc(obj, true)}}
+	}
+	if info.Uses[ident] != nil {
+		// Variables, builtins and conversions
+		return nil
+	}
+
+	// Without type information only package-level functions can be found
+	switch f := fun.(type) {
+	case *ast.Ident:
+		if obj, ok := checked.Scope().Lookup(f.Name).(*types.Func); ok {
+			return []resolvedCallee{{key: cgb.addFunc(obj, true)}}
+		}
+	case *ast.SelectorExpr:
+		if x, ok := f.X.(*ast.Ident); ok {
+			if path, ok := imports[x.Name]; ok {
+				key := path + "." + f.Sel.Name
+				cgb.graph.addNode(CallGraphNode{Key: key, Name: importLocalName(GoImport{Path: strconv.Quote(path)}) + "." + f.Sel.Name, Package: path, External: true})
+				return []resolvedCallee{{key: key}}
+			}
+		}
+	}
+	return nil
+}
+
+// implementations fans an interface method call out to the method of every
+// analyzed type that implements the interface.
+func (cgb *callGraphBuilder) implementations(method *types.Func) []resolvedCallee {
+	iface, ok := method.Type().(*types.Signature).Recv().Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+
+	callees := make([]resolvedCallee, 0)
+	for _, tn := range cgb.named {
+		typ, ok := implementsInterface(tn.Type(), iface)
+		if !ok {
+			continue
+		}
+		obj, _, _ := types.LookupFieldOrMethod(typ, true, method.Pkg(), method.Name())
+		if impl, ok := obj.(*types.Func); ok {
+			callees = append(callees, resolvedCallee{key: cgb.addFunc(impl, true), dynamic: true})
+		}
+	}
+	return callees
+}
+
+// addFunc registers fn and returns its key. Functions with a position in
+// the analyzed files are not external.
+func (cgb *callGraphBuilder) addFunc(fn *types.Func, external bool) string {
+	key := fn.FullName()
+	node := CallGraphNode{Key: key, Name: callName(fn), External: external}
+	if fn.Pkg() != nil {
+		node.Package = fn.Pkg().Path()
+	}
+	if _, ok := cgb.graph.nodes[key]; ok && external {
+		return key
+	}
+	if !external {
+		node.Position = cgb.fset.Position(fn.Pos()).String()
+	}
+	cgb.graph.addNode(node)
+	return key
+}
+
+// callName is the short name used in reports: pkg.Func or pkg.Type.Method.
+func callName(fn *types.Func) string {
+	name := fn.Name()
+	if sig, ok := fn.Type().(*types.Signature); ok && sig.Recv() != nil {
+		recv := sig.Recv().Type()
+		if ptr, ok := recv.(*types.Pointer); ok {
+			recv = ptr.Elem()
+		}
+		if named, ok := recv.(*types.Named); ok {
+			name = named.Obj().Name() + "." + name
+		}
+	}
+	if fn.Pkg() != nil {
+		name = fn.Pkg().Name() + "." + name
+	}
+	return name
+}
+
+// Find returns the keys of the functions named by query: a full key, a
+// short name such as pkg.Type.Method, or a suffix of it such as
+// Type.Method or Func.
+func (cg *CallGraph) Find(query string) []string {
+	matches := make([]string, 0)
+	for key, node := range cg.nodes {
+		if key == query || node.Name == query || strings.HasSuffix(node.Name, "."+query) {
+			matches = append(matches, key)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func (cg *CallGraph) Node(key string) (CallGraphNode, bool) {
+	node, ok := cg.nodes[key]
+	if !ok {
+		return CallGraphNode{}, false
+	}
+	return *node, true
+}
+
+// CallTree is the result of a callers or callees query.
+type CallTree struct {
+	Node     CallGraphNode `json:"node"`
+	Position string        `json:"position,omitempty"`
+	Dynamic  bool          `json:"dynamic,omitempty"`
+	Cycle    bool          `json:"cycle,omitempty"`
+	Children []*CallTree   `json:"children,omitempty"`
+}
+
+// Callees returns the functions called by key, to the given depth. A depth
+// of zero or less is unlimited.
+func (cg *CallGraph) Callees(key string, depth int) *CallTree {
+	return cg.walk(key, depth, cg.callees, func(edge CallEdge) string { return edge.Callee })
+}
+
+// Callers returns the functions calling key, to the given depth.
+func (cg *CallGraph) Callers(key string, depth int) *CallTree {
+	return cg.walk(key, depth, cg.callers, func(edge CallEdge) string { return edge.Caller })
+}
+
+func (cg *CallGraph) walk(key string, depth int, edges map[string][]CallEdge, next func(CallEdge) string) *CallTree {
+	var expand func(tree *CallTree, key string, level int, path map[string]bool)
+	expand = func(tree *CallTree, key string, level int, path map[string]bool) {
+		if depth > 0 && level >= depth {
+			return
+		}
+		sorted := append([]CallEdge{}, edges[key]...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if next(sorted[i]) != next(sorted[j]) {
+				return cg.nodes[next(sorted[i])].Name < cg.nodes[next(sorted[j])].Name
+			}
+			return sorted[i].Position < sorted[j].Position
+		})
+
+		for _, edge := range sorted {
+			target := next(edge)
+			child := &CallTree{Node: *cg.nodes[target], Position: edge.Position, Dynamic: edge.Dynamic}
+			tree.Children = append(tree.Children, child)
+			if path[target] {
+				child.Cycle = true
+				continue
+			}
+			path[target] = true
+			expand(child, target, level+1, path)
+			delete(path, target)
+		}
+	}
+
+	root := &CallTree{Node: *cg.nodes[key]}
+	expand(root, key, 0, map[string]bool{key: true})
+	return root
+}
+
+func WriteCallTree(w io.Writer, title string, tree *CallTree, arrow string, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(tree)
+	case "text", "":
+		fmt.Fprintf(w, "\n--- %s %s ---\n", title, tree.Node.Name)
+		var write func(tree *CallTree, indent string)
+		write = func(tree *CallTree, indent string) {
+			for _, child := range tree.Children {
+				line := fmt.Sprintf("%s%s %s  (%s)", indent, arrow, child.Node.Name, child.Position)
+				if child.Dynamic {
+					line += " [via interface]"
+				}
+				if child.Cycle {
+					line += " [cycle]"
+				}
+				fmt.Fprintln(w, line)
+				write(child, indent+"   ")
+			}
+		}
+		if len(tree.Children) == 0 {
+			fmt.Fprintln(w, "(none)")
+		}
+		write(tree, "")
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
+
+// WriteCallGraph exports the graph as text, JSON or Graphviz DOT.
+func WriteCallGraph(w io.Writer, cg *CallGraph, format string) error {
+	keys := make([]string, 0, len(cg.nodes))
+	for key := range cg.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if cg.nodes[keys[i]].Name != cg.nodes[keys[j]].Name {
+			return cg.nodes[keys[i]].Name < cg.nodes[keys[j]].Name
+		}
+		return keys[i] < keys[j]
+	})
+
+	edges := make([]CallEdge, 0)
+	callees := make(map[string][]CallEdge, len(keys))
+	for _, key := range keys {
+		sorted := append([]CallEdge{}, cg.callees[key]...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].Callee != sorted[j].Callee {
+				return cg.nodes[sorted[i].Callee].Name < cg.nodes[sorted[j].Callee].Name
+			}
+			return sorted[i].Position < sorted[j].Position
+		})
+		callees[key] = sorted
+		edges = append(edges, sorted...)
+	}
+
+	switch format {
+	case "json":
+		nodes := make([]CallGraphNode, 0, len(keys))
+		for _, key := range keys {
+			nodes = append(nodes, *cg.nodes[key])
+		}
+		return newJSONEncoder(w).Encode(struct {
+			Nodes []CallGraphNode `json:"nodes"`
+			Edges []CallEdge      `json:"edges"`
+		}{nodes, edges})
+	case "dot":
+		fmt.Fprintln(w, "digraph calls {")
+		fmt.Fprintln(w, "\trankdir=LR;")
+		for _, key := range keys {
+			node := cg.nodes[key]
+			shape := "box"
+			if node.External {
+				shape = "ellipse"
+			}
+			fmt.Fprintf(w, "\t%q [label=%q, shape=%s];\n", key, node.Name, shape)
+		}
+		for _, edge := range edges {
+			style := ""
+			if edge.Dynamic {
+				style = " [style=dashed]"
+			}
+			fmt.Fprintf(w, "\t%q -> %q%s;\n", edge.Caller, edge.Callee, style)
+		}
+		fmt.Fprintln(w, "}")
+		return nil
+	case "text", "":
+		fmt.Fprintln(w, "\n--- Call Graph ---")
+		for _, key := range keys {
+			node := cg.nodes[key]
+			if node.External {
+				continue
+			}
+			fmt.Fprintf(w, "%s at %s\n", node.Name, node.Position)
+			for _, edge := range callees[key] {
+				line := fmt.Sprintf("  -> %s  (%s)", cg.nodes[edge.Callee].Name, edge.Position)
+				if edge.Dynamic {
+					line += " [via interface]"
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text, json or dot)", format)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// callGraphModule has an interface implemented in two packages, a call
+// through it, and a package-level variable initialized by a call.
+var callGraphModule = map[string]string{
+	"go.mod":         "module example.com/m\n",
+	"store/store.go": "package store\n\ntype Store interface {\n\tGet(id string) string\n}\n\ntype Memory struct{}\n\nfunc (m *Memory) Get(id string) string { return lookup(id) }\n\nfunc lookup(id string) string { return id }\n",
+	"disk/disk.go":   "package disk\n\ntype Disk struct{}\n\nfunc (Disk) Get(id string) string { return \"\" }\n",
+	"app/app.go":     "package app\n\nimport (\n\t\"example.com/m/disk\"\n\t\"example.com/m/store\"\n)\n\nvar fallback = disk.Disk{}.Get(\"x\")\n\nfunc Load(s store.Store) string { return s.Get(\"id\") }\n\nfunc Run() string { return Load(&store.Memory{}) }\n",
+	"cli/cli.go":     "package cli\n\nimport \"example.com/m/app\"\n\nfunc Main() { app.Run() }\n",
+}
+
+func buildTestCallGraph(t *testing.T, root string) *CallGraph {
+	t.Helper()
+	opts, resolver := analyzeTestModule(t, root)
+	calls, err := BuildCallGraph(context.Background(), []string{root}, opts.Graph, opts, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+// flattenCallTree lists the nodes of tree below its root, indented by
+// depth and marked when reached through an interface.
+func flattenCallTree(tree *CallTree) []string {
+	lines := make([]string, 0)
+	var walk func(tree *CallTree, depth int)
+	walk = func(tree *CallTree, depth int) {
+		for _, child := range tree.Children {
+			line := strings.Repeat("  ", depth) + child.Node.Name
+			if child.Dynamic {
+				line += " (dynamic)"
+			}
+			if child.Cycle {
+				line += " (cycle)"
+			}
+			lines = append(lines, line)
+			walk(child, depth+1)
+		}
+	}
+	walk(tree, 0)
+	return lines
+}
+
+func TestCallGraphQueries(t *testing.T) {
+	calls := buildTestCallGraph(t, writeTestModule(t, callGraphModule))
+
+	tests := []struct {
+		name  string
+		query string
+		walk  func(*CallGraph) func(string, int) *CallTree
+		depth int
+		want  []string
+	}{
+		{
+			name:  "callees fan out to implementations",
+			query: "app.Run",
+			walk:  func(cg *CallGraph) func(string, int) *CallTree { return cg.Callees },
+			want: []string{
+				"app.Load",
+				"  disk.Disk.Get (dynamic)",
+				"  store.Memory.Get (dynamic)",
+				"    store.lookup",
+				"  store.Store.Get (dynamic)",
+			},
+		},
+		{
+			name:  "callees to depth",
+			query: "app.Run",
+			walk:  func(cg *CallGraph) func(string, int) *CallTree { return cg.Callees },
+			depth: 1,
+			want:  []string{"app.Load"},
+		},
+		{
+			name:  "callers through an interface",
+			query: "store.lookup",
+			walk:  func(cg *CallGraph) func(string, int) *CallTree { return cg.Callers },
+			want: []string{
+				"store.Memory.Get",
+				"  app.Load (dynamic)",
+				"    app.Run",
+				"      cli.Main",
+			},
+		},
+		{
+			name:  "callers include package initializers",
+			query: "Disk.Get",
+			walk:  func(cg *CallGraph) func(string, int) *CallTree { return cg.Callers },
+			want: []string{
+				"app.Load (dynamic)",
+				"  app.Run",
+				"    cli.Main",
+				"app.init",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := calls.Find(tt.query)
+			if len(matches) != 1 {
+				t.Fatalf("Find(%q) = %v, want one match", tt.query, matches)
+			}
+			if got := flattenCallTree(tt.walk(calls)(matches[0], tt.depth)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCallGraphCycles(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod":       "module example.com/m\n",
+		"even/even.go": "package even\n\nfunc Even(n int) bool { return n == 0 || odd(n-1) }\n\nfunc odd(n int) bool { return n != 0 && Even(n-1) }\n",
+	})
+	calls := buildTestCallGraph(t, root)
+
+	want := []string{"even.odd", "  even.Even (cycle)"}
+	if got := flattenCallTree(calls.Callees(calls.Find("Even")[0], 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Callees(Even) = %q, want %q", got, want)
+	}
+	if node, ok := calls.Node("example.com/m/even.odd"); !ok || node.External || node.Position == "" {
+		t.Errorf("Node(odd) = %+v, %v, want an analyzed node", node, ok)
+	}
+	if _, ok := calls.Node("example.com/m/even.missing"); ok {
+		t.Error("Node(missing) found")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangeScope is the part of the tree a -changed-since run reports on: the
+// .go files changed since a revision, and the declarations directly
+// depending on a declaration in one of them.
+type ChangeScope struct {
+	Revision   string
+	files      map[string]bool
+	dependents map[string]bool
+	shownFiles map[string]bool
+}
+
+func NewChangeScope(revision string, files []string) *ChangeScope {
+	cs := &ChangeScope{
+		Revision:   revision,
+		files:      make(map[string]bool),
+		dependents: make(map[string]bool),
+		shownFiles: make(map[string]bool),
+	}
+	for _, file := range files {
+		file = absolutePath(file)
+		cs.files[file] = true
+		cs.shownFiles[file] = true
+	}
+	return cs
+}
+
+// ChangedGoFiles asks git for the .go files that differ between rev and the
+// working tree, including untracked ones. Deleted files are left out.
+func ChangedGoFiles(rev string) ([]string, error) {
+	top, err := runGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	// Pathspecs are relative to the directory git runs in
+	diff, err := runGit(top, "diff", "--name-only", "--diff-filter=d", rev, "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit(top, "ls-files", "--others", "--exclude-standard", "--full-name", "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for _, name := range strings.Split(diff+"\n"+untracked, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			files = appendUnique(files, filepath.Join(top, filepath.FromSlash(name)))
+		}
+	}
+	return files, nil
+}
+
+func absolutePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (cs *ChangeScope) Files() int {
+	return len(cs.files)
+}
+
+func (cs *ChangeScope) Dependents() int {
+	return len(cs.dependents)
+}
+
+// Covers reports whether the declaration at position is in a changed file
+// or directly depends on one that is.
+func (cs *ChangeScope) Covers(position string) bool {
+	return cs.dependents[position] || cs.files[absolutePath(positionFile(position))]
+}
+
+// ShowsFile reports whether filename holds anything the scope covers.
+func (cs *ChangeScope) ShowsFile(filename string) bool {
+	return cs.shownFiles[absolutePath(filename)]
+}
+
+// AddDependents adds every declaration with a dependency edge to a
+// declaration in a changed file. Such edges only come from the packages of
+// the changed files and the packages importing them, so the import graph
+// of dirs is walked back from the changed packages and only those are
+// analyzed and type-checked.
+func (cs *ChangeScope) AddDependents(ctx context.Context, dirs []string, base AnalysisOptions, resolver PackageResolver) error {
+	graph := base.Graph
+	if graph == nil {
+		var err error
+		if graph, err = BuildImportGraph(dirs, base, resolver); err != nil {
+			return err
+		}
+	}
+	scoped := cs.dependentDirs(graph)
+	if len(scoped) == 0 {
+		return nil
+	}
+
+	opts := base
+	opts.SelectedTypes = map[string]bool{
+		"structs":    true,
+		"interfaces": true,
+		"functions":  true,
+	}
+	opts.NonRecursive = true
+	opts.GenNoOp = false
+	opts.FunctionFilter = nil
+	opts.Changes = nil
+	opts.StructSources = NewSourceItemsCollector[GoStruct]()
+	opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	opts.VariableSources = nil
+	opts.ConstantSources = nil
+	opts.TypeSources = nil
+	opts.Imports = NewImportIndex()
+	opts.Graph = graph
+	opts.Output = io.Discard
+
+	for _, dir := range scoped {
+		if err := walkDirectory(ctx, dir, opts); err != nil {
+			return fmt.Errorf("analyzing %s: %v", dir, err)
+		}
+	}
+
+	calls, err := BuildCallGraph(ctx, scoped, graph, opts, resolver)
+	if err != nil {
+		return err
+	}
+	dg := BuildDependencyGraph(opts, resolver, calls)
+	for key, decl := range dg.declarations {
+		if !cs.files[absolutePath(positionFile(decl.Position))] {
+			continue
+		}
+		for _, edge := range dg.dependents[key] {
+			position := dg.declarations[edge.From].Position
+			if file := absolutePath(positionFile(position)); !cs.files[file] {
+				cs.dependents[position] = true
+				cs.shownFiles[file] = true
+			}
+		}
+	}
+	return nil
+}
+
+// dependentDirs returns the directories of the analyzed packages holding a
+// changed file and of the analyzed packages importing those, sorted.
+func (cs *ChangeScope) dependentDirs(graph *ImportGraph) []string {
+	// Only packages found under the analyzed directories have a name
+	byDir := make(map[string][]string)
+	dirOf := make(map[string]string)
+	for _, pkg := range graph.Sorted() {
+		if pkg.Name == "" || pkg.Dir == "" {
+			continue
+		}
+		dir := absolutePath(pkg.Dir)
+		byDir[dir] = append(byDir[dir], pkg.ImportPath)
+		dirOf[pkg.ImportPath] = pkg.Dir
+	}
+
+	scoped := make(map[string]bool)
+	for file := range cs.files {
+		for _, path := range byDir[filepath.Dir(file)] {
+			scoped[dirOf[path]] = true
+			for _, importer := range graph.Importers(path) {
+				if dir, ok := dirOf[importer]; ok {
+					scoped[dir] = true
+				}
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(scoped))
+	for dir := range scoped {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// ChangedItemValidator accepts the items a ChangeScope covers.
+type ChangedItemValidator[T any] struct {
+	changes   *ChangeScope
+	positions PositionProvider[T]
+	next      ItemValidator[T]
+}
+
+// NewChangedItemValidator wraps next, which may be nil, so items must be
+// both covered by changes and valid for next.
+func NewChangedItemValidator[T any](changes *ChangeScope, positions PositionProvider[T], next ItemValidator[T]) *ChangedItemValidator[T] {
+	return &ChangedItemValidator[T]{changes: changes, positions: positions, next: next}
+}
+
+func (civ *ChangedItemValidator[T]) IsValid(item T) bool {
+	if !civ.changes.Covers(civ.positions.GetPosition(item)) {
+		return false
+	}
+	return civ.next == nil || civ.next.IsValid(item)
+}
+
+func filterValid[T any](items []T, validator ItemValidator[T]) []T {
+	valid := make([]T, 0, len(items))
+	for _, item := range items {
+		if validator.IsValid(item) {
+			valid = append(valid, item)
+		}
+	}
+	return valid
+}
+
+type StructPositionProvider struct{}
+
+func (spp *StructPositionProvider) GetPosition(item GoStruct) string {
+	return item.Position
+}
+
+type InterfacePositionProvider struct{}
+
+func (ipp *InterfacePositionProvider) GetPosition(item GoInterface) string {
+	return item.Position
+}
+
+type FunctionPositionProvider struct{}
+
+func (fpp *FunctionPositionProvider) GetPosition(item GoFunction) string {
+	return item.Position
+}
+
+type VariablePositionProvider struct{}
+
+func (vpp *VariablePositionProvider) GetPosition(item GoVariable) string {
+	return item.Position
+}
+
+type ConstantPositionProvider struct{}
+
+func (cpp *ConstantPositionProvider) GetPosition(item GoConstant) string {
+	return item.Position
+}
+
+type TypePositionProvider struct{}
+
+func (tpp *TypePositionProvider) GetPosition(item GoType) string {
+	return item.Position
+}
+
+type ImportPositionProvider struct{}
+
+func (ipp *ImportPositionProvider) GetPosition(item GoImport) string {
+	return item.Position
+}
+
+type DeadCodePositionProvider struct{}
+
+func (dpp *DeadCodePositionProvider) GetPosition(item DeadCode) string {
+	return item.Position
+}
+
+type LayerViolationPositionProvider struct{}
+
+func (lpp *LayerViolationPositionProvider) GetPosition(item LayerViolation) string {
+	return item.Position
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestChangedGoFiles(t *testing.T) {
+	repo := commitTestRepository(t, map[string]string{
+		"go.mod":         "module example.com/c\n",
+		"README":         "readme\n",
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"store/store.go": "package store\n",
+		"store/old.go":   "package store\n\nfunc Old() {}\n",
+		"app/app.go":     "package app\n",
+	})
+	for name, content := range map[string]string{
+		"README":           "changed\n",
+		"store/store.go":   "package store\n\nfunc New() {}\n",
+		"app/untracked.go": "package app\n\nfunc Added() {}\n",
+		"app/notes.txt":    "notes\n",
+	} {
+		if err := os.WriteFile(filepath.Join(repo, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(repo, "store", "old.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths are relative to the top of the repository, not the working
+	// directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(repo, "app")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	files, err := ChangedGoFiles("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	want := []string{filepath.Join(repo, "app", "untracked.go"), filepath.Join(repo, "store", "store.go")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ChangedGoFiles() = %v, want %v", files, want)
+	}
+
+	if _, err := ChangedGoFiles("no-such-revision"); err == nil {
+		t.Error("ChangedGoFiles() with an unknown revision succeeded")
+	}
+}
+
+func TestChangeScopeAddDependents(t *testing.T) {
+	root := writeTestModule(t, callGraphModule)
+
+	tests := []struct {
+		name       string
+		changed    []string
+		dirs       []string // packages analyzed
+		shown      []string
+		dependents []string // positions relative to root
+	}{
+		{
+			name:       "importers of a changed package",
+			changed:    []string{"store/store.go"},
+			dirs:       []string{"app", "store"},
+			shown:      []string{"app/app.go", "store/store.go"},
+			dependents: []string{"app/app.go:10:1"},
+		},
+		{
+			name:    "package without importers",
+			changed: []string{"cli/cli.go"},
+			dirs:    []string{"cli"},
+			shown:   []string{"cli/cli.go"},
+		},
+		{
+			name:    "file outside the analyzed packages",
+			changed: []string{"tools/gen.go"},
+			shown:   []string{"tools/gen.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewPackageResolver([]string{root})
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make([]string, 0, len(tt.changed))
+			for _, name := range tt.changed {
+				files = append(files, filepath.Join(root, filepath.FromSlash(name)))
+			}
+			cs := NewChangeScope("HEAD", files)
+
+			graph, err := BuildImportGraph([]string{root}, AnalysisOptions{}, resolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dirs []string
+			for _, dir := range cs.dependentDirs(graph) {
+				rel, err := filepath.Rel(root, dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dirs = append(dirs, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(dirs, tt.dirs) {
+				t.Errorf("dependentDirs() = %v, want %v", dirs, tt.dirs)
+			}
+
+			if err := cs.AddDependents(context.Background(), []string{root}, AnalysisOptions{}, resolver); err != nil {
+				t.Fatal(err)
+			}
+			if cs.Dependents() != len(tt.dependents) {
+				t.Errorf("Dependents() = %d, want %d", cs.Dependents(), len(tt.dependents))
+			}
+			for _, position := range tt.dependents {
+				if !cs.Covers(filepath.Join(root, filepath.FromSlash(position))) {
+					t.Errorf("Covers(%s) = false", position)
+				}
+			}
+			for _, name := range []string{"app/app.go", "cli/cli.go", "disk/disk.go", "store/store.go", "tools/gen.go"} {
+				want := false
+				for _, shown := range tt.shown {
+					want = want || shown == name
+				}
+				if got := cs.ShowsFile(filepath.Join(root, filepath.FromSlash(name))); got != want {
+					t.Errorf("ShowsFile(%s) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
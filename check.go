@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkGeneratedFiles compares freshly generated files against what is on
+// disk and prints a unified diff for every file that differs. Files under
+// the output directories that carry the generated header but were not
+// produced by this run are reported as orphans. It returns the number of
+// stale files.
+func checkGeneratedFiles(outputDirs []string, generated map[string]string, w io.Writer) (int, error) {
+	names := make([]string, 0, len(generated))
+	for name := range generated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stale := 0
+	for _, name := range names {
+		want := generated[name]
+		current, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			return stale, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		if err == nil && string(current) == want {
+			continue
+		}
+
+		stale++
+		from := name
+		if os.IsNotExist(err) {
+			from = "/dev/null"
+			fmt.Fprintf(w, "missing: %s\n", name)
+		} else {
+			fmt.Fprintf(w, "stale: %s\n", name)
+		}
+		fmt.Fprint(w, unifiedDiff(from, name, string(current), want))
+	}
+
+	orphans := make([]string, 0)
+	for _, outputDir := range outputDirs {
+		found, err := findOrphanedFiles(outputDir, generated)
+		if err != nil {
+			return stale, err
+		}
+		orphans = append(orphans, found...)
+	}
+
+	for _, name := range orphans {
+		current, err := os.ReadFile(name)
+		if err != nil {
+			return stale, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		stale++
+		fmt.Fprintf(w, "orphaned: %s\n", name)
+		fmt.Fprint(w, unifiedDiff(name, "/dev/null", string(current), ""))
+	}
+
+	return stale, nil
+}
+
+func findOrphanedFiles(outputDir string, generated map[string]string) ([]string, error) {
+	orphans := make([]string, 0)
+
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		return orphans, nil
+	}
+
+	err := filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		path = filepath.Clean(path)
+		if _, ok := generated[path]; ok {
+			return nil
+		}
+
+		isGenerated, err := hasGeneratedHeader(path)
+		if err != nil {
+			return err
+		}
+		if isGenerated {
+			orphans = append(orphans, path)
+		}
+		return nil
+	})
+
+	sort.Strings(orphans)
+	return orphans, err
+}
+
+func hasGeneratedHeader(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		return scanner.Text() == generatedHeader, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// cliConfig holds the flags of every command. Commands register the flag
+// groups they take and set the fields their name implies, so "astro gen
+// noop" runs the same analysis as "astro -noop".
+type cliConfig struct {
+	// patterns are the arguments left after the flags and the command's
+	// own arguments: packages, or the snapshots of "api diff"
+	patterns    []string
+	impactOf    string
+	apiSnapshot bool
+	// noListing discards the per-file listing of commands that only
+	// generate or check
+	noListing       bool
+	builtinTemplate string
+
+	dirs          string
+	withTests     bool
+	buildTags     string
+	goos          string
+	goarch        string
+	excludes      string
+	includes      string
+	withGenerated bool
+	useGitignore  bool
+	strict        bool
+	jobs          int
+	cacheDir      string
+	clearCache    bool
+
+	showStructs   bool
+	showIfaces    bool
+	showFuncs     bool
+	showVars      bool
+	showConsts    bool
+	showTypes     bool
+	showImports   bool
+	showAll       bool
+	topoSort      bool
+	alphaSort     bool
+	sortBy        string
+	minComplexity int
+	minCognitive  int
+	changedSince  string
+
+	genNoOp     bool
+	noOpDir     string
+	checkMode   bool
+	noOpLayout  string
+	noOpMirror  bool
+	noOpName    string
+	noOpPackage string
+
+	tmplPath    string
+	tmplOut     string
+	tmplLayout  string
+	tmplMirror  bool
+	tmplName    string
+	tmplPackage string
+
+	extractFrom   string
+	extractName   string
+	extractOnly   string
+	extractAssert bool
+	extractOut    string
+	genTests      bool
+	genFuzz       bool
+
+	format        string
+	importGraph   bool
+	showMetrics   bool
+	metricsFail   string
+	callGraph     bool
+	callersOf     string
+	calleesOf     string
+	callDepth     int
+	deadCode      bool
+	deadBaseline  string
+	deadUpdate    bool
+	apiOut        string
+	fromRev       string
+	toRev         string
+	layersFile    string
+	platforms     string
+	watchMode     bool
+	watchInterval time.Duration
+}
+
+// callQuery reports whether the call graph is exported or queried.
+func (cfg *cliConfig) callQuery() bool {
+	return cfg.callGraph || cfg.callersOf != "" || cfg.calleesOf != ""
+}
+
+// errFindings makes astro exit with status 1 after a command reported
+// violations or stale files itself.
+var errFindings = errors.New("findings reported")
+
+// cliCommand is a subcommand. Commands of a group, like "gen noop", are
+// listed under their full name. setup validates the arguments left after
+// the flags, sets what the command implies and returns the package
+// patterns to analyze; run runs the command.
+type cliCommand struct {
+	name    string
+	args    string
+	summary string
+	flags   func(fs *flag.FlagSet, cfg *cliConfig)
+	setup   func(cfg *cliConfig, args []string) ([]string, error)
+	run     func(ctx context.Context, cfg *cliConfig) error
+}
+
+var cliCommands = []cliCommand{
+	{
+		name:    "analyze",
+		args:    "[packages]",
+		summary: "List declarations and run any reports, generators and checks; running astro without a command is the same",
+		flags:   addAnalyzeFlags,
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			return args, checkWatch(cfg)
+		},
+		run: runAnalysis,
+	},
+	{
+		name:    "gen noop",
+		args:    "[packages]",
+		summary: "Generate NoOp implementations of the interfaces",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addOrderFlags(fs, cfg)
+			fs.StringVar(&cfg.noOpDir, "out", "./noop", "Directory to save NoOp implementations")
+			fs.StringVar(&cfg.noOpLayout, "layout", LayoutPerFile, "Output layout: file, interface or package")
+			fs.BoolVar(&cfg.noOpMirror, "mirror", false, "Mirror the source directory tree under -out")
+			fs.StringVar(&cfg.noOpName, "name", "", "Filename template relative to -out, e.g. {{.Package}}/noop_{{.Name}}.go")
+			fs.StringVar(&cfg.noOpPackage, "package", "main", "Package clause of generated files (empty uses the source package)")
+			fs.BoolVar(&cfg.checkMode, "check", false, "Check that the files in -out are up to date instead of writing them (exits non-zero if stale)")
+			addWatchFlags(fs, cfg)
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			cfg.genNoOp = true
+			cfg.showIfaces = true
+			cfg.noListing = true
+			return args, checkWatch(cfg)
+		},
+		run: runAnalysis,
+	},
+	genTemplateCommand("mock", "Generate mocks of the interfaces: each method calls a func field and panics when it is nil"),
+	genTemplateCommand("fake", "Generate fakes of the interfaces: each method records its call and returns a func field's results or zero values"),
+	{
+		name:    "graph imports",
+		args:    "[packages]",
+		summary: "Report the package import graph with cycles and transitive dependency counts",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text, json or dot")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			cfg.importGraph = true
+			return args, nil
+		},
+		run: runAnalysis,
+	},
+	{
+		name:    "graph calls",
+		args:    "[packages]",
+		summary: "Export the static call graph",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text, json or dot")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			cfg.callGraph = true
+			return args, nil
+		},
+		run: runAnalysis,
+	},
+	{
+		name:    "check",
+		args:    "[packages]",
+		summary: "Check imports against layering rules and package metrics against thresholds (exits non-zero on violations)",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text or json")
+			fs.StringVar(&cfg.layersFile, "layers", "", "Layering rules file to check imports and type references against")
+			fs.StringVar(&cfg.metricsFail, "metrics-fail", "", "Comma-separated metric thresholds, e.g. D>0.7,Ce>=20")
+			fs.StringVar(&cfg.changedSince, "changed-since", "", "Only report layer violations in .go files changed since this git revision and those directly depending on them")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			if cfg.layersFile == "" && cfg.metricsFail == "" {
+				return nil, fmt.Errorf("nothing to check: set -layers or -metrics-fail")
+			}
+			cfg.noListing = true
+			return args, nil
+		},
+		run: runAnalysis,
+	},
+	callQueryCommand("callers", "Show the callers of a function (Func, Type.Method or pkg.Type.Method)", func(cfg *cliConfig) *string { return &cfg.callersOf }),
+	callQueryCommand("callees", "Show the functions called by a function (Func, Type.Method or pkg.Type.Method)", func(cfg *cliConfig) *string { return &cfg.calleesOf }),
+	{
+		name:    "query impact",
+		args:    "<Name> [packages]",
+		summary: "Show what directly and transitively depends on a declaration",
+		flags:   addImpactFlags,
+		setup:   setupImpact,
+		run:     runAnalysis,
+	},
+	{
+		name:    "impact",
+		args:    "<Name> [packages]",
+		summary: "Same as query impact",
+		flags:   addImpactFlags,
+		setup:   setupImpact,
+		run:     runAnalysis,
+	},
+	{
+		name:    "api snapshot",
+		args:    "[packages]",
+		summary: "Write the exported API of the packages",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text or json")
+			fs.StringVar(&cfg.apiOut, "api-out", "", "File to write the API snapshot to (default stdout)")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			cfg.apiSnapshot = true
+			return args, nil
+		},
+		run: runAnalysis,
+	},
+	{
+		name:    "api diff",
+		args:    "<old> <new>",
+		summary: "Compare two API snapshots and classify the changes",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addFormatFlag(fs, cfg, "text or json")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("want the old and the new snapshot")
+			}
+			return args, nil
+		},
+		run: runAPIDiff,
+	},
+	{
+		name:    "diff",
+		args:    "-from=<rev> [packages]",
+		summary: "Compare the architecture of two git revisions",
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text or json")
+			fs.StringVar(&cfg.fromRev, "from", "", "Git revision to compare from")
+			fs.StringVar(&cfg.toRev, "to", "HEAD", "Git revision to compare to")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			if cfg.fromRev == "" {
+				return nil, fmt.Errorf("-from is required")
+			}
+			return args, nil
+		},
+		run: runArchitectureDiff,
+	},
+}
+
+func genTemplateCommand(name, summary string) cliCommand {
+	return cliCommand{
+		name:    "gen " + name,
+		args:    "[packages]",
+		summary: summary,
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addOrderFlags(fs, cfg)
+			fs.StringVar(&cfg.tmplOut, "out", "./"+name+"s", "Directory to save the "+name+"s")
+			fs.StringVar(&cfg.tmplLayout, "layout", LayoutPerFile, "Output layout: file, interface or package")
+			fs.BoolVar(&cfg.tmplMirror, "mirror", false, "Mirror the source directory tree under -out")
+			fs.StringVar(&cfg.tmplName, "name", "", "Filename template relative to -out, e.g. {{.Package}}/"+name+"_{{.Name}}.go")
+			fs.StringVar(&cfg.tmplPackage, "package", "", "Package clause of generated files (empty uses the source package with a "+name+" suffix, or the name of -out when it holds several packages)")
+			fs.BoolVar(&cfg.checkMode, "check", false, "Check that the files in -out are up to date instead of writing them (exits non-zero if stale)")
+			addWatchFlags(fs, cfg)
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			cfg.builtinTemplate = name
+			cfg.showIfaces = true
+			cfg.noListing = true
+			return args, checkWatch(cfg)
+		},
+		run: runAnalysis,
+	}
+}
+
+func callQueryCommand(name, summary string, target func(cfg *cliConfig) *string) cliCommand {
+	return cliCommand{
+		name:    "query " + name,
+		args:    "<Func> [packages]",
+		summary: summary,
+		flags: func(fs *flag.FlagSet, cfg *cliConfig) {
+			addSourceFlags(fs, cfg)
+			addFormatFlag(fs, cfg, "text or json")
+			fs.IntVar(&cfg.callDepth, "call-depth", 1, "Depth of the query (0 for unlimited)")
+		},
+		setup: func(cfg *cliConfig, args []string) ([]string, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("want the function to query")
+			}
+			*target(cfg) = args[0]
+			return args[1:], nil
+		},
+		run: runAnalysis,
+	}
+}
+
+func addImpactFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	addSourceFlags(fs, cfg)
+	addFormatFlag(fs, cfg, "text or json")
+}
+
+func setupImpact(cfg *cliConfig, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("want the declaration to analyze")
+	}
+	cfg.impactOf = args[0]
+	return args[1:], nil
+}
+
+// addSourceFlags registers what selects and parses the analyzed files.
+func addSourceFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.dirs, "dirs", ".", "Comma-separated list of directories to analyze")
+	fs.BoolVar(&cfg.withTests, "tests", false, "Include _test.go files in the analysis")
+	fs.StringVar(&cfg.buildTags, "tags", "", "Comma-separated build tags files are selected with, like go build -tags")
+	fs.StringVar(&cfg.goos, "goos", build.Default.GOOS, "Target operating system files are selected for")
+	fs.StringVar(&cfg.goarch, "goarch", build.Default.GOARCH, "Target architecture files are selected for")
+	fs.StringVar(&cfg.excludes, "exclude", "", "Comma-separated globs or re:regexps of files and directories to skip")
+	fs.StringVar(&cfg.includes, "include", "", "Comma-separated globs or re:regexps; only matching files are analyzed")
+	fs.BoolVar(&cfg.withGenerated, "generated", false, "Analyze generated files (// Code generated ... DO NOT EDIT.)")
+	fs.BoolVar(&cfg.useGitignore, "gitignore", true, "Skip files and directories ignored by .gitignore")
+	fs.BoolVar(&cfg.strict, "strict", false, "Stop at the first file that can't be read or parsed instead of reporting it at the end")
+	fs.IntVar(&cfg.jobs, "j", 0, "Number of files parsed and analyzed in parallel (0 uses GOMAXPROCS)")
+	fs.StringVar(&cfg.cacheDir, "cache-dir", DefaultCacheDir(), "Directory of the analysis cache (empty disables caching)")
+	fs.BoolVar(&cfg.clearCache, "clear-cache", false, "Remove everything in -cache-dir before analyzing")
+}
+
+func addOrderFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.BoolVar(&cfg.topoSort, "topo", true, "Use topological sorting based on dependencies")
+	fs.BoolVar(&cfg.alphaSort, "alpha", false, "Use alphabetical sorting instead of topological")
+}
+
+// addWatchFlags registers -watch, which regenerates the files of the
+// generators that are enabled as well.
+func addWatchFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.BoolVar(&cfg.watchMode, "watch", false, "Keep running and report architecture changes whenever .go files change")
+	fs.DurationVar(&cfg.watchInterval, "watch-interval", time.Second, "How often -watch polls for changed files")
+}
+
+// checkWatch rejects -watch with -check, which exits after one run.
+func checkWatch(cfg *cliConfig) error {
+	if cfg.watchMode && cfg.checkMode {
+		return fmt.Errorf("-watch cannot be combined with -check")
+	}
+	return nil
+}
+
+func addFormatFlag(fs *flag.FlagSet, cfg *cliConfig, formats string) {
+	fs.StringVar(&cfg.format, "format", "text", "Output format: "+formats)
+}
+
+// addAnalyzeFlags registers every flag; they are the flags astro takes
+// without a command.
+func addAnalyzeFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	addSourceFlags(fs, cfg)
+	addOrderFlags(fs, cfg)
+	fs.BoolVar(&cfg.showStructs, "structs", false, "Show structs")
+	fs.BoolVar(&cfg.showIfaces, "interfaces", false, "Show interfaces")
+	fs.BoolVar(&cfg.showFuncs, "functions", false, "Show functions")
+	fs.BoolVar(&cfg.showVars, "variables", false, "Show variables")
+	fs.BoolVar(&cfg.showConsts, "constants", false, "Show constants")
+	fs.BoolVar(&cfg.showTypes, "types", false, "Show named types other than structs and interfaces")
+	fs.BoolVar(&cfg.showImports, "imports", false, "Show imports")
+	fs.BoolVar(&cfg.showAll, "all", false, "Show all types")
+	fs.StringVar(&cfg.sortBy, "sort", SortByDependency, "Order of function listings: dependency, name, complexity, cognitive, statements, nesting or returns")
+	fs.IntVar(&cfg.minComplexity, "min-complexity", 0, "Only list functions with at least this cyclomatic complexity")
+	fs.IntVar(&cfg.minCognitive, "min-cognitive", 0, "Only list functions with at least this cognitive complexity")
+	fs.StringVar(&cfg.changedSince, "changed-since", "", "Only report declarations in .go files changed since this git revision and those directly depending on them")
+
+	fs.BoolVar(&cfg.genNoOp, "noop", false, "Generate NoOp implementations for interfaces")
+	fs.StringVar(&cfg.noOpDir, "noop-dir", "./noop", "Directory to save NoOp implementations")
+	fs.BoolVar(&cfg.checkMode, "check", false, "Check that generated NoOp files in -noop-dir are up to date (exits non-zero if stale)")
+	fs.StringVar(&cfg.noOpLayout, "noop-layout", LayoutPerFile, "NoOp output layout: file, interface or package")
+	fs.BoolVar(&cfg.noOpMirror, "noop-mirror", false, "Mirror the source directory tree under -noop-dir")
+	fs.StringVar(&cfg.noOpName, "noop-name", "", "Filename template relative to -noop-dir, e.g. {{.Package}}/mock_{{.Name}}.go")
+	fs.StringVar(&cfg.noOpPackage, "noop-package", "main", "Package clause of generated NoOp files (empty uses the source package)")
+	fs.StringVar(&cfg.tmplPath, "template", "", "text/template file or directory of *.tmpl files to execute against interfaces and structs")
+	fs.StringVar(&cfg.tmplOut, "template-out", "./generated", "Directory to save template output")
+	fs.StringVar(&cfg.tmplLayout, "template-layout", LayoutPerFile, "Template output layout: file, interface or package")
+	fs.BoolVar(&cfg.tmplMirror, "template-mirror", false, "Mirror the source directory tree under -template-out")
+	fs.StringVar(&cfg.tmplName, "template-name", "", "Filename template relative to -template-out")
+	fs.StringVar(&cfg.tmplPackage, "template-package", "", "Package clause of template output (empty uses the source package, or the name of the output directory when it holds several packages)")
+	fs.StringVar(&cfg.extractFrom, "extract-interface", "", "Generate an interface from the exported methods of this struct (Name or pkg.Name)")
+	fs.StringVar(&cfg.extractName, "extract-name", "", "Name of the extracted interface (default <Struct>Interface)")
+	fs.StringVar(&cfg.extractOnly, "extract-methods", "", "Only include methods whose name matches this regular expression")
+	fs.BoolVar(&cfg.extractAssert, "extract-assert", false, "Emit a compile-time assertion that the struct implements the extracted interface")
+	fs.StringVar(&cfg.extractOut, "extract-out", "", "File to write the extracted interface to (default stdout)")
+	fs.BoolVar(&cfg.genTests, "gen-tests", false, "Generate table-driven _test.go skeletons for exported functions and methods")
+	fs.BoolVar(&cfg.genFuzz, "gen-fuzz", false, "Generate FuzzX skeletons for exported functions with fuzzable parameters")
+
+	fs.StringVar(&cfg.format, "format", "text", "Output format of graph reports: text, json or dot")
+	fs.BoolVar(&cfg.importGraph, "import-graph", false, "Report the package import graph with cycles and transitive dependency counts")
+	fs.BoolVar(&cfg.showMetrics, "metrics", false, "Report package coupling, instability, abstractness and distance from the main sequence")
+	fs.StringVar(&cfg.metricsFail, "metrics-fail", "", "Comma-separated thresholds that fail the run, e.g. D>0.7,Ce>=20")
+	fs.BoolVar(&cfg.callGraph, "call-graph", false, "Export the static call graph in -format")
+	fs.StringVar(&cfg.callersOf, "callers", "", "Show the callers of a function (Func, Type.Method or pkg.Type.Method)")
+	fs.StringVar(&cfg.calleesOf, "callees", "", "Show the functions called by a function")
+	fs.IntVar(&cfg.callDepth, "call-depth", 1, "Depth of -callers and -callees queries (0 for unlimited)")
+	fs.BoolVar(&cfg.deadCode, "dead-code", false, "Report unused declarations, interfaces without implementations and struct fields never read")
+	fs.StringVar(&cfg.deadBaseline, "dead-code-baseline", "", "File of dead code findings to suppress, one key per line")
+	fs.BoolVar(&cfg.deadUpdate, "dead-code-update-baseline", false, "Write the current dead code findings to -dead-code-baseline")
+	fs.StringVar(&cfg.layersFile, "layers", "", "Layering rules file to check imports and type references against (exits non-zero on violations)")
+	fs.StringVar(&cfg.platforms, "platforms", "", "Comma-separated goos/goarch pairs to analyze; reports declarations missing on some of them")
+	addWatchFlags(fs, cfg)
+}
+
+// parseCommandLine finds the command in args and parses its flags, which
+// may come before or after its arguments. Without a command, args are
+// the flags and packages of analyze.
+func parseCommandLine(args []string) (cliCommand, *cliConfig) {
+	cmd, args := findCommand(args)
+	cfg := &cliConfig{}
+	fs := newCommandFlagSet(cmd, cfg)
+	patterns, err := cmd.setup(cfg, parseInterspersed(fs, args))
+	if err != nil {
+		fmt.Fprintf(fs.Output(), "astro %s: %v\n", cmd.name, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	cfg.patterns = patterns
+	return cmd, cfg
+}
+
+// parseInterspersed parses flags that may follow positional arguments,
+// as in "astro impact Name -format json", and returns the positionals.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	positionals := make([]string, 0)
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		// Everything after "--" is positional, even if it looks like a flag
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positionals, rest...)
+		}
+		args = rest
+		if len(args) == 0 {
+			return positionals
+		}
+		positionals = append(positionals, args[0])
+		args = args[1:]
+	}
+}
+
+// newCommandFlagSet registers the flags of cmd. Flags of other commands
+// keep the defaults they have for analyze.
+func newCommandFlagSet(cmd cliCommand, cfg *cliConfig) *flag.FlagSet {
+	addAnalyzeFlags(flag.NewFlagSet("defaults", flag.ContinueOnError), cfg)
+
+	fs := flag.NewFlagSet("astro "+cmd.name, flag.ExitOnError)
+	cmd.flags(fs, cfg)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: astro %s [flags] %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+		if cmd.name == "analyze" {
+			fmt.Fprintln(fs.Output())
+			writeCommands(fs.Output())
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// findCommand returns the command args start with and the arguments after
+// its name. Groups without a valid subcommand and "astro help" print usage
+// and exit. A first argument naming a directory is a package of analyze,
+// not a command.
+func findCommand(args []string) (cliCommand, []string) {
+	if len(args) == 0 || isDirectory(args[0]) {
+		cmd, _ := lookupCommand("analyze")
+		return cmd, args
+	}
+
+	if args[0] == "help" {
+		if len(args) > 1 {
+			if cmd, ok := lookupCommand(strings.Join(args[1:], " ")); ok {
+				fs := newCommandFlagSet(cmd, &cliConfig{})
+				fs.SetOutput(os.Stdout)
+				fs.Usage()
+				os.Exit(0)
+			}
+		}
+		fmt.Println("Usage: astro <command> [flags] [arguments]")
+		fmt.Println()
+		writeCommands(os.Stdout)
+		fmt.Println("\nRun \"astro help <command>\" for the flags of a command.")
+		os.Exit(0)
+	}
+
+	if len(args) > 1 {
+		if cmd, ok := lookupCommand(args[0] + " " + args[1]); ok {
+			return cmd, args[2:]
+		}
+	}
+	if cmd, ok := lookupCommand(args[0]); ok {
+		return cmd, args[1:]
+	}
+	if isCommandGroup(args[0]) {
+		fmt.Fprintf(os.Stderr, "Usage: astro %s <command> [flags] [arguments]\n\n", args[0])
+		writeCommandsOf(os.Stderr, args[0]+" ")
+		os.Exit(2)
+	}
+	cmd, _ := lookupCommand("analyze")
+	return cmd, args
+}
+
+func isDirectory(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+func lookupCommand(name string) (cliCommand, bool) {
+	for _, cmd := range cliCommands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+func isCommandGroup(name string) bool {
+	for _, cmd := range cliCommands {
+		if strings.HasPrefix(cmd.name, name+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCommands(w io.Writer) {
+	fmt.Fprintln(w, "Commands:")
+	writeCommandsOf(w, "")
+}
+
+func writeCommandsOf(w io.Writer, prefix string) {
+	for _, cmd := range cliCommands {
+		if strings.HasPrefix(cmd.name, prefix) {
+			fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.summary)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		want   []string
+		format string
+	}{
+		{name: "flags first", args: []string{"-format", "json", "Name"}, want: []string{"Name"}, format: "json"},
+		{name: "flags after positionals", args: []string{"Name", "-format", "json", "./..."}, want: []string{"Name", "./..."}, format: "json"},
+		{name: "dash dash ends flags", args: []string{"-format", "json", "--", "-Name", "-format"}, want: []string{"-Name", "-format"}, format: "json"},
+		{name: "dash dash after positionals", args: []string{"Name", "--", "-format", "dot"}, want: []string{"Name", "-format", "dot"}, format: "text"},
+		{name: "dash dash alone", args: []string{"--"}, want: []string{}, format: "text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			format := fs.String("format", "text", "")
+			if got := parseInterspersed(fs, tt.args); !reflect.DeepEqual(got, tt.want) || *format != tt.format {
+				t.Errorf("parseInterspersed() = %q, -format %s, want %q, -format %s", got, *format, tt.want, tt.format)
+			}
+		})
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, dir := range []string{"check", "graph"} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args     []string
+		name     string
+		wantArgs []string
+	}{
+		{args: []string{}, name: "analyze", wantArgs: []string{}},
+		{args: []string{"-all", "./..."}, name: "analyze", wantArgs: []string{"-all", "./..."}},
+		{args: []string{"impact", "Store"}, name: "impact", wantArgs: []string{"Store"}},
+		{args: []string{"query", "impact", "Store"}, name: "query impact", wantArgs: []string{"Store"}},
+		{args: []string{"api", "diff", "a.json", "b.json"}, name: "api diff", wantArgs: []string{"a.json", "b.json"}},
+		{args: []string{"check", "-layers", "layers.txt"}, name: "analyze", wantArgs: []string{"check", "-layers", "layers.txt"}},
+		{args: []string{"graph", "imports"}, name: "analyze", wantArgs: []string{"graph", "imports"}},
+	}
+
+	for _, tt := range tests {
+		cmd, args := findCommand(tt.args)
+		if cmd.name != tt.name || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("findCommand(%q) = %s %q, want %s %q", tt.args, cmd.name, args, tt.name, tt.wantArgs)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	SortByDependency = "dependency"
+	SortByName       = "name"
+	SortByComplexity = "complexity"
+	SortByCognitive  = "cognitive"
+	SortByStatements = "statements"
+	SortByNesting    = "nesting"
+	SortByReturns    = "returns"
+)
+
+// bodyMetrics measures a function body from the nodes of a single walk,
+// fed in pre-order by enter and in post-order by leave. Nested function
+// literals count towards the enclosing function.
+//   - cyclomatic complexity is one plus the number of decision points: if,
+//     for, non-default case clauses, && and ||;
+//   - statements are those of every statement list, so the init and post
+//     statements of a for loop are not counted;
+//   - return points are the return statements of the function itself,
+//     outside function literals, plus one when control can reach the end
+//     of the body;
+//   - cognitive complexity follows the cognitive complexity specification:
+//     control flow structures cost one plus their nesting level, else
+//     branches, labeled jumps, sequences of mixed boolean operators and
+//     recursion cost one each;
+//   - nesting is the deepest nesting of control flow structures and
+//     function literals, keeping else if chains at the level of the first
+//     if and leaving out conditions and headers.
+type bodyMetrics struct {
+	fn         *ast.FuncDecl
+	stack      []bodyFrame
+	literals   int
+	cyclomatic int
+	cognitive  int
+	statements int
+	returns    int
+	nesting    int
+}
+
+// bodyFrame is a node being walked: nesting is its cognitive nesting level,
+// depth its control flow depth. Headers are outside the depth measure and
+// skipped nodes outside the cognitive one.
+type bodyFrame struct {
+	node    ast.Node
+	nesting int
+	depth   int
+	header  bool
+	skipped bool
+	elseIf  bool
+	orElse  bool
+}
+
+func newBodyMetrics(fn *ast.FuncDecl) *bodyMetrics {
+	return &bodyMetrics{fn: fn, cyclomatic: 1}
+}
+
+func (bm *bodyMetrics) enter(n ast.Node) {
+	var frame bodyFrame
+	if len(bm.stack) == 0 {
+		if n != bm.fn.Body {
+			return
+		}
+		frame.node = n
+	} else {
+		frame = bm.child(bm.stack[len(bm.stack)-1], n)
+	}
+	bm.stack = append(bm.stack, frame)
+
+	if !frame.header && frame.depth > bm.nesting {
+		bm.nesting = frame.depth
+	}
+	bm.count(n)
+	if !frame.skipped {
+		bm.cognitive += bm.cost(frame)
+	}
+}
+
+func (bm *bodyMetrics) leave(n ast.Node) {
+	if len(bm.stack) == 0 || bm.stack[len(bm.stack)-1].node != n {
+		return
+	}
+	if _, ok := n.(*ast.FuncLit); ok {
+		bm.literals--
+	}
+	bm.stack = bm.stack[:len(bm.stack)-1]
+}
+
+// fill stores the metrics in item once the body has been left.
+func (bm *bodyMetrics) fill(item *GoFunction) {
+	if bm.fn.Body == nil {
+		return
+	}
+	item.Cyclomatic = bm.cyclomatic
+	item.Cognitive = bm.cognitive
+	item.Statements = bm.statements
+	item.Nesting = bm.nesting
+	item.ReturnPoints = bm.returns
+	if !isTerminating(bm.fn.Body) {
+		item.ReturnPoints++
+	}
+}
+
+// child derives the frame of n from the frame of its parent.
+func (bm *bodyMetrics) child(parent bodyFrame, n ast.Node) bodyFrame {
+	frame := bodyFrame{
+		node:    n,
+		nesting: parent.nesting,
+		depth:   parent.depth,
+		header:  parent.header,
+		skipped: parent.skipped,
+	}
+
+	var body ast.Node
+	switch p := parent.node.(type) {
+	case *ast.IfStmt:
+		body = p.Body
+		if n == p.Else {
+			if _, ok := n.(*ast.IfStmt); ok {
+				frame.elseIf = true
+				return frame
+			}
+			frame.orElse = true
+			body = n
+		}
+	case *ast.ForStmt:
+		body = p.Body
+	case *ast.RangeStmt:
+		body = p.Body
+		frame.skipped = frame.skipped || n == p.Key || n == p.Value
+	case *ast.SwitchStmt:
+		body = p.Body
+	case *ast.TypeSwitchStmt:
+		body = p.Body
+	case *ast.SelectStmt:
+		body = p.Body
+	case *ast.FuncLit:
+		body = p.Body
+		frame.skipped = frame.skipped || n == p.Type
+	case *ast.CaseClause:
+		if _, ok := n.(ast.Expr); ok {
+			frame.nesting--
+		}
+		return frame
+	case *ast.CommClause:
+		if n == p.Comm {
+			frame.nesting--
+		}
+		return frame
+	default:
+		return frame
+	}
+
+	if n == body {
+		frame.nesting++
+		frame.depth++
+	} else {
+		frame.header = true
+	}
+	return frame
+}
+
+// count adds n to the cyclomatic complexity, statements and return points.
+func (bm *bodyMetrics) count(n ast.Node) {
+	var list []ast.Stmt
+	switch node := n.(type) {
+	case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+		bm.cyclomatic++
+	case *ast.CaseClause:
+		if node.List != nil {
+			bm.cyclomatic++
+		}
+		list = node.Body
+	case *ast.CommClause:
+		if node.Comm != nil {
+			bm.cyclomatic++
+		}
+		list = node.Body
+	case *ast.BinaryExpr:
+		if isLogical(node) {
+			bm.cyclomatic++
+		}
+	case *ast.BlockStmt:
+		list = node.List
+	case *ast.FuncLit:
+		bm.literals++
+	case *ast.ReturnStmt:
+		if bm.literals == 0 {
+			bm.returns++
+		}
+	}
+
+	for _, stmt := range list {
+		switch stmt.(type) {
+		case *ast.EmptyStmt, *ast.CaseClause, *ast.CommClause:
+		default:
+			bm.statements++
+		}
+	}
+}
+
+// cost returns the cognitive complexity n adds at the nesting of frame.
+func (bm *bodyMetrics) cost(frame bodyFrame) int {
+	switch node := frame.node.(type) {
+	case *ast.IfStmt:
+		if frame.elseIf {
+			return 1
+		}
+		return 1 + frame.nesting
+	case *ast.BlockStmt:
+		if frame.orElse {
+			return 1
+		}
+	case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+		return 1 + frame.nesting
+	case *ast.BranchStmt:
+		if node.Label != nil || node.Tok == token.GOTO {
+			return 1
+		}
+	case *ast.BinaryExpr:
+		if isLogical(node) && !bm.inLogicalExpr() {
+			return logicalSequences(node)
+		}
+	case *ast.CallExpr:
+		if ident, ok := node.Fun.(*ast.Ident); ok && ident.Name == bm.fn.Name.Name {
+			return 1
+		}
+	}
+	return 0
+}
+
+// inLogicalExpr reports whether the node on top of the stack is an operand
+// of an enclosing && or || expression, looking through parentheses.
+func (bm *bodyMetrics) inLogicalExpr() bool {
+	for i := len(bm.stack) - 2; i >= 0; i-- {
+		switch node := bm.stack[i].node.(type) {
+		case *ast.ParenExpr:
+			continue
+		case *ast.BinaryExpr:
+			return isLogical(node)
+		}
+		return false
+	}
+	return false
+}
+
+func isLogical(expr *ast.BinaryExpr) bool {
+	return expr.Op == token.LAND || expr.Op == token.LOR
+}
+
+// logicalOperators lists the && and || operators of a boolean expression
+// from left to right, looking through parentheses.
+func logicalOperators(expr ast.Expr) []token.Token {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return logicalOperators(e.X)
+	case *ast.BinaryExpr:
+		if isLogical(e) {
+			ops := logicalOperators(e.X)
+			ops = append(ops, e.Op)
+			return append(ops, logicalOperators(e.Y)...)
+		}
+	}
+	return nil
+}
+
+// logicalSequences counts runs of the same boolean operator, so a && b && c
+// costs one and a && b || c costs two.
+func logicalSequences(expr ast.Expr) int {
+	sequences := 0
+	var previous token.Token
+	for _, op := range logicalOperators(expr) {
+		if op != previous {
+			sequences++
+			previous = op
+		}
+	}
+	return sequences
+}
+
+// isTerminating reports whether control can't reach the end of stmt,
+// following the terminating statement rules of the Go specification
+// without tracking break statements.
+func isTerminating(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return s.Tok == token.GOTO
+	case *ast.ExprStmt:
+		call, ok := s.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Fun.(*ast.Ident)
+		return ok && ident.Name == "panic"
+	case *ast.BlockStmt:
+		return len(s.List) > 0 && isTerminating(s.List[len(s.List)-1])
+	case *ast.LabeledStmt:
+		return isTerminating(s.Stmt)
+	case *ast.IfStmt:
+		return s.Else != nil && isTerminating(s.Body) && isTerminating(s.Else)
+	case *ast.ForStmt:
+		return s.Cond == nil
+	case *ast.SwitchStmt:
+		return clausesTerminate(s.Body, true)
+	case *ast.TypeSwitchStmt:
+		return clausesTerminate(s.Body, true)
+	case *ast.SelectStmt:
+		return clausesTerminate(s.Body, false)
+	}
+	return false
+}
+
+func clausesTerminate(body *ast.BlockStmt, needDefault bool) bool {
+	hasDefault := false
+	for _, clause := range body.List {
+		var list []ast.Stmt
+		switch c := clause.(type) {
+		case *ast.CaseClause:
+			hasDefault = hasDefault || c.List == nil
+			list = c.Body
+		case *ast.CommClause:
+			list = c.Body
+		}
+		if len(list) == 0 {
+			return false
+		}
+		last := list[len(list)-1]
+		if branch, ok := last.(*ast.BranchStmt); ok && branch.Tok == token.FALLTHROUGH {
+			continue
+		}
+		if !isTerminating(last) {
+			return false
+		}
+	}
+	return hasDefault || !needDefault
+}
+
+// FunctionMetricProvider reads one of the body metrics of a function.
+type FunctionMetricProvider struct {
+	metric string
+}
+
+func NewFunctionMetricProvider(metric string) (*FunctionMetricProvider, error) {
+	switch metric {
+	case SortByComplexity, SortByCognitive, SortByStatements, SortByNesting, SortByReturns:
+		return &FunctionMetricProvider{metric: metric}, nil
+	}
+	return nil, fmt.Errorf("unknown function metric %q", metric)
+}
+
+func (fmp *FunctionMetricProvider) GetMetric(item GoFunction) int {
+	switch fmp.metric {
+	case SortByComplexity:
+		return item.Cyclomatic
+	case SortByCognitive:
+		return item.Cognitive
+	case SortByStatements:
+		return item.Statements
+	case SortByNesting:
+		return item.Nesting
+	case SortByReturns:
+		return item.ReturnPoints
+	}
+	return 0
+}
+
+type MetricProvider[T any] interface {
+	GetMetric(item T) int
+}
+
+// MetricDependencyResolver orders items by a metric, highest first, and by
+// name among equal values.
+type MetricDependencyResolver[T any] struct {
+	metricProvider   MetricProvider[T]
+	typeNameProvider TypeNameProvider[T]
+}
+
+func NewMetricDependencyResolver[T any](
+	metricProvider MetricProvider[T],
+	nameProvider TypeNameProvider[T],
+) *MetricDependencyResolver[T] {
+	return &MetricDependencyResolver[T]{
+		metricProvider:   metricProvider,
+		typeNameProvider: nameProvider,
+	}
+}
+
+func (mdr *MetricDependencyResolver[T]) ResolveDependencies(items []T) []T {
+	result := make([]T, len(items))
+	copy(result, items)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		mi, mj := mdr.metricProvider.GetMetric(result[i]), mdr.metricProvider.GetMetric(result[j])
+		if mi != mj {
+			return mi > mj
+		}
+		return mdr.typeNameProvider.GetTypeName(result[i]) < mdr.typeNameProvider.GetTypeName(result[j])
+	})
+
+	return result
+}
+
+// FunctionComplexityFilter hides functions below the minimum complexities
+// from the listing.
+type FunctionComplexityFilter struct {
+	MinCyclomatic int
+	MinCognitive  int
+}
+
+func (fcf *FunctionComplexityFilter) IsValid(item GoFunction) bool {
+	return item.Cyclomatic >= fcf.MinCyclomatic && item.Cognitive >= fcf.MinCognitive
+}
+
+func formatFunctionMetrics(item GoFunction) string {
+	if item.Cyclomatic == 0 {
+		return ""
+	}
+	parts := []string{
+		fmt.Sprintf("cyclomatic %d", item.Cyclomatic),
+		fmt.Sprintf("cognitive %d", item.Cognitive),
+		fmt.Sprintf("statements %d", item.Statements),
+		fmt.Sprintf("nesting %d", item.Nesting),
+		fmt.Sprintf("return points %d", item.ReturnPoints),
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printFunctionRanking lists the functions of every file ordered by the
+// metric, after the per-file listings.
+func printFunctionRanking(w io.Writer, sources []SourceItems[GoFunction], sortBy string, filter ItemValidator[GoFunction]) {
+	metric, err := NewFunctionMetricProvider(sortBy)
+	if err != nil {
+		return
+	}
+
+	functions := make([]GoFunction, 0)
+	for _, src := range sources {
+		for _, fn := range src.Items {
+			if filter == nil || filter.IsValid(fn) {
+				functions = append(functions, fn)
+			}
+		}
+	}
+	functions = NewMetricDependencyResolver[GoFunction](metric, &FunctionTypeNameProvider{}).ResolveDependencies(functions)
+
+	fmt.Fprintf(w, "\n--- Functions by %s (All Files) ---\n", sortBy)
+	renderer := &FunctionItemRenderer{}
+	for _, fn := range functions {
+		header := strings.SplitN(renderer.RenderItem(fn), "\n", 2)[0]
+		fmt.Fprintf(w, "%4d  %s\n      %s\n", metric.GetMetric(fn), header, formatFunctionMetrics(fn))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestAnalyzeFunctionBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   [5]int // cyclomatic, cognitive, statements, nesting, return points
+	}{
+		{
+			name:   "empty",
+			source: `func f() {}`,
+			want:   [5]int{1, 0, 0, 0, 1},
+		},
+		{
+			name:   "straight line",
+			source: `func f() int { x := 1; return x }`,
+			want:   [5]int{1, 0, 2, 0, 1},
+		},
+		{
+			name: "nested loops with a labeled continue",
+			source: `func sumOfPrimes(max int) int {
+	total := 0
+OUT:
+	for i := 1; i <= max; i++ {
+		for j := 2; j < i; j++ {
+			if i%j == 0 {
+				continue OUT
+			}
+		}
+		total += i
+	}
+	return total
+}`,
+			want: [5]int{4, 7, 7, 3, 1},
+		},
+		{
+			name: "else if chain with mixed operators",
+			source: `func classify(a, b, c bool) string {
+	if a && b || c {
+		return "x"
+	} else if a {
+		return "y"
+	} else {
+		return "z"
+	}
+}`,
+			want: [5]int{5, 5, 4, 1, 3},
+		},
+		{
+			name: "switch with a recursive closure",
+			source: `func walk(n int, ch chan int) {
+	switch {
+	case n > 0:
+		go func() {
+			walk(n-1, ch)
+		}()
+	case n < 0:
+		ch <- n
+	default:
+	}
+}`,
+			want: [5]int{3, 2, 4, 2, 1},
+		},
+		{
+			name: "endless loop around a select",
+			source: `func must(err error) int {
+	for {
+		select {
+		case <-time.After(0):
+			return 1
+		default:
+			panic(err)
+		}
+	}
+}`,
+			want: [5]int{3, 3, 4, 2, 1},
+		},
+		{
+			name: "returns inside function literals don't count",
+			source: `func f() func() int {
+	g := func() int { return 1 }
+	return g
+}`,
+			want: [5]int{1, 0, 3, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "", "package p\n\n"+tt.source, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			visitor := NewGenericVisitor(NewFunctionNodeVisitor(fset, "p"), NewFunctionResultCollector(), &FunctionValidator{})
+			traversal := NewDeclarationTraversal()
+			traversal.Register(token.FUNC, NewAnalysisEngine(visitor, nil, nil, nil))
+			traversal.Walk(file)
+			results := visitor.GetResults()
+			if len(results) != 1 {
+				t.Fatalf("got %d functions, want 1", len(results))
+			}
+			item := results[0]
+			got := [5]int{item.Cyclomatic, item.Cognitive, item.Statements, item.Nesting, item.ReturnPoints}
+			if got != tt.want {
+				t.Errorf("cyclomatic, cognitive, statements, nesting, return points = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogicalSequences(t *testing.T) {
+	tests := map[string]int{
+		"a":                  0,
+		"a && b && c":        1,
+		"a && b || c":        2,
+		"a && (b || c)":      2,
+		"a || (b && c) || d": 3,
+		"(a || b) || c":      1,
+		"a == b && !c":       1,
+	}
+
+	for src, want := range tests {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := logicalSequences(expr); got != want {
+			t.Errorf("logicalSequences(%s) = %d, want %d", src, got, want)
+		}
+	}
+}
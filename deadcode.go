@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+// DeadCode is an unused declaration. Key identifies it independently of
+// its position, e.g. "func example.com/app/store.compact", and is what
+// baseline files list.
+type DeadCode struct {
+	Key        string `json:"key"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Position   string `json:"position"`
+	Reason     string `json:"reason"`
+	Confidence string `json:"confidence"`
+}
+
+// deadCodeFinder tracks the references of all analyzed packages. Uses
+// from within a declaration's own body don't count, and struct fields are
+// only read by uses that are not the target of an assignment or a key of
+// a composite literal.
+type deadCodeFinder struct {
+	fset     *token.FileSet
+	used     map[types.Object]bool
+	read     map[types.Object]bool
+	named    []*types.TypeName
+	findings []DeadCode
+}
+
+// FindDeadCode reports unexported functions, methods, types, constants and
+// variables that are never used, interfaces without implementations and
+// struct fields that are never read. Confidence is lowered where
+// reflection, unsafe, go:linkname or type errors may hide uses.
+func FindDeadCode(ctx context.Context, dirs []string, graph *ImportGraph, opts AnalysisOptions, resolver PackageResolver) ([]DeadCode, error) {
+	fset, packages, err := loadTypedPackages(ctx, dirs, graph, opts, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	finder := &deadCodeFinder{
+		fset:     fset,
+		used:     make(map[types.Object]bool),
+		read:     make(map[types.Object]bool),
+		named:    namedTypes(packages),
+		findings: make([]DeadCode, 0),
+	}
+	for _, pkg := range packages {
+		finder.collectUses(pkg)
+	}
+	for _, pkg := range packages {
+		finder.checkPackage(pkg)
+	}
+
+	sort.Slice(finder.findings, func(i, j int) bool {
+		a, b := finder.findings[i], finder.findings[j]
+		if fa, fb := positionFile(a.Position), positionFile(b.Position); fa != fb {
+			return fa < fb
+		}
+		if la, lb := positionLine(a.Position), positionLine(b.Position); la != lb {
+			return la < lb
+		}
+		return a.Key < b.Key
+	})
+	return finder.findings, nil
+}
+
+func originObject(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Origin()
+	case *types.Var:
+		return o.Origin()
+	}
+	return obj
+}
+
+func (dcf *deadCodeFinder) collectUses(pkg *typedPackage) {
+	for _, file := range pkg.files {
+		writes := make(map[*ast.Ident]bool)
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.AssignStmt:
+				if node.Tok != token.ASSIGN && node.Tok != token.DEFINE {
+					break
+				}
+				for _, lhs := range node.Lhs {
+					for paren, ok := lhs.(*ast.ParenExpr); ok; paren, ok = lhs.(*ast.ParenExpr) {
+						lhs = paren.X
+					}
+					if sel, ok := lhs.(*ast.SelectorExpr); ok {
+						writes[sel.Sel] = true
+					}
+				}
+			case *ast.CompositeLit:
+				for _, elt := range node.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						if key, ok := kv.Key.(*ast.Ident); ok {
+							writes[key] = true
+						}
+					}
+				}
+			}
+			return true
+		})
+
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				dcf.collectDeclUses(pkg, d, writes, pkg.info.Defs[d.Name])
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					self := make([]types.Object, 0)
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						self = append(self, pkg.info.Defs[s.Name])
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							self = append(self, pkg.info.Defs[name])
+						}
+					}
+					dcf.collectDeclUses(pkg, spec, writes, self...)
+				}
+			}
+		}
+	}
+}
+
+func (dcf *deadCodeFinder) collectDeclUses(pkg *typedPackage, node ast.Node, writes map[*ast.Ident]bool, self ...types.Object) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		obj := pkg.info.Uses[ident]
+		if obj == nil {
+			return true
+		}
+		obj = originObject(obj)
+		if v, ok := obj.(*types.Var); ok && v.IsField() && !writes[ident] {
+			dcf.read[obj] = true
+		}
+		for _, s := range self {
+			if s != nil && obj == originObject(s) {
+				return true
+			}
+		}
+		dcf.used[obj] = true
+		return true
+	})
+}
+
+func (dcf *deadCodeFinder) checkPackage(pkg *typedPackage) {
+	if pkg.checked == nil {
+		return
+	}
+
+	// Package-wide reasons for doubt
+	doubt := ""
+	for _, file := range pkg.files {
+		for _, spec := range file.Imports {
+			switch spec.Path.Value {
+			case `"reflect"`:
+				doubt = "package uses reflect"
+			case `"unsafe"`:
+				if doubt == "" {
+					doubt = "package uses unsafe"
+				}
+			}
+		}
+	}
+	if pkg.errors > 0 {
+		doubt = fmt.Sprintf("package has %d type error(s)", pkg.errors)
+	}
+	linknamed := make(map[string]bool)
+	exported := make(map[string]bool)
+	for _, file := range pkg.files {
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				fields := strings.Fields(comment.Text)
+				if len(fields) >= 2 && fields[0] == "//go:linkname" {
+					linknamed[fields[1]] = true
+				}
+				if len(fields) >= 2 && fields[0] == "//export" {
+					exported[fields[1]] = true
+				}
+			}
+		}
+	}
+
+	// Unexported methods can only satisfy interfaces of their own package
+	interfaceMethods := make(map[string]bool)
+	scope := pkg.checked.Scope()
+	for _, name := range scope.Names() {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok {
+			if iface, ok := tn.Type().Underlying().(*types.Interface); ok {
+				for i := 0; i < iface.NumMethods(); i++ {
+					interfaceMethods[iface.Method(i).Name()] = true
+				}
+			}
+		}
+	}
+
+	report := func(kind, name string, obj types.Object, reason, confidence string) {
+		if linknamed[obj.Name()] {
+			confidence, reason = ConfidenceLow, reason+" (go:linkname)"
+		} else if doubt != "" {
+			confidence, reason = ConfidenceLow, reason+" ("+doubt+")"
+		}
+		dcf.findings = append(dcf.findings, DeadCode{
+			Key:        kind + " " + pkg.path + "." + name,
+			Kind:       kind,
+			Name:       pkg.checked.Name() + "." + name,
+			Position:   dcf.fset.Position(obj.Pos()).String(),
+			Reason:     reason,
+			Confidence: confidence,
+		})
+	}
+
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		unused := !obj.Exported() && name != "_" && !dcf.used[obj]
+		switch o := obj.(type) {
+		case *types.Func:
+			if unused && name != "init" && !(name == "main" && pkg.checked.Name() == "main") && !exported[name] {
+				report("func", name, o, "never used", ConfidenceHigh)
+			}
+		case *types.Const:
+			if unused {
+				report("const", name, o, "never used", ConfidenceHigh)
+			}
+		case *types.Var:
+			if unused {
+				report("var", name, o, "never used", ConfidenceHigh)
+			}
+		case *types.TypeName:
+			if unused {
+				report("type", name, o, "never used", ConfidenceHigh)
+				continue
+			}
+			dcf.checkType(o, interfaceMethods, report)
+		}
+	}
+}
+
+func (dcf *deadCodeFinder) checkType(tn *types.TypeName, interfaceMethods map[string]bool, report func(kind, name string, obj types.Object, reason, confidence string)) {
+	named, ok := tn.Type().(*types.Named)
+	if !ok {
+		return
+	}
+
+	if iface, ok := named.Underlying().(*types.Interface); ok {
+		if iface.NumMethods() == 0 {
+			return
+		}
+		for _, candidate := range dcf.named {
+			if named.TypeParams().Len() > 0 {
+				// Generic interfaces can't be checked without an instantiation,
+				// so match their method names
+				if hasMethodNames(candidate.Type(), iface) {
+					return
+				}
+			} else if _, ok := implementsInterface(candidate.Type(), iface); ok {
+				return
+			}
+		}
+		confidence := ConfidenceHigh
+		if tn.Exported() {
+			// Implementations may live outside the analyzed packages
+			confidence = ConfidenceMedium
+		}
+		report("interface", tn.Name(), tn, "has no implementations among the analyzed types", confidence)
+		return
+	}
+
+	for i := 0; i < named.NumMethods(); i++ {
+		method := named.Method(i)
+		if method.Exported() || method.Name() == "_" || dcf.used[method] || interfaceMethods[method.Name()] {
+			continue
+		}
+		report("method", tn.Name()+"."+method.Name(), method, "never used", ConfidenceHigh)
+	}
+
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if field.Embedded() || field.Name() == "_" || dcf.read[field] {
+			continue
+		}
+		confidence := ConfidenceHigh
+		reason := "never read"
+		switch {
+		case st.Tag(i) != "":
+			confidence, reason = ConfidenceLow, "never read (tagged for encoding)"
+		case field.Exported():
+			// Exported fields are read by fmt, encoding and reflection
+			confidence = ConfidenceMedium
+		}
+		report("field", tn.Name()+"."+field.Name(), field, reason, confidence)
+	}
+}
+
+func hasMethodNames(typ types.Type, iface *types.Interface) bool {
+	methods := types.NewMethodSet(types.NewPointer(typ))
+	for i := 0; i < iface.NumMethods(); i++ {
+		if methods.Lookup(iface.Method(i).Pkg(), iface.Method(i).Name()) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadDeadCodeBaseline reads the keys of known false positives, one per
+// line. Blank lines and lines starting with "#" are ignored.
+func LoadDeadCodeBaseline(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	baseline := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		baseline[line] = true
+	}
+	return baseline, scanner.Err()
+}
+
+func WriteDeadCodeBaseline(path string, findings []DeadCode) error {
+	keys := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		keys = appendUnique(keys, finding.Key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString("# astro dead code baseline: findings listed here are not reported\n")
+	for _, key := range keys {
+		builder.WriteString(key + "\n")
+	}
+	return os.WriteFile(path, []byte(builder.String()), 0644)
+}
+
+// FilterDeadCode drops the findings in the baseline and returns the rest
+// with the number suppressed.
+func FilterDeadCode(findings []DeadCode, baseline map[string]bool) ([]DeadCode, int) {
+	kept := make([]DeadCode, 0, len(findings))
+	for _, finding := range findings {
+		if !baseline[finding.Key] {
+			kept = append(kept, finding)
+		}
+	}
+	return kept, len(findings) - len(kept)
+}
+
+func WriteDeadCode(w io.Writer, findings []DeadCode, suppressed int, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(struct {
+			Findings   []DeadCode `json:"findings"`
+			Suppressed int        `json:"suppressed"`
+		}{findings, suppressed})
+	case "text", "":
+		fmt.Fprintln(w, "\n--- Dead Code ---")
+		for _, finding := range findings {
+			fmt.Fprintf(w, "%s: %s %s %s [%s]\n", finding.Position, finding.Kind, finding.Name, finding.Reason, finding.Confidence)
+		}
+		fmt.Fprintf(w, "%d finding(s)", len(findings))
+		if suppressed > 0 {
+			fmt.Fprintf(w, ", %d suppressed by baseline", suppressed)
+		}
+		fmt.Fprintln(w)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFindDeadCode(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod": "module example.com/m\n",
+		"store/store.go": `package store
+
+type Store interface {
+	Get() string
+}
+
+type closer interface {
+	close()
+}
+
+type reader interface {
+	read()
+}
+
+var _ reader
+
+type file struct {
+	name string
+	size int
+	Tag  string ` + "`json:\"tag\"`" + `
+}
+
+func (f *file) close() {}
+
+func (f *file) describe() string { return f.name }
+
+const limit = 10
+
+var registry = map[string]*file{}
+
+func Open(name string) closer {
+	f := &file{name: name, size: 0}
+	registry[name] = f
+	return f
+}
+
+func unused() { unused() }
+`,
+		"codec/codec.go": "package codec\n\nimport \"reflect\"\n\nfunc kind(v any) reflect.Kind { return reflect.TypeOf(v).Kind() }\n",
+	})
+	opts, resolver := analyzeTestModule(t, root)
+	findings, err := FindDeadCode(context.Background(), []string{root}, opts.Graph, opts, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"interface example.com/m/store.Store":      ConfidenceMedium,
+		"interface example.com/m/store.reader":     ConfidenceHigh,
+		"field example.com/m/store.file.size":      ConfidenceHigh,
+		"field example.com/m/store.file.Tag":       ConfidenceLow,
+		"method example.com/m/store.file.describe": ConfidenceHigh,
+		"const example.com/m/store.limit":          ConfidenceHigh,
+		"func example.com/m/store.unused":          ConfidenceHigh,
+		"func example.com/m/codec.kind":            ConfidenceLow,
+	}
+	got := make(map[string]string)
+	for _, finding := range findings {
+		got[finding.Key] = finding.Confidence
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindDeadCode() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterDeadCode(t *testing.T) {
+	findings := []DeadCode{{Key: "func m/a.f"}, {Key: "func m/a.g"}, {Key: "type m/a.t"}}
+	kept, suppressed := FilterDeadCode(findings, map[string]bool{"func m/a.g": true, "func m/a.gone": true})
+	if want := []DeadCode{{Key: "func m/a.f"}, {Key: "type m/a.t"}}; !reflect.DeepEqual(kept, want) || suppressed != 1 {
+		t.Errorf("FilterDeadCode() = %v, %d, want %v, 1", kept, suppressed, want)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Diagnostic is a problem with one file that the analysis continued past.
+type Diagnostic struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (d Diagnostic) Position() string {
+	if d.Line == 0 {
+		return d.Filename
+	}
+	return fmt.Sprintf("%s:%d:%d", d.Filename, d.Line, d.Column)
+}
+
+// Diagnostics collects the errors of files that couldn't be read or parsed
+// completely, so one bad file doesn't stop the analysis of the others.
+// Several passes parse the same files, so each error is recorded once.
+type Diagnostics struct {
+	mu     sync.Mutex
+	strict bool
+	seen   map[Diagnostic]bool
+	list   []Diagnostic
+}
+
+// NewDiagnostics returns an empty collector. A strict one records nothing
+// and hands every error back, so the analysis fails fast.
+func NewDiagnostics(strict bool) *Diagnostics {
+	return &Diagnostics{strict: strict, seen: make(map[Diagnostic]bool), list: make([]Diagnostic, 0)}
+}
+
+func (d *Diagnostics) Strict() bool {
+	return d.strict
+}
+
+// Report records err, found in filename, and returns nil, or returns err
+// when d is strict or nil. Parse errors are recorded at their positions.
+func (d *Diagnostics) Report(filename string, err error) error {
+	if d == nil || d.strict {
+		return err
+	}
+
+	var found []Diagnostic
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		for _, e := range list {
+			found = append(found, Diagnostic{Filename: e.Pos.Filename, Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg})
+		}
+	} else {
+		found = append(found, Diagnostic{Filename: filename, Message: err.Error()})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, diagnostic := range found {
+		if !d.seen[diagnostic] {
+			d.seen[diagnostic] = true
+			d.list = append(d.list, diagnostic)
+		}
+	}
+	return nil
+}
+
+func (d *Diagnostics) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.list)
+}
+
+// HasErrors reports whether anything was recorded for filename, whose
+// declarations may then be incomplete.
+func (d *Diagnostics) HasErrors(filename string) bool {
+	if d == nil {
+		return false
+	}
+	filename = filepath.Clean(filename)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, diagnostic := range d.list {
+		if filepath.Clean(diagnostic.Filename) == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the diagnostics ordered by position.
+func (d *Diagnostics) List() []Diagnostic {
+	d.mu.Lock()
+	list := append([]Diagnostic(nil), d.list...)
+	d.mu.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return list
+}
+
+// hasPackageClause reports whether a file that failed to parse got as far
+// as its package clause, so what was parsed of it can be analyzed.
+func hasPackageClause(file *ast.File) bool {
+	return file != nil && file.Name != nil && file.Name.Name != ""
+}
+
+// WriteDiagnostics prints the diagnostics and how many files they are in.
+func WriteDiagnostics(w io.Writer, diagnostics []Diagnostic) {
+	if len(diagnostics) == 0 {
+		return
+	}
+	files := make(map[string]bool)
+	fmt.Fprintf(w, "\n--- Diagnostics ---\n")
+	for _, diagnostic := range diagnostics {
+		files[diagnostic.Filename] = true
+		fmt.Fprintf(w, "%s: %s\n", diagnostic.Position(), diagnostic.Message)
+	}
+	fmt.Fprintf(w, "%d error(s) in %d file(s); declarations after an error may be missing\n", len(diagnostics), len(files))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// brokenModule has a file with a syntax error after a declaration, one
+// without a package clause and one that can't be read.
+func brokenModule(t *testing.T) string {
+	t.Helper()
+	root := writeTestModule(t, map[string]string{
+		"go.mod":         "module example.com/b\n",
+		"app/good.go":    "package app\n\nfunc Good() {}\n",
+		"app/broken.go":  "package app\n\nfunc Before() {}\n\nfunc Broken( {\n}\n",
+		"app/nopkg.go":   "func Orphan() {}\n",
+		"lib/lib.go":     "package lib\n\nfunc Lib() {}\n",
+		"lib/missing.go": "",
+	})
+	missing := filepath.Join(root, "lib", "missing.go")
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "nowhere.go"), missing); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	return root
+}
+
+func TestDiagnosticsAggregate(t *testing.T) {
+	root := brokenModule(t)
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := NewDiagnostics(false)
+	opts := AnalysisOptions{SelectedTypes: make(map[string]bool), Diagnostics: diagnostics, Jobs: 4, Output: io.Discard}
+	opts.ensureCollectors("functions")
+
+	// The import graph and the walk parse the same files; each error is
+	// recorded once
+	if opts.Graph, err = BuildImportGraph([]string{root}, opts, resolver); err != nil {
+		t.Fatal(err)
+	}
+	if err := walkDirectory(context.Background(), root, opts); err != nil {
+		t.Fatalf("walkDirectory() failed despite non-strict diagnostics: %v", err)
+	}
+
+	functions := make([]string, 0)
+	for _, src := range opts.FunctionSources.CollectResults() {
+		for _, fn := range src.Items {
+			functions = append(functions, fn.Name)
+		}
+	}
+	// The declarations of broken.go are analyzed from the partial AST
+	if want := []string{"Before", "Broken", "Good", "Lib"}; !reflect.DeepEqual(functions, want) {
+		t.Errorf("functions = %v, want %v", functions, want)
+	}
+
+	got := make([]string, 0)
+	for _, d := range diagnostics.List() {
+		rel, _ := filepath.Rel(root, d.Filename)
+		got = append(got, filepath.ToSlash(rel)+" "+strings.TrimPrefix(d.Position(), d.Filename))
+	}
+	want := []string{"app/broken.go :5:14", "app/broken.go :6:1", "app/nopkg.go :1:1", "lib/missing.go "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics at %v, want %v", got, want)
+	}
+
+	for file, want := range map[string]bool{"app/broken.go": true, "app/good.go": false, "lib/missing.go": true, "lib/lib.go": false} {
+		if got := diagnostics.HasErrors(filepath.Join(root, file)); got != want {
+			t.Errorf("HasErrors(%s) = %v, want %v", file, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	WriteDiagnostics(&out, diagnostics.List())
+	if !strings.Contains(out.String(), "4 error(s) in 3 file(s)") {
+		t.Errorf("WriteDiagnostics() =\n%s", out.String())
+	}
+}
+
+func TestDiagnosticsStrict(t *testing.T) {
+	root := brokenModule(t)
+	for _, dir := range []string{"app", "lib"} {
+		diagnostics := NewDiagnostics(true)
+		opts := AnalysisOptions{SelectedTypes: map[string]bool{"functions": true}, Diagnostics: diagnostics, Output: io.Discard}
+		err := walkDirectory(context.Background(), filepath.Join(root, dir), opts)
+		if err == nil {
+			t.Errorf("%s: walkDirectory() succeeded with -strict", dir)
+		}
+		if diagnostics.Len() != 0 {
+			t.Errorf("%s: strict diagnostics recorded %v", dir, diagnostics.List())
+		}
+	}
+
+	// A nil collector is strict too
+	if err := (*Diagnostics)(nil).Report("x.go", os.ErrNotExist); err != os.ErrNotExist {
+		t.Errorf("nil Report() = %v, want the error", err)
+	}
+}
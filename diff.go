@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+const diffContextLines = 3
+
+// unifiedDiff returns a unified diff turning a into b, or "" when they are
+// equal.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := diffLines(markMissingNewline(a), markMissingNewline(b))
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("--- %s\n", fromName))
+	builder.WriteString(fmt.Sprintf("+++ %s\n", toName))
+
+	// Line numbers (1-based) of each op in a and b
+	aLine, bLine := 1, 1
+	positions := make([][2]int, len(ops))
+	for i, op := range ops {
+		positions[i] = [2]int{aLine, bLine}
+		switch op.kind {
+		case diffEqual:
+			aLine++
+			bLine++
+		case diffDelete:
+			aLine++
+		case diffInsert:
+			bLine++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are within 2*context of each other
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == diffEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end += diffContextLines
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = run
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != diffInsert {
+				aCount++
+			}
+			if op.kind != diffDelete {
+				bCount++
+			}
+		}
+
+		aStart, bStart := positions[start][0], positions[start][1]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+
+		builder.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount)))
+		for _, op := range ops[start:end] {
+			prefix := " "
+			switch op.kind {
+			case diffDelete:
+				prefix = "-"
+			case diffInsert:
+				prefix = "+"
+			}
+			line, missingNewline := strings.CutSuffix(op.line, "\n")
+			builder.WriteString(prefix + line + "\n")
+			if missingNewline {
+				builder.WriteString("\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return builder.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// markMissingNewline splits s into lines and keeps a newline on the last
+// one if s doesn't end with one. No other line contains a newline, so it
+// only equals a last line that is missing it too.
+func markMissingNewline(s string) []string {
+	lines := splitLines(s)
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script with the linear space variant
+// of Myers' algorithm, so that large regenerated files don't need a copy of
+// the search frontier per edit.
+func diffLines(a, b []string) []diffOp {
+	size := (len(a)+len(b)+1)/2 + 2
+	md := &myersDiff{
+		ops:     make([]diffOp, 0, len(a)+len(b)),
+		forward: make([]int, 2*size+1),
+		reverse: make([]int, 2*size+1),
+		offset:  size,
+	}
+	md.diff(a, b)
+	return md.ops
+}
+
+// myersDiff holds the frontiers of the forward and the reverse search,
+// indexed by diagonal k = x - y plus offset, and the ops found so far.
+type myersDiff struct {
+	ops              []diffOp
+	forward, reverse []int
+	offset           int
+}
+
+func (md *myersDiff) diff(a, b []string) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		md.ops = append(md.ops, diffOp{kind: diffEqual, line: a[0]})
+		a, b = a[1:], b[1:]
+	}
+	common := 0
+	for common < len(a) && common < len(b) && a[len(a)-1-common] == b[len(b)-1-common] {
+		common++
+	}
+	suffix := a[len(a)-common:]
+	a, b = a[:len(a)-common], b[:len(b)-common]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			md.ops = append(md.ops, diffOp{kind: diffInsert, line: line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			md.ops = append(md.ops, diffOp{kind: diffDelete, line: line})
+		}
+	default:
+		// Both sides differ at their first and last line, so the edit
+		// distance is at least 2 and both halves are smaller problems
+		x, y, u, v := md.middleSnake(a, b)
+		md.diff(a[:x], b[:y])
+		for _, line := range a[x:u] {
+			md.ops = append(md.ops, diffOp{kind: diffEqual, line: line})
+		}
+		md.diff(a[u:], b[v:])
+	}
+
+	for _, line := range suffix {
+		md.ops = append(md.ops, diffOp{kind: diffEqual, line: line})
+	}
+}
+
+// middleSnake searches from both ends of the edit graph at once until the
+// paths meet and returns the snake (x, y) to (u, v) where they do, which
+// lies on a shortest edit script.
+func (md *myersDiff) middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	forward, reverse, offset := md.forward, md.reverse, md.offset
+	forward[offset+1] = 0
+	reverse[offset+1] = 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+			// The reverse search has made d-1 edits on diagonal delta-k
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && u+reverse[offset+rk] >= n {
+				return x, y, u, v
+			}
+		}
+
+		// The reverse search runs on both sides read backwards
+		for k := -d; k <= d; k += 2 {
+			var rx int
+			if k == -d || (k != d && reverse[offset+k-1] < reverse[offset+k+1]) {
+				rx = reverse[offset+k+1]
+			} else {
+				rx = reverse[offset+k-1] + 1
+			}
+			ry := rx - k
+			ru, rv := rx, ry
+			for ru < n && rv < m && a[n-1-ru] == b[m-1-rv] {
+				ru++
+				rv++
+			}
+			reverse[offset+k] = ru
+			if fk := delta - k; !odd && fk >= -d && fk <= d && ru+forward[offset+fk] >= n {
+				return n - ru, m - rv, n - rx, m - ry
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
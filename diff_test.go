@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		edits int
+	}{
+		{name: "equal", a: "a\nb\nc", b: "a\nb\nc", edits: 0},
+		{name: "both empty", a: "", b: "", edits: 0},
+		{name: "from empty", a: "", b: "a\nb", edits: 2},
+		{name: "to empty", a: "a\nb", b: "", edits: 2},
+		{name: "changed line", a: "a\nb\nc", b: "a\nx\nc", edits: 2},
+		{name: "inserted line", a: "a\nc", b: "a\nb\nc", edits: 1},
+		{name: "deleted line", a: "a\nb\nc", b: "a\nc", edits: 1},
+		{name: "moved line", a: "a\nb\nc\nd", b: "b\nc\nd\na", edits: 2},
+		{name: "disjoint", a: "a\nb", b: "c\nd", edits: 4},
+		{name: "repeated lines", a: "x\ny\nx\ny", b: "y\nx\ny\nx", edits: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := splitLines(tt.a), splitLines(tt.b)
+			ops := diffLines(a, b)
+
+			var from, to []string
+			edits := 0
+			for _, op := range ops {
+				switch op.kind {
+				case diffEqual:
+					from = append(from, op.line)
+					to = append(to, op.line)
+				case diffDelete:
+					from = append(from, op.line)
+					edits++
+				case diffInsert:
+					to = append(to, op.line)
+					edits++
+				}
+			}
+			if strings.Join(from, "\n") != tt.a || strings.Join(to, "\n") != tt.b {
+				t.Errorf("ops turn %q into %q, want %q into %q", strings.Join(from, "\n"), strings.Join(to, "\n"), tt.a, tt.b)
+			}
+			if edits != tt.edits {
+				t.Errorf("got %d edits, want %d", edits, tt.edits)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		var from, to []string
+		edits := 0
+		for _, op := range diffLines(a, b) {
+			if op.kind != diffInsert {
+				from = append(from, op.line)
+			}
+			if op.kind != diffDelete {
+				to = append(to, op.line)
+			}
+			if op.kind != diffEqual {
+				edits++
+			}
+		}
+		if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+			t.Fatalf("ops for %q -> %q turn %q into %q", a, b, from, to)
+		}
+		if want := len(a) + len(b) - 2*longestCommonSubsequence(a, b); edits != want {
+			t.Fatalf("%q -> %q: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int, changed map[int]string) string {
+		var builder strings.Builder
+		for i := 1; i <= n; i++ {
+			if line, ok := changed[i]; ok {
+				builder.WriteString(line + "\n")
+			} else {
+				builder.WriteString("line" + string(rune('a'+i-1)) + "\n")
+			}
+		}
+		return builder.String()
+	}
+
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "new file",
+			a:    "",
+			b:    "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "removed file",
+			a:    "a\n",
+			b:    "",
+			want: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "missing newline at the end",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "both missing the newline",
+			a:    "a\nb",
+			b:    "x\nb",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-a\n+x\n b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "context is limited to three lines",
+			a:    lines(9, nil),
+			b:    lines(9, map[int]string{5: "changed"}),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n lineb\n linec\n lined\n-linee\n+changed\n linef\n lineg\n lineh\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			a:    lines(20, nil),
+			b:    lines(20, map[int]string{2: "first", 19: "second"}),
+			want: "--- old\n+++ new\n" +
+				"@@ -1,5 +1,5 @@\n linea\n-lineb\n+first\n linec\n lined\n linee\n" +
+				"@@ -16,5 +16,5 @@\n linep\n lineq\n liner\n-lines\n+second\n linet\n",
+		},
+		{
+			name: "close changes share a hunk",
+			a:    lines(12, nil),
+			b:    lines(12, map[int]string{4: "first", 9: "second"}),
+			want: "--- old\n+++ new\n" +
+				"@@ -1,12 +1,12 @@\n linea\n lineb\n linec\n-lined\n+first\n linee\n linef\n lineg\n lineh\n-linei\n+second\n linej\n linek\n linel\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.a, tt.b); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type InterfaceExtractionOptions struct {
+	StructName    string
+	InterfaceName string
+	MethodFilter  *regexp.Regexp
+	Assert        bool
+}
+
+// receiverBaseName strips pointers and type parameters from a receiver,
+// so both *Cache and Cache[K, V] link to the Cache struct.
+func receiverBaseName(receiver string) string {
+	receiver = strings.TrimLeft(receiver, "*")
+	if idx := strings.Index(receiver, "["); idx >= 0 {
+		receiver = receiver[:idx]
+	}
+	return receiver
+}
+
+func formatMethodSignature(fn GoFunction) string {
+	signature := fmt.Sprintf("%s(%s)", fn.Name, strings.Join(fn.Parameters, ", "))
+
+	switch {
+	case len(fn.Returns) == 1 && !strings.Contains(fn.Returns[0], " "):
+		signature += " " + fn.Returns[0]
+	case len(fn.Returns) > 0:
+		signature += " (" + strings.Join(fn.Returns, ", ") + ")"
+	}
+	return signature
+}
+
+// linkStructMethods fills GoStruct.Methods from the functions whose receiver
+// is the struct, matching by package directory so methods declared in other
+// files of the package are found.
+func linkStructMethods(structs []SourceItems[GoStruct], functions []SourceItems[GoFunction]) {
+	methods := make(map[string][]GoFunction)
+	for _, src := range functions {
+		for _, fn := range src.Items {
+			if fn.Receiver == "" {
+				continue
+			}
+			key := src.Dir + "#" + receiverBaseName(fn.Receiver)
+			methods[key] = append(methods[key], fn)
+		}
+	}
+
+	for i := range structs {
+		for j := range structs[i].Items {
+			st := &structs[i].Items[j]
+			linked := methods[structs[i].Dir+"#"+st.Name]
+			sort.Slice(linked, func(a, b int) bool {
+				return linked[a].Name < linked[b].Name
+			})
+
+			st.Methods = make([]string, 0, len(linked))
+			for _, fn := range linked {
+				st.Methods = append(st.Methods, formatMethodSignature(fn))
+			}
+		}
+	}
+}
+
+// findStruct looks a struct up by name, optionally qualified with its
+// package name ("store.Cache"). Ambiguous names are an error.
+func findStruct(structs []SourceItems[GoStruct], name string) (GoStruct, error) {
+	pkg := ""
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		pkg, name = name[:idx], name[idx+1:]
+	}
+
+	matches := make([]GoStruct, 0)
+	for _, src := range structs {
+		for _, st := range src.Items {
+			if st.Name == name && (pkg == "" || st.Package == pkg) {
+				matches = append(matches, st)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return GoStruct{}, fmt.Errorf("struct %s not found", name)
+	case 1:
+		return matches[0], nil
+	default:
+		positions := make([]string, 0, len(matches))
+		for _, st := range matches {
+			positions = append(positions, fmt.Sprintf("%s.%s at %s", st.Package, st.Name, st.Position))
+		}
+		return GoStruct{}, fmt.Errorf("struct name %s is ambiguous, qualify it with the package name:\n  %s", name, strings.Join(positions, "\n  "))
+	}
+}
+
+// extractInterface builds an interface from the exported methods of st.
+func extractInterface(st GoStruct, opts InterfaceExtractionOptions) GoInterface {
+	name := opts.InterfaceName
+	if name == "" {
+		name = st.Name + "Interface"
+	}
+
+	methods := make([]string, 0, len(st.Methods))
+	for _, method := range st.Methods {
+		methodName, _, _ := parseMethodSignature(method)
+		if !ast.IsExported(methodName) {
+			continue
+		}
+		if opts.MethodFilter != nil && !opts.MethodFilter.MatchString(methodName) {
+			continue
+		}
+		methods = append(methods, method)
+	}
+
+	return GoInterface{
+		Name:     name,
+		Package:  st.Package,
+		Methods:  methods,
+		Position: st.Position,
+	}
+}
+
+// InterfaceDeclCodeGenerator renders an interface declaration extracted
+// from a struct, optionally followed by a compile-time assertion that the
+// struct implements it. Generic structs produce a generic interface with
+// the same type parameters; the assertion is skipped for them since it
+// needs concrete type arguments.
+type InterfaceDeclCodeGenerator struct {
+	source GoStruct
+	assert bool
+}
+
+func NewInterfaceDeclCodeGenerator(source GoStruct, assert bool) *InterfaceDeclCodeGenerator {
+	return &InterfaceDeclCodeGenerator{source: source, assert: assert}
+}
+
+func (idcg *InterfaceDeclCodeGenerator) GenerateCode(item GoInterface) string {
+	if item.Name == "" {
+		return ""
+	}
+
+	typeParams := ""
+	if len(idcg.source.TypeParams) > 0 {
+		typeParams = "[" + strings.Join(idcg.source.TypeParams, ", ") + "]"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("// %s is extracted from the exported methods of %s.\n", item.Name, idcg.source.Name))
+	builder.WriteString(fmt.Sprintf("type %s%s interface {\n", item.Name, typeParams))
+	for _, method := range item.Methods {
+		builder.WriteString(fmt.Sprintf("\t%s\n", method))
+	}
+	builder.WriteString("}\n")
+
+	if idcg.assert {
+		if typeParams != "" {
+			builder.WriteString(fmt.Sprintf("\n// No compile-time assertion: %s is generic.\n", idcg.source.Name))
+		} else {
+			builder.WriteString(fmt.Sprintf("\nvar _ %s = (*%s)(nil)\n", item.Name, idcg.source.Name))
+		}
+	}
+	return builder.String()
+}
+
+// extractedInterfaceImports resolves the types of each method against the
+// imports of the file the method is declared in, which need not be the
+// file of the struct.
+func extractedInterfaceImports(iface GoInterface, st GoStruct, opts AnalysisOptions) []string {
+	dir := filepath.Dir(positionFile(st.Position))
+	positions := make(map[string]string)
+	for _, src := range opts.FunctionSources.CollectResults() {
+		for _, fn := range src.Items {
+			if fn.Receiver != "" && receiverBaseName(fn.Receiver) == st.Name && filepath.Dir(positionFile(fn.Position)) == dir {
+				positions[fn.Name] = fn.Position
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	imports := make([]string, 0)
+	add := func(specs []string) {
+		for _, spec := range specs {
+			if !seen[spec] {
+				seen[spec] = true
+				imports = append(imports, spec)
+			}
+		}
+	}
+	add(opts.Imports.ImportsFor(st.Position, st.TypeParams))
+	for _, method := range iface.Methods {
+		name, _, _ := parseMethodSignature(method)
+		position, ok := positions[name]
+		if !ok {
+			position = st.Position
+		}
+		add(opts.Imports.ImportsFor(position, []string{method}))
+	}
+	sortImportSpecs(imports)
+	return imports
+}
+
+type ExtractedInterfaceNamer struct{}
+
+func (ein *ExtractedInterfaceNamer) GetImplementationName(item GoInterface) string {
+	return item.Name
+}
+
+// runInterfaceExtraction prints the extracted interface, or writes it as a
+// generated file with the imports it needs when outFile is set.
+func runInterfaceExtraction(extraction InterfaceExtractionOptions, opts AnalysisOptions, outFile string) error {
+	st, err := findStruct(opts.StructSources.CollectResults(), extraction.StructName)
+	if err != nil {
+		return err
+	}
+
+	iface := extractInterface(st, extraction)
+	if len(iface.Methods) == 0 {
+		return fmt.Errorf("struct %s has no exported methods matching the filter", st.Name)
+	}
+
+	generator := NewGenericCodeGenerator[GoInterface](
+		NewInterfaceDeclCodeGenerator(st, extraction.Assert),
+		&ExtractedInterfaceNamer{},
+		&SimpleFileWriter{},
+	)
+	code := generator.GenerateImplementation(iface)
+
+	if outFile == "" {
+		fmt.Print(code)
+		return nil
+	}
+
+	var builder strings.Builder
+	builder.WriteString(generatedHeader + "\n\n")
+	builder.WriteString(fmt.Sprintf("package %s\n\n", st.Package))
+	if imports := extractedInterfaceImports(iface, st, opts); len(imports) > 0 {
+		builder.WriteString("import (\n")
+		for _, spec := range imports {
+			builder.WriteString("\t" + spec + "\n")
+		}
+		builder.WriteString(")\n\n")
+	}
+	builder.WriteString(code)
+
+	if err := generator.WriteToFile(builder.String(), outFile); err != nil {
+		return err
+	}
+	fmt.Printf("Extracted interface %s from %s: %s\n", iface.Name, st.Name, outFile)
+	return nil
+}
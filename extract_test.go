@@ -0,0 +1,149 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// extractModule declares Cache in one file and its methods in another, with
+// imports of their own, and a generic Pool.
+var extractModule = map[string]string{
+	"go.mod": "module example.com/x\n",
+	"store/cache.go": `package store
+
+import "sync"
+
+type Cache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+type Pool[T any] struct {
+	items []T
+}
+
+func (p *Pool[T]) Put(item T) { p.items = append(p.items, item) }
+
+func (p *Pool[T]) Len() int { return len(p.items) }
+`,
+	"store/methods.go": `package store
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+func (c *Cache) Get(ctx context.Context, key string) (io.Reader, error) { return nil, nil }
+
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {}
+
+func (c *Cache) Close() error { return nil }
+
+func (c *Cache) evict() {}
+`,
+	"lru/lru.go": "package lru\n\ntype Cache struct{}\n\nfunc (c *Cache) Len() int { return 0 }\n",
+}
+
+func TestRunInterfaceExtraction(t *testing.T) {
+	root := writeTestModule(t, extractModule)
+	opts, _ := analyzeTestModule(t, root, "structs", "functions")
+	linkStructMethods(opts.StructSources.CollectResults(), opts.FunctionSources.CollectResults())
+
+	tests := []struct {
+		name       string
+		extraction InterfaceExtractionOptions
+		decl       string
+		methods    []string
+		imports    []string
+		absent     []string
+		err        string
+	}{
+		{
+			name:       "exported methods from another file",
+			extraction: InterfaceExtractionOptions{StructName: "store.Cache", Assert: true},
+			decl:       "type CacheInterface interface {",
+			methods:    []string{"Close() error", "Get(ctx context.Context, key string) (io.Reader, error)", "Set(key string, value []byte, ttl time.Duration)"},
+			imports:    []string{"context", "io", "time"},
+			absent:     []string{"evict", "sync"},
+		},
+		{
+			name:       "method filter",
+			extraction: InterfaceExtractionOptions{StructName: "store.Cache", InterfaceName: "Setter", MethodFilter: regexp.MustCompile("^S")},
+			decl:       "type Setter interface {",
+			methods:    []string{"Set(key string, value []byte, ttl time.Duration)"},
+			imports:    []string{"time"},
+			absent:     []string{"Get(", "Close(", "var _"},
+		},
+		{
+			name:       "generic struct skips the assertion",
+			extraction: InterfaceExtractionOptions{StructName: "Pool", Assert: true},
+			decl:       "type PoolInterface[T any] interface {",
+			methods:    []string{"Len() int", "Put(item T)"},
+			absent:     []string{"var _", "import"},
+		},
+		{
+			name:       "filter matching nothing",
+			extraction: InterfaceExtractionOptions{StructName: "store.Cache", MethodFilter: regexp.MustCompile("^Delete$")},
+			err:        "no exported methods",
+		},
+		{
+			name:       "ambiguous name",
+			extraction: InterfaceExtractionOptions{StructName: "Cache"},
+			err:        "ambiguous",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "extracted.go")
+			err := runInterfaceExtraction(tt.extraction, opts, out)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("runInterfaceExtraction() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := parser.ParseFile(token.NewFileSet(), out, content, parser.ImportsOnly)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, content)
+			}
+
+			var imports []string
+			for _, spec := range file.Imports {
+				path, _ := strconv.Unquote(spec.Path.Value)
+				imports = append(imports, path)
+			}
+			if !reflect.DeepEqual(imports, tt.imports) {
+				t.Errorf("imports %v, want %v", imports, tt.imports)
+			}
+			for _, want := range append([]string{tt.decl, "package store"}, tt.methods...) {
+				if !strings.Contains(string(content), want) {
+					t.Errorf("missing %q in\n%s", want, content)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(string(content), absent) {
+					t.Errorf("unexpected %q in\n%s", absent, content)
+				}
+			}
+			if tt.extraction.Assert && !strings.Contains(tt.decl, "[") && !strings.Contains(string(content), "var _ CacheInterface = (*Cache)(nil)") {
+				t.Errorf("missing assertion in\n%s", content)
+			}
+		})
+	}
+}
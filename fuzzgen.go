@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var fuzzableTypes = map[string]bool{
+	"string": true, "[]byte": true, "bool": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true, "byte": true, "rune": true,
+}
+
+// fuzzSeed returns a typed zero value literal usable as an f.Add argument.
+// f.Add requires the exact parameter types, so anything that is not the
+// default type of its untyped constant gets a conversion.
+func fuzzSeed(typ string) string {
+	switch typ {
+	case "string", "bool", "int", "float64":
+		return getZeroValue(typ)
+	case "[]byte":
+		return `[]byte("")`
+	default:
+		return fmt.Sprintf("%s(%s)", typ, getZeroValue(typ))
+	}
+}
+
+// fuzzArg is one parameter of the fuzz function. Struct parameters are
+// flattened into one fuzzArg per field and rebuilt before the call.
+type fuzzArg struct {
+	Name string
+	Type string
+}
+
+type fuzzParam struct {
+	Name    string
+	Type    string
+	Struct  string
+	Pointer bool
+	Fields  []fuzzField
+}
+
+type fuzzField struct {
+	Field string
+	Arg   fuzzArg
+}
+
+// UnfuzzableFunction records why no fuzz target was generated.
+type UnfuzzableFunction struct {
+	Function GoFunction
+	Reason   string
+}
+
+// FuzzScaffoldCodeGenerator renders a FuzzX(f *testing.F) target for
+// functions whose parameters are all accepted by native Go fuzzing, or are
+// structs of the same package made only of such fields.
+type FuzzScaffoldCodeGenerator struct {
+	dir        string
+	index      *ScaffoldIndex
+	receivers  *ReceiverBuilder
+	unfuzzable []UnfuzzableFunction
+}
+
+func NewFuzzScaffoldCodeGenerator(dir string, index *ScaffoldIndex, receivers *ReceiverBuilder) *FuzzScaffoldCodeGenerator {
+	return &FuzzScaffoldCodeGenerator{
+		dir:        dir,
+		index:      index,
+		receivers:  receivers,
+		unfuzzable: make([]UnfuzzableFunction, 0),
+	}
+}
+
+func (fscg *FuzzScaffoldCodeGenerator) Unfuzzable() []UnfuzzableFunction {
+	return fscg.unfuzzable
+}
+
+func (fscg *FuzzScaffoldCodeGenerator) fuzzParams(item GoFunction) ([]fuzzParam, string) {
+	if len(item.Parameters) == 0 {
+		return nil, "no parameters"
+	}
+
+	params := make([]fuzzParam, 0, len(item.Parameters))
+	for i, param := range item.Parameters {
+		name, typ := splitParam(param)
+		if name == "" || name == "_" {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		if fuzzableTypes[typ] {
+			if name == "t" || name == "f" {
+				name += "Arg"
+			}
+			params = append(params, fuzzParam{Name: name, Type: typ})
+			continue
+		}
+
+		structName := strings.TrimPrefix(typ, "*")
+		st, ok := fscg.index.Struct(fscg.dir, structName)
+		if !ok || len(st.TypeParams) > 0 {
+			return nil, fmt.Sprintf("parameter %s has unsupported type %s", name, typ)
+		}
+
+		fp := fuzzParam{Name: name, Type: typ, Struct: structName, Pointer: strings.HasPrefix(typ, "*")}
+		for _, field := range st.Fields {
+			fieldName, fieldType := splitParam(field)
+			if fieldName == "" {
+				return nil, fmt.Sprintf("parameter %s: struct %s embeds %s", name, structName, fieldType)
+			}
+			if !fuzzableTypes[fieldType] {
+				return nil, fmt.Sprintf("parameter %s: struct %s field %s has unsupported type %s", name, structName, fieldName, fieldType)
+			}
+			fp.Fields = append(fp.Fields, fuzzField{
+				Field: fieldName,
+				Arg:   fuzzArg{Name: name + upperFirst(fieldName), Type: fieldType},
+			})
+		}
+		if len(fp.Fields) == 0 {
+			return nil, fmt.Sprintf("parameter %s: struct %s has no fields", name, structName)
+		}
+		params = append(params, fp)
+	}
+	return params, ""
+}
+
+func upperFirst(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func (fscg *FuzzScaffoldCodeGenerator) GenerateCode(item GoFunction) string {
+	if !scaffoldable(item) {
+		return ""
+	}
+
+	params, reason := fscg.fuzzParams(item)
+	if reason != "" {
+		fscg.unfuzzable = append(fscg.unfuzzable, UnfuzzableFunction{Function: item, Reason: reason})
+		return ""
+	}
+
+	fuzzName := "Fuzz" + item.Name
+	if item.Receiver != "" {
+		fuzzName = fmt.Sprintf("Fuzz%s_%s", receiverBaseName(item.Receiver), item.Name)
+	}
+
+	args := make([]fuzzArg, 0, len(params))
+	for _, param := range params {
+		if param.Struct == "" {
+			args = append(args, fuzzArg{Name: param.Name, Type: param.Type})
+			continue
+		}
+		for _, field := range param.Fields {
+			args = append(args, field.Arg)
+		}
+	}
+
+	seeds := make([]string, 0, len(args))
+	signature := make([]string, 0, len(args)+1)
+	signature = append(signature, "t *testing.T")
+	for _, arg := range args {
+		seeds = append(seeds, fuzzSeed(arg.Type))
+		signature = append(signature, fmt.Sprintf("%s %s", arg.Name, arg.Type))
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("func %s(f *testing.F) {\n", fuzzName))
+	builder.WriteString(fmt.Sprintf("\tf.Add(%s)\n", strings.Join(seeds, ", ")))
+	builder.WriteString(fmt.Sprintf("\tf.Fuzz(func(%s) {\n", strings.Join(signature, ", ")))
+
+	callArgs := make([]string, 0, len(params))
+	for _, param := range params {
+		if param.Struct == "" {
+			callArgs = append(callArgs, param.Name)
+			continue
+		}
+
+		inits := make([]string, 0, len(param.Fields))
+		for _, field := range param.Fields {
+			inits = append(inits, fmt.Sprintf("%s: %s", field.Field, field.Arg.Name))
+		}
+		builder.WriteString(fmt.Sprintf("\t\t%s := %s{%s}\n", param.Name, param.Struct, strings.Join(inits, ", ")))
+		if param.Pointer {
+			callArgs = append(callArgs, "&"+param.Name)
+		} else {
+			callArgs = append(callArgs, param.Name)
+		}
+	}
+
+	call := item.Name
+	if item.Receiver != "" {
+		builder.WriteString(fscg.receivers.Build(item, "\t\t"))
+		call = "receiver." + item.Name
+	}
+
+	builder.WriteString(fmt.Sprintf("\t\t%s(%s)\n", call, strings.Join(callArgs, ", ")))
+	builder.WriteString("\t\t// TODO: Check invariants of the result.\n")
+	builder.WriteString("\t})\n")
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+type FuzzScaffoldNamer struct{}
+
+func (fsn *FuzzScaffoldNamer) GetImplementationName(item GoFunction) string {
+	if item.Receiver != "" {
+		return fmt.Sprintf("Fuzz%s_%s", receiverBaseName(item.Receiver), item.Name)
+	}
+	return "Fuzz" + item.Name
+}
+
+// generateFuzzScaffolds writes a <file>_fuzz_test.go next to every source
+// file with fuzzable exported functions and returns the functions that
+// could not be fuzzed. Existing files are never overwritten.
+func generateFuzzScaffolds(opts AnalysisOptions, index *ScaffoldIndex, writer FileWriter) ([]string, []UnfuzzableFunction, error) {
+	written := make([]string, 0)
+	unfuzzable := make([]UnfuzzableFunction, 0)
+
+	for _, src := range opts.FunctionSources.CollectResults() {
+		if opts.Diagnostics.HasErrors(src.File) {
+			fmt.Fprintf(opts.Output, "Skipped fuzz scaffold for %s: the file has errors\n", src.File)
+			continue
+		}
+		functions := sourceFunctions(src)
+		scaffold := NewFuzzScaffoldCodeGenerator(src.Dir, index, index.Receivers(src))
+		generator := NewGenericCodeGenerator[GoFunction](scaffold, &FuzzScaffoldNamer{}, writer)
+
+		targets := make([]string, 0)
+		for _, fn := range functions {
+			if code := generator.GenerateImplementation(fn); code != "" {
+				targets = append(targets, code)
+			}
+		}
+		unfuzzable = append(unfuzzable, scaffold.Unfuzzable()...)
+		if len(targets) == 0 {
+			continue
+		}
+
+		fuzzFile := strings.TrimSuffix(src.File, ".go") + "_fuzz_test.go"
+		if _, err := os.Stat(fuzzFile); err == nil {
+			fmt.Fprintf(opts.Output, "Skipped fuzz scaffold for %s: %s already exists\n", src.File, fuzzFile)
+			continue
+		}
+
+		content := renderTestFile(src.Package, []string{`"testing"`}, targets)
+		if err := generator.WriteToFile(content, fuzzFile); err != nil {
+			return written, unfuzzable, fmt.Errorf("failed to write %s: %v", fuzzFile, err)
+		}
+		written = append(written, fuzzFile)
+	}
+
+	return written, unfuzzable, nil
+}
+
+func printUnfuzzable(w io.Writer, unfuzzable []UnfuzzableFunction) {
+	if len(unfuzzable) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\n--- Functions Without Fuzz Targets ---")
+	renderer := &FunctionItemRenderer{}
+	for _, entry := range unfuzzable {
+		header := strings.SplitN(renderer.RenderItem(entry.Function), "\n", 2)[0]
+		fmt.Fprintf(w, "%s\n  Reason: %s\n", header, entry.Reason)
+	}
+}
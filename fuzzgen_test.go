@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFuzzScaffolds(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod": "module example.com/f\n",
+		"codec/codec.go": `package codec
+
+import "io"
+
+type Options struct {
+	Name  string
+	Level int8
+}
+
+type Config struct {
+	Opts Options
+}
+
+type Wrapper struct {
+	Options
+}
+
+type Empty struct{}
+
+type Pair[T any] struct {
+	A, B T
+}
+
+type Parser struct {
+	strict bool
+}
+
+func Parse(data []byte) error { return nil }
+
+func Count(s string, t int, f float32) int { return 0 }
+
+func Encode(opts Options) []byte { return nil }
+
+func EncodePtr(opts *Options) []byte { return nil }
+
+func (p *Parser) Feed(chunk string) {}
+
+func Write(w io.Writer) {}
+
+func Nested(c Config) {}
+
+func Embedded(w Wrapper) {}
+
+func Blank(e Empty) {}
+
+func Swap(p Pair[int]) {}
+
+func Reset() {}
+
+func helper(s string) {}
+`,
+	})
+	opts, resolver := analyzeTestModule(t, root, "structs", "interfaces", "functions")
+	writer := NewMemoryFileWriter()
+	written, unfuzzable, err := generateFuzzScaffolds(opts, NewScaffoldIndex(opts, resolver), writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fuzzFile := filepath.Join(root, "codec", "codec_fuzz_test.go")
+	if !reflect.DeepEqual(written, []string{fuzzFile}) {
+		t.Fatalf("written %v, want %s", written, fuzzFile)
+	}
+	content := writer.Files()[fuzzFile]
+	if _, err := parser.ParseFile(token.NewFileSet(), fuzzFile, content, 0); err != nil {
+		t.Fatalf("%v\n%s", err, content)
+	}
+	if formatted, err := format.Source([]byte(content)); err != nil || string(formatted) != content {
+		t.Errorf("scaffold is not gofmt'ed:\n%s", content)
+	}
+
+	for _, want := range []string{
+		"func FuzzParse(f *testing.F) {\n\tf.Add([]byte(\"\"))\n\tf.Fuzz(func(t *testing.T, data []byte) {\n\t\tParse(data)\n",
+		// Parameters named like the testing arguments are renamed
+		"f.Add(\"\", 0, float32(0.0))\n\tf.Fuzz(func(t *testing.T, s string, tArg int, fArg float32) {\n\t\tCount(s, tArg, fArg)\n",
+		// Struct parameters are flattened into their fields and rebuilt
+		"f.Add(\"\", int8(0))\n\tf.Fuzz(func(t *testing.T, optsName string, optsLevel int8) {\n\t\topts := Options{Name: optsName, Level: optsLevel}\n\t\tEncode(opts)\n",
+		"\t\topts := Options{Name: optsName, Level: optsLevel}\n\t\tEncodePtr(&opts)\n",
+		"func FuzzParser_Feed(f *testing.F) {",
+		"receiver.Feed(chunk)",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("scaffold does not contain %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "helper") {
+		t.Errorf("unexported function fuzzed:\n%s", content)
+	}
+
+	reasons := make(map[string]string)
+	for _, entry := range unfuzzable {
+		reasons[entry.Function.Name] = entry.Reason
+	}
+	want := map[string]string{
+		"Write":    "parameter w has unsupported type io.Writer",
+		"Nested":   "parameter c: struct Config field Opts has unsupported type Options",
+		"Embedded": "parameter w: struct Wrapper embeds Options",
+		"Blank":    "parameter e: struct Empty has no fields",
+		"Swap":     "parameter p has unsupported type Pair[int]",
+		"Reset":    "no parameters",
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("unfuzzable = %v, want %v", reasons, want)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Declaration is a struct, interface, function or method in the dependency
+// graph. Keys are import path qualified: "example.com/app/store.Repo" or
+// "example.com/app/store.Cache.Get".
+type Declaration struct {
+	Key      string `json:"key"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Package  string `json:"package"`
+	Position string `json:"position"`
+}
+
+const (
+	DependencyKindReference  = "reference"
+	DependencyKindImplements = "implements"
+	DependencyKindCall       = "call"
+)
+
+// DependencyEdge records that From depends on To, and why.
+type DependencyEdge struct {
+	From     string `json:"-"`
+	To       string `json:"-"`
+	Kind     string `json:"kind"`
+	Position string `json:"position"`
+	Reason   string `json:"reason"`
+}
+
+// DependencyGraph links declarations through the types they reference,
+// the methods they are declared on, the interfaces they implement and the
+// functions they call.
+type DependencyGraph struct {
+	declarations map[string]*Declaration
+	dependents   map[string][]DependencyEdge
+}
+
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		declarations: make(map[string]*Declaration),
+		dependents:   make(map[string][]DependencyEdge),
+	}
+}
+
+func (dg *DependencyGraph) addEdge(edge DependencyEdge) {
+	if edge.From == edge.To || dg.declarations[edge.From] == nil || dg.declarations[edge.To] == nil {
+		return
+	}
+	for _, existing := range dg.dependents[edge.To] {
+		if existing == edge {
+			return
+		}
+	}
+	dg.dependents[edge.To] = append(dg.dependents[edge.To], edge)
+}
+
+// BuildDependencyGraph builds the graph from the collected structs,
+// interfaces and functions. Call edges are added when calls is not nil.
+func BuildDependencyGraph(opts AnalysisOptions, resolver PackageResolver, calls *CallGraph) *DependencyGraph {
+	dg := NewDependencyGraph()
+	structs := opts.StructSources.CollectResults()
+	interfaces := opts.InterfaceSources.CollectResults()
+	functions := opts.FunctionSources.CollectResults()
+
+	declare := func(src string, kind, name, pkgName, position string) {
+		key := src + "." + name
+		dg.declarations[key] = &Declaration{
+			Key:      key,
+			Kind:     kind,
+			Name:     pkgName + "." + name,
+			Package:  src,
+			Position: position,
+		}
+	}
+
+	for _, src := range structs {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, st := range src.Items {
+			declare(path, "struct", st.Name, src.Package, st.Position)
+		}
+	}
+	for _, src := range interfaces {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, iface := range src.Items {
+			declare(path, "interface", iface.Name, src.Package, iface.Position)
+		}
+	}
+	for _, src := range functions {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, fn := range src.Items {
+			if fn.Receiver != "" {
+				declare(path, "method", receiverBaseName(fn.Receiver)+"."+fn.Name, src.Package, fn.Position)
+			} else {
+				declare(path, "func", fn.Name, src.Package, fn.Position)
+			}
+		}
+	}
+
+	references := func(from, path, position, reason string, typeStrs []string) {
+		for _, typeStr := range typeStrs {
+			for _, to := range dg.referencedTypes(path, position, typeStr, opts) {
+				dg.addEdge(DependencyEdge{From: from, To: to, Kind: DependencyKindReference, Position: position, Reason: reason + " " + typeStr})
+			}
+		}
+	}
+
+	for _, src := range structs {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, st := range src.Items {
+			references(path+"."+st.Name, path, st.Position, "field", st.Fields)
+		}
+	}
+	for _, src := range interfaces {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, iface := range src.Items {
+			references(path+"."+iface.Name, path, iface.Position, "method", iface.Methods)
+		}
+	}
+	for _, src := range functions {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, fn := range src.Items {
+			key := path + "." + fn.Name
+			if fn.Receiver != "" {
+				key = path + "." + receiverBaseName(fn.Receiver) + "." + fn.Name
+				references(key, path, fn.Position, "receiver", []string{fn.Receiver})
+			}
+			references(key, path, fn.Position, "parameter", fn.Parameters)
+			references(key, path, fn.Position, "returns", fn.Returns)
+		}
+	}
+
+	dg.addImplementations(structs, interfaces, functions, resolver)
+	if calls != nil {
+		dg.addCalls(calls)
+	}
+	return dg
+}
+
+// referencedTypes resolves the named types of a type string to declaration
+// keys: qualified names through the imports of the file at position, and
+// unqualified names within the package.
+func (dg *DependencyGraph) referencedTypes(path, position, typeStr string, opts AnalysisOptions) []string {
+	keys := make([]string, 0)
+	for _, loc := range typeNamePattern.FindAllStringSubmatchIndex(typeStr, -1) {
+		// Names followed by a space or a parenthesis name a field, parameter
+		// or method rather than a type
+		if loc[1] < len(typeStr) && (typeStr[loc[1]] == ' ' || typeStr[loc[1]] == '(') {
+			continue
+		}
+		name := typeStr[loc[4]:loc[5]]
+		if loc[2] < 0 {
+			if dg.declarations[path+"."+name] != nil {
+				keys = appendUnique(keys, path+"."+name)
+			}
+			continue
+		}
+		if opts.Imports == nil {
+			continue
+		}
+		for _, importPath := range opts.Imports.ImportPathsFor(position, []string{typeStr[loc[0]:loc[1]]}) {
+			if opts.Graph != nil {
+				importPath = opts.Graph.Resolve(importPath)
+			}
+			if dg.declarations[importPath+"."+name] != nil {
+				keys = appendUnique(keys, importPath+"."+name)
+			}
+		}
+	}
+	return keys
+}
+
+var typeNamePattern = regexp.MustCompile(`\b(?:([A-Za-z_][A-Za-z0-9_]*)\.)?([A-Za-z_][A-Za-z0-9_]*)\b`)
+
+// addImplementations makes every struct depend on the interfaces whose
+// methods it declares, matched by name.
+func (dg *DependencyGraph) addImplementations(structs []SourceItems[GoStruct], interfaces []SourceItems[GoInterface], functions []SourceItems[GoFunction], resolver PackageResolver) {
+	methodSets := make(map[string]map[string]bool)
+	for _, src := range functions {
+		path := resolver.ImportPath(src.Dir, src.Package)
+		for _, fn := range src.Items {
+			if fn.Receiver == "" {
+				continue
+			}
+			key := path + "." + receiverBaseName(fn.Receiver)
+			if methodSets[key] == nil {
+				methodSets[key] = make(map[string]bool)
+			}
+			methodSets[key][fn.Name] = true
+		}
+	}
+
+	for _, isrc := range interfaces {
+		ipath := resolver.ImportPath(isrc.Dir, isrc.Package)
+		for _, iface := range isrc.Items {
+			names := make([]string, 0, len(iface.Methods))
+			for _, method := range iface.Methods {
+				if name, _, _ := parseMethodSignature(method); isValidIdentifier(name) {
+					names = append(names, name)
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+
+			for _, ssrc := range structs {
+				spath := resolver.ImportPath(ssrc.Dir, ssrc.Package)
+				for _, st := range ssrc.Items {
+					methods := methodSets[spath+"."+st.Name]
+					implements := true
+					for _, name := range names {
+						if !methods[name] {
+							implements = false
+							break
+						}
+					}
+					if implements {
+						dg.addEdge(DependencyEdge{
+							From:     spath + "." + st.Name,
+							To:       ipath + "." + iface.Name,
+							Kind:     DependencyKindImplements,
+							Position: st.Position,
+							Reason:   "implements " + isrc.Package + "." + iface.Name,
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+// addCalls makes callers depend on the functions they call.
+func (dg *DependencyGraph) addCalls(calls *CallGraph) {
+	keyOf := func(node *CallGraphNode) string {
+		name := node.Name
+		if idx := strings.Index(name, "."); idx >= 0 {
+			name = name[idx+1:]
+		}
+		return node.Package + "." + name
+	}
+
+	for caller, edges := range calls.callees {
+		from, ok := calls.nodes[caller]
+		if !ok || from.External {
+			continue
+		}
+		for _, edge := range edges {
+			to, ok := calls.nodes[edge.Callee]
+			if !ok || to.External {
+				continue
+			}
+			reason := "calls " + to.Name
+			if edge.Dynamic {
+				reason += " via interface"
+			}
+			dg.addEdge(DependencyEdge{From: keyOf(from), To: keyOf(to), Kind: DependencyKindCall, Position: edge.Position, Reason: reason})
+		}
+	}
+}
+
+// PackageReferences maps each package to the other packages whose
+// declarations it refers to by type. Implementations and calls don't
+// count: a struct implements an interface without referring to it, and a
+// call through an interface doesn't depend on the implementation.
+func (dg *DependencyGraph) PackageReferences() map[string][]string {
+	references := make(map[string][]string)
+	for _, edges := range dg.dependents {
+		for _, edge := range edges {
+			if edge.Kind != DependencyKindReference {
+				continue
+			}
+			from, to := dg.declarations[edge.From].Package, dg.declarations[edge.To].Package
+			if from != to {
+				references[from] = appendUnique(references[from], to)
+			}
+		}
+	}
+	for _, packages := range references {
+		sort.Strings(packages)
+	}
+	return references
+}
+
+// Find returns the keys of the declarations named by query: a full key,
+// pkg.Name, or a suffix such as Name or Type.Method.
+func (dg *DependencyGraph) Find(query string) []string {
+	matches := make([]string, 0)
+	for key, decl := range dg.declarations {
+		if key == query || decl.Name == query || strings.HasSuffix(decl.Name, "."+query) {
+			matches = append(matches, key)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// ImpactedDeclaration is a declaration depending on the target through
+// the edges listed, all pointing at declarations one level closer.
+type ImpactedDeclaration struct {
+	Declaration
+	Level int              `json:"level"`
+	Edges []DependencyEdge `json:"references"`
+}
+
+type Impact struct {
+	Target    Declaration           `json:"target"`
+	Impacted  []ImpactedDeclaration `json:"impacted"`
+	Packages  int                   `json:"packages"`
+	MaxLevels int                   `json:"levels"`
+}
+
+// Impact lists everything depending on key, directly (level 1) and
+// transitively, breadth first.
+func (dg *DependencyGraph) Impact(key string) Impact {
+	impact := Impact{Target: *dg.declarations[key], Impacted: make([]ImpactedDeclaration, 0)}
+	levels := map[string]int{key: 0}
+	frontier := []string{key}
+	for level := 1; len(frontier) > 0; level++ {
+		found := make(map[string][]DependencyEdge)
+		for _, to := range frontier {
+			for _, edge := range dg.dependents[to] {
+				if _, seen := levels[edge.From]; seen {
+					continue
+				}
+				found[edge.From] = append(found[edge.From], edge)
+			}
+		}
+
+		frontier = frontier[:0:0]
+		for from, edges := range found {
+			levels[from] = level
+			frontier = append(frontier, from)
+			sort.Slice(edges, func(i, j int) bool {
+				if edges[i].Position != edges[j].Position {
+					return edges[i].Position < edges[j].Position
+				}
+				return edges[i].Reason < edges[j].Reason
+			})
+			impact.Impacted = append(impact.Impacted, ImpactedDeclaration{Declaration: *dg.declarations[from], Level: level, Edges: edges})
+			impact.MaxLevels = level
+		}
+	}
+
+	sort.Slice(impact.Impacted, func(i, j int) bool {
+		a, b := impact.Impacted[i], impact.Impacted[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Key < b.Key
+	})
+
+	packages := make(map[string]bool)
+	for _, decl := range impact.Impacted {
+		packages[decl.Package] = true
+	}
+	impact.Packages = len(packages)
+	return impact
+}
+
+func WriteImpact(w io.Writer, impact Impact, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(impact)
+	case "text", "":
+		target := impact.Target
+		fmt.Fprintf(w, "\n--- Impact of %s (%s at %s) ---\n", target.Name, target.Kind, target.Position)
+		if len(impact.Impacted) == 0 {
+			fmt.Fprintln(w, "Nothing depends on it")
+			return nil
+		}
+
+		level, pkg := 0, ""
+		for _, decl := range impact.Impacted {
+			if decl.Level != level {
+				level, pkg = decl.Level, ""
+				if level == 1 {
+					fmt.Fprintln(w, "Level 1 (direct):")
+				} else {
+					fmt.Fprintf(w, "Level %d:\n", level)
+				}
+			}
+			if decl.Package != pkg {
+				pkg = decl.Package
+				fmt.Fprintf(w, "  %s\n", pkg)
+			}
+			fmt.Fprintf(w, "    %s %s at %s\n", decl.Kind, decl.Name, decl.Position)
+			for _, edge := range decl.Edges {
+				fmt.Fprintf(w, "      %s: %s\n", edge.Position, edge.Reason)
+			}
+		}
+		fmt.Fprintf(w, "%d declaration(s) in %d package(s) affected\n", len(impact.Impacted), impact.Packages)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
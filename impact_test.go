@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencyGraphImpact(t *testing.T) {
+	root := writeTestModule(t, callGraphModule)
+	opts, resolver := analyzeTestModule(t, root, "structs", "interfaces", "functions")
+	dependencies := BuildDependencyGraph(opts, resolver, buildTestCallGraph(t, root))
+
+	matches := dependencies.Find("store.Store")
+	if len(matches) != 1 {
+		t.Fatalf("Find(store.Store) = %v, want one match", matches)
+	}
+	impact := dependencies.Impact(matches[0])
+
+	type impacted struct {
+		level int
+		kinds []string
+	}
+	want := map[string]impacted{
+		"store.Memory":     {1, []string{DependencyKindImplements}},
+		"disk.Disk":        {1, []string{DependencyKindImplements}},
+		"app.Load":         {1, []string{DependencyKindReference}},
+		"store.Memory.Get": {2, []string{DependencyKindReference}},
+		"disk.Disk.Get":    {2, []string{DependencyKindReference}},
+		"app.Run":          {2, []string{DependencyKindCall}},
+		"cli.Main":         {3, []string{DependencyKindCall}},
+	}
+	got := make(map[string]impacted)
+	for _, decl := range impact.Impacted {
+		kinds := make([]string, 0)
+		for _, edge := range decl.Edges {
+			kinds = appendUnique(kinds, edge.Kind)
+		}
+		got[decl.Name] = impacted{decl.Level, kinds}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Impact(store.Store) = %v, want %v", got, want)
+	}
+	if impact.MaxLevels != 3 || impact.Packages != 4 {
+		t.Errorf("Impact(store.Store) has %d levels in %d packages, want 3 in 4", impact.MaxLevels, impact.Packages)
+	}
+}
+
+func TestPackageReferences(t *testing.T) {
+	root := writeTestModule(t, callGraphModule)
+	opts, resolver := analyzeTestModule(t, root, "structs", "interfaces", "functions")
+	dependencies := BuildDependencyGraph(opts, resolver, buildTestCallGraph(t, root))
+
+	// disk implements store.Store and cli calls app, neither refers to the
+	// other package's types
+	want := map[string][]string{"example.com/m/app": {"example.com/m/store"}}
+	if got := dependencies.PackageReferences(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PackageReferences() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	PackageKindStdlib     = "stdlib"
+	PackageKindInternal   = "internal"
+	PackageKindThirdParty = "third-party"
+)
+
+// GoPackage is a node of the import graph. Packages found under -dirs have
+// a Dir; imported packages that were not analyzed only have an import path.
+type GoPackage struct {
+	ImportPath     string   `json:"importPath"`
+	Name           string   `json:"name,omitempty"`
+	Dir            string   `json:"dir,omitempty"`
+	Kind           string   `json:"kind"`
+	Imports        []string `json:"imports,omitempty"`
+	TransitiveDeps int      `json:"transitiveDeps"`
+	Level          int      `json:"level"`
+}
+
+// PackageResolver maps analyzed directories to import paths and resolves
+// import paths back to internal packages and their directories.
+type PackageResolver interface {
+	ImportPath(dir, pkgName string) string
+	Resolve(importPath string) (string, bool)
+	Dir(importPath string) (string, bool)
+}
+
+// SuffixPackageResolver is used when nothing is known about the module: a
+// directory is named by its path relative to the working directory, or to
+// base if set, and an import resolves to the analyzed directory it ends
+// with.
+type SuffixPackageResolver struct {
+	base string
+	dirs map[string]string
+}
+
+func NewSuffixPackageResolver() *SuffixPackageResolver {
+	return &SuffixPackageResolver{dirs: make(map[string]string)}
+}
+
+func (spr *SuffixPackageResolver) ImportPath(dir, pkgName string) string {
+	rel := mirrorDir(dir)
+	if spr.base != "" {
+		if inBase, ok := relativeTo(spr.base, absolutePath(dir)); ok {
+			rel = inBase
+		}
+	}
+	if rel == "" || rel == "." {
+		rel = pkgName
+	}
+	spr.dirs[rel] = dir
+	return rel
+}
+
+func (spr *SuffixPackageResolver) Resolve(importPath string) (string, bool) {
+	best := ""
+	for name := range spr.dirs {
+		if importPath == name || strings.HasSuffix(importPath, "/"+name) {
+			if len(name) > len(best) {
+				best = name
+			}
+		}
+	}
+	return best, best != ""
+}
+
+func (spr *SuffixPackageResolver) Dir(importPath string) (string, bool) {
+	if name, ok := spr.Resolve(importPath); ok {
+		return spr.dirs[name], true
+	}
+	return "", false
+}
+
+func isStdlibPath(importPath string) bool {
+	first := strings.SplitN(importPath, "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+// ImportGraph links packages by import path. Import paths as written in
+// source are kept in aliases, mapped to the node they resolved to.
+type ImportGraph struct {
+	packages  map[string]*GoPackage
+	aliases   map[string]string
+	positions map[string]map[string][]string
+	cycles    [][]string
+}
+
+// BuildImportGraph parses only the import blocks of every analyzable file under dirs
+// and links packages by import path.
+func BuildImportGraph(dirs []string, opts AnalysisOptions, resolver PackageResolver) (*ImportGraph, error) {
+	graph := &ImportGraph{
+		packages:  make(map[string]*GoPackage),
+		aliases:   make(map[string]string),
+		positions: make(map[string]map[string][]string),
+	}
+	rawImports := make(map[string][]string)
+	rawPositions := make(map[string]map[string][]string)
+	fset := token.NewFileSet()
+
+	for _, dir := range dirs {
+		files, err := analyzableFiles(dir, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			file, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+			if err != nil {
+				if err := opts.Diagnostics.Report(path, err); err != nil {
+					return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+				}
+				if !hasPackageClause(file) {
+					continue
+				}
+			}
+
+			pkgDir := filepath.Dir(path)
+			importPath := resolver.ImportPath(pkgDir, strings.TrimSuffix(file.Name.Name, "_test"))
+			pkg, ok := graph.packages[importPath]
+			if !ok {
+				kind := PackageKindInternal
+				if _, internal := resolver.Resolve(importPath); !internal {
+					kind = PackageKindThirdParty
+				}
+				pkg = &GoPackage{
+					ImportPath: importPath,
+					Name:       file.Name.Name,
+					Dir:        pkgDir,
+					Kind:       kind,
+				}
+				graph.packages[importPath] = pkg
+			}
+
+			for _, spec := range file.Imports {
+				if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+					rawImports[importPath] = appendUnique(rawImports[importPath], path)
+					if rawPositions[importPath] == nil {
+						rawPositions[importPath] = make(map[string][]string)
+					}
+					rawPositions[importPath][path] = append(rawPositions[importPath][path], fset.Position(spec.Pos()).String())
+				}
+			}
+		}
+	}
+
+	// Resolve imports once every analyzed package is known
+	for from, imports := range rawImports {
+		pkg := graph.packages[from]
+		for _, importPath := range imports {
+			target := importPath
+			if resolved, ok := resolver.Resolve(importPath); ok {
+				target = resolved
+				if _, exists := graph.packages[target]; !exists {
+					dir, _ := resolver.Dir(importPath)
+					graph.packages[target] = &GoPackage{ImportPath: target, Dir: dir, Kind: PackageKindInternal}
+				}
+			} else if _, exists := graph.packages[importPath]; !exists {
+				kind := PackageKindThirdParty
+				if isStdlibPath(importPath) {
+					kind = PackageKindStdlib
+				}
+				graph.packages[importPath] = &GoPackage{ImportPath: importPath, Kind: kind}
+			}
+			graph.aliases[importPath] = target
+			if target != from {
+				pkg.Imports = appendUnique(pkg.Imports, target)
+				if graph.positions[from] == nil {
+					graph.positions[from] = make(map[string][]string)
+				}
+				graph.positions[from][target] = append(graph.positions[from][target], rawPositions[from][importPath]...)
+			}
+		}
+		sort.Strings(pkg.Imports)
+	}
+
+	graph.cycles = findCycles(graph.adjacency())
+	graph.computeLevels()
+	graph.computeTransitiveDeps()
+	return graph, nil
+}
+
+func (g *ImportGraph) adjacency() map[string][]string {
+	adjacency := make(map[string][]string, len(g.packages))
+	for path, pkg := range g.packages {
+		adjacency[path] = pkg.Imports
+	}
+	return adjacency
+}
+
+// computeLevels assigns every package the length of its longest import
+// chain. Packages in a cycle are collapsed into one node and share a level.
+func (g *ImportGraph) computeLevels() {
+	component := make(map[string]string, len(g.packages))
+	members := make(map[string][]string, len(g.packages))
+	for path := range g.packages {
+		component[path] = path
+		members[path] = []string{path}
+	}
+	for _, cycle := range g.cycles {
+		members[cycle[0]] = cycle
+		for _, path := range cycle[1:] {
+			component[path] = cycle[0]
+			delete(members, path)
+		}
+	}
+
+	levels := make(map[string]int)
+	var level func(comp string) int
+	level = func(comp string) int {
+		if l, ok := levels[comp]; ok {
+			return l
+		}
+
+		l := 0
+		for _, path := range members[comp] {
+			for _, dep := range g.packages[path].Imports {
+				if component[dep] == comp {
+					continue
+				}
+				if depLevel := level(component[dep]) + 1; depLevel > l {
+					l = depLevel
+				}
+			}
+		}
+		levels[comp] = l
+		return l
+	}
+
+	for path, pkg := range g.packages {
+		pkg.Level = level(component[path])
+	}
+}
+
+func (g *ImportGraph) computeTransitiveDeps() {
+	for _, pkg := range g.packages {
+		pkg.TransitiveDeps = len(g.Reachable(pkg.ImportPath)) - 1
+	}
+}
+
+// Reachable returns the packages reachable from path, including itself.
+func (g *ImportGraph) Reachable(path string) map[string]bool {
+	seen := map[string]bool{path: true}
+	stack := []string{path}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		pkg, ok := g.packages[current]
+		if !ok {
+			continue
+		}
+		for _, dep := range pkg.Imports {
+			if !seen[dep] {
+				seen[dep] = true
+				stack = append(stack, dep)
+			}
+		}
+	}
+	return seen
+}
+
+func (g *ImportGraph) Package(importPath string) (GoPackage, bool) {
+	pkg, ok := g.packages[importPath]
+	if !ok {
+		return GoPackage{}, false
+	}
+	return *pkg, true
+}
+
+// Resolve returns the node an import path as written in source refers to.
+func (g *ImportGraph) Resolve(importPath string) string {
+	if target, ok := g.aliases[importPath]; ok {
+		return target
+	}
+	return importPath
+}
+
+// Kind classifies an import path as seen from the analyzed packages.
+func (g *ImportGraph) Kind(importPath string) string {
+	if pkg, ok := g.packages[g.Resolve(importPath)]; ok {
+		return pkg.Kind
+	}
+	if isStdlibPath(importPath) {
+		return PackageKindStdlib
+	}
+	return PackageKindThirdParty
+}
+
+// Dependencies returns the direct imports of a package.
+func (g *ImportGraph) Dependencies(importPath string) []string {
+	if pkg, ok := g.packages[importPath]; ok {
+		return pkg.Imports
+	}
+	return nil
+}
+
+// Importers returns the packages directly importing a package.
+func (g *ImportGraph) Importers(importPath string) []string {
+	importers := make([]string, 0)
+	for path, pkg := range g.packages {
+		for _, dep := range pkg.Imports {
+			if dep == importPath {
+				importers = append(importers, path)
+				break
+			}
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+// ImportPositions returns where the files of package from import to.
+func (g *ImportGraph) ImportPositions(from, to string) []string {
+	positions := append([]string{}, g.positions[from][to]...)
+	sortPositions(positions)
+	return positions
+}
+
+// sortPositions orders "file:line:col" positions by file, then line.
+func sortPositions(positions []string) {
+	sort.SliceStable(positions, func(i, j int) bool {
+		fi, fj := positionFile(positions[i]), positionFile(positions[j])
+		if fi != fj {
+			return fi < fj
+		}
+		return positionLine(positions[i]) < positionLine(positions[j])
+	})
+}
+
+// TransitiveImports returns the import paths, as written in source, of every
+// package importPath depends on directly or indirectly.
+func (g *ImportGraph) TransitiveImports(importPath string) []string {
+	node := g.Resolve(importPath)
+	reachable := g.Reachable(node)
+
+	imports := make([]string, 0)
+	for alias, target := range g.aliases {
+		if target != node && reachable[target] {
+			imports = append(imports, alias)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+func (g *ImportGraph) Cycles() [][]string {
+	return g.cycles
+}
+
+// CyclePath returns a shortest import path from the first package of a
+// cycle back to itself, through packages of the cycle only. Cycles lists
+// the members of a cycle, not the order they import each other in.
+func (g *ImportGraph) CyclePath(cycle []string) []string {
+	members := make(map[string]bool, len(cycle))
+	for _, path := range cycle {
+		members[path] = true
+	}
+
+	start := cycle[0]
+	previous := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range g.Dependencies(node) {
+			if next == start {
+				path := []string{start}
+				for at := node; at != start; at = previous[at] {
+					path = append(path, at)
+				}
+				path = append(path, start)
+				for i, j := 1, len(path)-2; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			if _, seen := previous[next]; !seen && members[next] {
+				previous[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return append(append([]string{}, cycle...), start)
+}
+
+// Sorted returns the packages least dependent first. Ordering by level
+// keeps the order topological even when the graph has cycles.
+func (g *ImportGraph) Sorted() []GoPackage {
+	packages := make([]GoPackage, 0, len(g.packages))
+	for _, pkg := range g.packages {
+		packages = append(packages, *pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Level != packages[j].Level {
+			return packages[i].Level < packages[j].Level
+		}
+		return packages[i].ImportPath < packages[j].ImportPath
+	})
+	return packages
+}
+
+// findCycles returns the strongly connected components with more than one
+// node (or a self-import), using Tarjan's algorithm.
+func findCycles(adjacency map[string][]string) [][]string {
+	index := 0
+	indices := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	cycles := make([][]string, 0)
+
+	nodes := make([]string, 0, len(adjacency))
+	for node := range adjacency {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	var strongConnect func(node string)
+	strongConnect = func(node string) {
+		indices[node] = index
+		lowlink[node] = index
+		index++
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, next := range adjacency[node] {
+			if _, visited := indices[next]; !visited {
+				strongConnect(next)
+				if lowlink[next] < lowlink[node] {
+					lowlink[node] = lowlink[next]
+				}
+			} else if onStack[next] && indices[next] < lowlink[node] {
+				lowlink[node] = indices[next]
+			}
+		}
+
+		if lowlink[node] == indices[node] {
+			component := make([]string, 0)
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+
+			selfLoop := false
+			for _, next := range adjacency[node] {
+				if next == node {
+					selfLoop = true
+				}
+			}
+			if len(component) > 1 || selfLoop {
+				sort.Strings(component)
+				cycles = append(cycles, component)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if _, visited := indices[node]; !visited {
+			strongConnect(node)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+type PackageItemRenderer struct{}
+
+func (pir *PackageItemRenderer) RenderItem(item GoPackage) string {
+	result := fmt.Sprintf("Package: %s (%s)", item.ImportPath, item.Kind)
+	if item.Dir != "" {
+		result += fmt.Sprintf(" at %s", item.Dir)
+	}
+	if len(item.Imports) > 0 {
+		result += fmt.Sprintf("\n  Imports: %s", strings.Join(item.Imports, ", "))
+		result += fmt.Sprintf("\n  Dependencies: %d direct, %d transitive", len(item.Imports), item.TransitiveDeps)
+	}
+	return result
+}
+
+// newJSONEncoder is the encoder of every -format json report: indented,
+// and without escaping "->" or "<" in reasons and signatures.
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder
+}
+
+// WriteImportGraph renders the graph as text, JSON or Graphviz DOT.
+func WriteImportGraph(w io.Writer, graph *ImportGraph, format string) error {
+	packages := graph.Sorted()
+
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(struct {
+			Packages []GoPackage `json:"packages"`
+			Cycles   [][]string  `json:"cycles"`
+		}{packages, graph.Cycles()})
+	case "dot":
+		fmt.Fprintln(w, "digraph imports {")
+		fmt.Fprintln(w, "\trankdir=LR;")
+		for _, pkg := range packages {
+			shape := "box"
+			if pkg.Kind != PackageKindInternal {
+				shape = "ellipse"
+			}
+			fmt.Fprintf(w, "\t%q [shape=%s];\n", pkg.ImportPath, shape)
+		}
+		for _, pkg := range packages {
+			for _, dep := range pkg.Imports {
+				fmt.Fprintf(w, "\t%q -> %q;\n", pkg.ImportPath, dep)
+			}
+		}
+		fmt.Fprintln(w, "}")
+		return nil
+	case "text", "":
+		renderer := &PackageItemRenderer{}
+		fmt.Fprintln(w, "\n--- Import Graph (Dependency Order) ---")
+		for _, pkg := range packages {
+			fmt.Fprintf(w, "[Level %d] %s\n", pkg.Level, renderer.RenderItem(pkg))
+		}
+
+		fmt.Fprintln(w, "\n--- Import Cycles ---")
+		if len(graph.Cycles()) == 0 {
+			fmt.Fprintln(w, "No import cycles")
+		}
+		for _, cycle := range graph.Cycles() {
+			fmt.Fprintf(w, "Cycle among %s: %s\n", strings.Join(cycle, ", "), strings.Join(graph.CyclePath(cycle), " -> "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text, json or dot)", format)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		adjacency map[string][]string
+		want      [][]string
+	}{
+		{
+			name:      "acyclic",
+			adjacency: map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": nil},
+			want:      [][]string{},
+		},
+		{
+			name:      "two packages",
+			adjacency: map[string][]string{"a": {"b"}, "b": {"a"}},
+			want:      [][]string{{"a", "b"}},
+		},
+		{
+			name:      "self import",
+			adjacency: map[string][]string{"a": {"a"}, "b": {"a"}},
+			want:      [][]string{{"a"}},
+		},
+		{
+			name:      "separate cycles",
+			adjacency: map[string][]string{"a": {"b"}, "b": {"a", "c"}, "c": {"d"}, "d": {"e"}, "e": {"c"}},
+			want:      [][]string{{"a", "b"}, {"c", "d", "e"}},
+		},
+		{
+			name:      "overlapping cycles form one component",
+			adjacency: map[string][]string{"a": {"b"}, "b": {"c", "a"}, "c": {"b"}, "d": {"a"}},
+			want:      [][]string{{"a", "b", "c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findCycles(tt.adjacency); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findCycles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportGraphCyclePath(t *testing.T) {
+	graph := func(adjacency map[string][]string) *ImportGraph {
+		g := &ImportGraph{packages: make(map[string]*GoPackage)}
+		for path, imports := range adjacency {
+			g.packages[path] = &GoPackage{ImportPath: path, Imports: imports}
+		}
+		return g
+	}
+
+	tests := []struct {
+		name      string
+		adjacency map[string][]string
+		want      []string
+	}{
+		{
+			name:      "members not importing in sorted order",
+			adjacency: map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			want:      []string{"a", "c", "b", "a"},
+		},
+		{
+			name:      "shortest of several cycles",
+			adjacency: map[string][]string{"a": {"b", "d"}, "b": {"c"}, "c": {"a"}, "d": {"a"}},
+			want:      []string{"a", "d", "a"},
+		},
+		{
+			name:      "first import wins among equal lengths",
+			adjacency: map[string][]string{"a": {"x", "b"}, "x": {"a"}, "b": {"a"}},
+			want:      []string{"a", "x", "a"},
+		},
+		{
+			name:      "self import",
+			adjacency: map[string][]string{"a": {"a"}},
+			want:      []string{"a", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := graph(tt.adjacency)
+			cycles := findCycles(g.adjacency())
+			if len(cycles) != 1 {
+				t.Fatalf("findCycles() = %v, want one cycle", cycles)
+			}
+			if got := g.CyclePath(cycles[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CyclePath(%v) = %v, want %v", cycles[0], got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Layer groups packages by import path globs. Globs starting with "./"
+// match the package directory relative to the working directory instead.
+type Layer struct {
+	Name     string
+	Patterns []string
+	matchers []*regexp.Regexp
+}
+
+// layerRule is one allow or deny line of the rules file.
+type layerRule struct {
+	allow    bool
+	from     string
+	to       []string
+	position string
+}
+
+// LayerRules is read from a rules file such as:
+//
+//	layer domain  example.com/app/internal/domain/...
+//	layer app     example.com/app/internal/app/...
+//	layer infra   example.com/app/internal/infra/... ./pkg/db
+//
+//	allow app -> domain
+//	deny domain -> app, infra
+//
+// A layer with allow rules may only depend on the layers it allows. Deny
+// rules forbid a dependency regardless. Packages outside every layer are
+// not constrained.
+type LayerRules struct {
+	Layers []*Layer
+	rules  []layerRule
+}
+
+// layerGlob translates a package glob into a regular expression: "*"
+// matches within one path element, "..." and "**" match across elements,
+// and a trailing "/..." also matches the package itself.
+func layerGlob(pattern string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "/..."):
+			builder.WriteString("(/.*)?")
+			i += 4
+		case strings.HasPrefix(pattern[i:], "..."):
+			builder.WriteString(".*")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			builder.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			builder.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			builder.WriteString("[^/]")
+			i++
+		default:
+			builder.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+func LoadLayerRules(path string) (*LayerRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := &LayerRules{}
+	layers := make(map[string]*Layer)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		position := fmt.Sprintf("%s:%d", path, lineNo)
+
+		switch fields[0] {
+		case "layer":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%s: layer needs a name and at least one package glob", position)
+			}
+			if _, exists := layers[fields[1]]; exists {
+				return nil, fmt.Errorf("%s: layer %s is already defined", position, fields[1])
+			}
+			layer := &Layer{Name: fields[1], Patterns: fields[2:]}
+			for _, pattern := range layer.Patterns {
+				matcher, err := layerGlob(strings.TrimPrefix(pattern, "./"))
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid glob %s: %v", position, pattern, err)
+				}
+				layer.matchers = append(layer.matchers, matcher)
+			}
+			layers[layer.Name] = layer
+			rules.Layers = append(rules.Layers, layer)
+		case "allow", "deny":
+			rest := strings.Join(fields[1:], " ")
+			parts := strings.SplitN(rest, "->", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("%s: expected %s <layer> -> <layer>[, <layer>...]", position, fields[0])
+			}
+			rule := layerRule{allow: fields[0] == "allow", from: strings.TrimSpace(parts[0]), position: position}
+			for _, to := range strings.Split(parts[1], ",") {
+				if to = strings.TrimSpace(to); to != "" {
+					rule.to = append(rule.to, to)
+				}
+			}
+			if rule.from == "" || len(rule.to) == 0 {
+				return nil, fmt.Errorf("%s: expected %s <layer> -> <layer>[, <layer>...]", position, fields[0])
+			}
+			rules.rules = append(rules.rules, rule)
+		default:
+			return nil, fmt.Errorf("%s: unknown directive %s", position, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rules may come before the layers they name, so check names last
+	for _, rule := range rules.rules {
+		for _, name := range append([]string{rule.from}, rule.to...) {
+			if _, ok := layers[name]; !ok {
+				return nil, fmt.Errorf("%s: unknown layer %s", rule.position, name)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// LayerOf returns the first layer matching the package, or "".
+func (lr *LayerRules) LayerOf(importPath, dir string) string {
+	relDir := ""
+	if dir != "" {
+		relDir = filepath.ToSlash(workingDirRelative(filepath.Clean(dir)))
+	}
+
+	for _, layer := range lr.Layers {
+		for i, matcher := range layer.matchers {
+			subject := importPath
+			if strings.HasPrefix(layer.Patterns[i], "./") {
+				subject = relDir
+			}
+			if subject != "" && matcher.MatchString(subject) {
+				return layer.Name
+			}
+		}
+	}
+	return ""
+}
+
+// Check reports whether layer from may depend on layer to, and the rule
+// that decided it when it may not.
+func (lr *LayerRules) Check(from, to string) (bool, string) {
+	if from == "" || to == "" || from == to {
+		return true, ""
+	}
+
+	restricted := false
+	for _, rule := range lr.rules {
+		if rule.from != from {
+			continue
+		}
+		for _, target := range rule.to {
+			if target != to {
+				continue
+			}
+			if !rule.allow {
+				return false, fmt.Sprintf("%s -> %s is denied at %s", from, to, rule.position)
+			}
+		}
+		if rule.allow {
+			restricted = true
+		}
+	}
+
+	if !restricted {
+		return true, ""
+	}
+	for _, rule := range lr.rules {
+		if !rule.allow || rule.from != from {
+			continue
+		}
+		for _, target := range rule.to {
+			if target == to {
+				return true, ""
+			}
+		}
+	}
+	return false, fmt.Sprintf("%s may only depend on %s", from, strings.Join(lr.allowed(from), ", "))
+}
+
+func (lr *LayerRules) allowed(from string) []string {
+	allowed := make([]string, 0)
+	for _, rule := range lr.rules {
+		if rule.allow && rule.from == from {
+			for _, to := range rule.to {
+				allowed = appendUnique(allowed, to)
+			}
+		}
+	}
+	return allowed
+}
+
+// LayerViolation is a forbidden import, or a forbidden type reference
+// between packages that don't import each other directly. The type
+// references behind an import violation are listed in References.
+type LayerViolation struct {
+	Kind       string   `json:"kind"`
+	Position   string   `json:"position"`
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	FromLayer  string   `json:"fromLayer"`
+	ToLayer    string   `json:"toLayer"`
+	Detail     string   `json:"detail,omitempty"`
+	Reason     string   `json:"reason"`
+	References []string `json:"references,omitempty"`
+}
+
+// CheckLayers checks the import graph and the type references of structs,
+// interfaces and functions against the rules. Each forbidden dependency is
+// reported once per import, with the type references it carries.
+func CheckLayers(rules *LayerRules, graph *ImportGraph, opts AnalysisOptions, resolver PackageResolver) []LayerViolation {
+	violations := make([]LayerViolation, 0)
+	references := make([]LayerViolation, 0)
+	imports := opts.Imports.WithPackageNames(packageNames(opts, resolver))
+	layerOf := func(importPath string) string {
+		pkg, _ := graph.Package(importPath)
+		return rules.LayerOf(importPath, pkg.Dir)
+	}
+
+	for _, pkg := range graph.Sorted() {
+		if pkg.Dir == "" {
+			continue
+		}
+		fromLayer := rules.LayerOf(pkg.ImportPath, pkg.Dir)
+		for _, dep := range pkg.Imports {
+			toLayer := layerOf(dep)
+			ok, reason := rules.Check(fromLayer, toLayer)
+			if ok {
+				continue
+			}
+			for _, position := range graph.ImportPositions(pkg.ImportPath, dep) {
+				violations = append(violations, LayerViolation{
+					Kind:      "import",
+					Position:  position,
+					From:      pkg.ImportPath,
+					To:        dep,
+					FromLayer: fromLayer,
+					ToLayer:   toLayer,
+					Reason:    reason,
+				})
+			}
+		}
+	}
+
+	checkTypes := func(dir, pkgName, position, detail string, typeStrs []string) {
+		from := resolver.ImportPath(dir, pkgName)
+		fromLayer := rules.LayerOf(from, dir)
+		for _, typeStr := range typeStrs {
+			for _, path := range imports.ImportPathsFor(position, []string{typeStr}) {
+				to := graph.Resolve(path)
+				toLayer := layerOf(to)
+				ok, reason := rules.Check(fromLayer, toLayer)
+				if ok {
+					continue
+				}
+				references = append(references, LayerViolation{
+					Kind:      "type",
+					Position:  position,
+					From:      from,
+					To:        to,
+					FromLayer: fromLayer,
+					ToLayer:   toLayer,
+					Detail:    fmt.Sprintf("%s uses %s", detail, typeStr),
+					Reason:    reason,
+				})
+			}
+		}
+	}
+
+	for _, src := range opts.StructSources.CollectResults() {
+		for _, st := range src.Items {
+			checkTypes(src.Dir, src.Package, st.Position, "struct "+st.Name, st.Fields)
+		}
+	}
+	for _, src := range opts.InterfaceSources.CollectResults() {
+		for _, iface := range src.Items {
+			checkTypes(src.Dir, src.Package, iface.Position, "interface "+iface.Name, iface.Methods)
+		}
+	}
+	for _, src := range opts.FunctionSources.CollectResults() {
+		for _, fn := range src.Items {
+			name := "func " + fn.Name
+			if fn.Receiver != "" {
+				name = fmt.Sprintf("method (%s).%s", fn.Receiver, fn.Name)
+			}
+			checkTypes(src.Dir, src.Package, fn.Position, name, append(append([]string{}, fn.Parameters...), fn.Returns...))
+		}
+	}
+
+	sortViolations(violations)
+	sortViolations(references)
+
+	// Type references go below the first import of the same dependency
+	first := make(map[string]int)
+	for i, v := range violations {
+		if _, ok := first[v.From+" "+v.To]; !ok {
+			first[v.From+" "+v.To] = i
+		}
+	}
+	unmatched := make([]LayerViolation, 0)
+	for _, ref := range references {
+		if i, ok := first[ref.From+" "+ref.To]; ok {
+			violations[i].References = append(violations[i].References, fmt.Sprintf("%s: %s", ref.Position, ref.Detail))
+		} else {
+			unmatched = append(unmatched, ref)
+		}
+	}
+	violations = append(violations, unmatched...)
+	sortViolations(violations)
+	return violations
+}
+
+func sortViolations(violations []LayerViolation) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		fi, fj := positionFile(violations[i].Position), positionFile(violations[j].Position)
+		if fi != fj {
+			return fi < fj
+		}
+		return positionLine(violations[i].Position) < positionLine(violations[j].Position)
+	})
+}
+
+// packageNames maps the import paths of the analyzed packages to the names
+// of their package clauses.
+func packageNames(opts AnalysisOptions, resolver PackageResolver) map[string]string {
+	names := make(map[string]string)
+	add := func(dir, pkgName string) {
+		names[resolver.ImportPath(dir, pkgName)] = pkgName
+	}
+	for _, src := range opts.StructSources.CollectResults() {
+		add(src.Dir, src.Package)
+	}
+	for _, src := range opts.InterfaceSources.CollectResults() {
+		add(src.Dir, src.Package)
+	}
+	for _, src := range opts.FunctionSources.CollectResults() {
+		add(src.Dir, src.Package)
+	}
+	return names
+}
+
+func WriteLayerViolations(w io.Writer, violations []LayerViolation, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(violations)
+	case "text", "":
+		fmt.Fprintln(w, "\n--- Layer Violations ---")
+		if len(violations) == 0 {
+			fmt.Fprintln(w, "No layer violations")
+			return nil
+		}
+		for _, v := range violations {
+			subject := fmt.Sprintf("%s (%s) imports %s (%s)", v.From, v.FromLayer, v.To, v.ToLayer)
+			if v.Kind == "type" {
+				subject = fmt.Sprintf("%s (%s) -> %s (%s): %s", v.From, v.FromLayer, v.To, v.ToLayer, v.Detail)
+			}
+			fmt.Fprintf(w, "%s: %s: %s\n", v.Position, subject, v.Reason)
+			for _, ref := range v.References {
+				fmt.Fprintf(w, "  %s\n", ref)
+			}
+		}
+		fmt.Fprintf(w, "%d layer violation(s)\n", len(violations))
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLayerRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "layers.rules")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLayerGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "example.com/app/domain", path: "example.com/app/domain", want: true},
+		{pattern: "example.com/app/domain", path: "example.com/app/domain/order", want: false},
+		{pattern: "example.com/app/domain/...", path: "example.com/app/domain", want: true},
+		{pattern: "example.com/app/domain/...", path: "example.com/app/domain/order/item", want: true},
+		{pattern: "example.com/app/domain/...", path: "example.com/app/domainx", want: false},
+		{pattern: "example.com/*/domain", path: "example.com/app/domain", want: true},
+		{pattern: "example.com/*/domain", path: "example.com/a/b/domain", want: false},
+		{pattern: "example.com/**/domain", path: "example.com/a/b/domain", want: true},
+		{pattern: "example.com/app/v?", path: "example.com/app/v2", want: true},
+		{pattern: "pkg/db", path: "pkg/db", want: true},
+	}
+
+	for _, tt := range tests {
+		re, err := layerGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("layerGlob(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("layerGlob(%q) matches %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLayerRulesCheck(t *testing.T) {
+	rules, err := LoadLayerRules(writeLayerRules(t, `
+# Layers of the app
+layer domain example.com/app/domain/...
+layer app    example.com/app/app/...
+layer infra  example.com/app/infra/... ./pkg/db
+layer cmd    example.com/app/cmd/...
+
+allow app -> domain, infra
+deny domain -> app, infra
+deny cmd -> domain
+allow cmd -> app
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     bool
+		reason   string
+	}{
+		{from: "app", to: "domain", want: true},
+		{from: "app", to: "infra", want: true},
+		{from: "app", to: "cmd", want: false, reason: "app may only depend on domain, infra"},
+		{from: "domain", to: "infra", want: false, reason: "domain -> infra is denied at"},
+		{from: "domain", to: "cmd", want: true},
+		{from: "infra", to: "app", want: true},
+		{from: "cmd", to: "domain", want: false, reason: "cmd -> domain is denied at"},
+		{from: "cmd", to: "app", want: true},
+		{from: "domain", to: "domain", want: true},
+		{from: "", to: "infra", want: true},
+		{from: "domain", to: "", want: true},
+	}
+
+	for _, tt := range tests {
+		ok, reason := rules.Check(tt.from, tt.to)
+		if ok != tt.want || !strings.Contains(reason, tt.reason) {
+			t.Errorf("Check(%q, %q) = %v, %q, want %v, %q", tt.from, tt.to, ok, reason, tt.want, tt.reason)
+		}
+	}
+
+	layers := []struct {
+		importPath, dir, want string
+	}{
+		{importPath: "example.com/app/domain", want: "domain"},
+		{importPath: "example.com/app/domain/order", want: "domain"},
+		{importPath: "example.com/app/other", want: ""},
+		{importPath: "example.com/legacy/db", dir: "pkg/db", want: "infra"},
+	}
+	for _, tt := range layers {
+		if got := rules.LayerOf(tt.importPath, tt.dir); got != tt.want {
+			t.Errorf("LayerOf(%q, %q) = %q, want %q", tt.importPath, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLayerRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "layer without globs", content: "layer domain", want: "layer needs a name"},
+		{name: "duplicate layer", content: "layer a x/...\nlayer a y/...", want: "layer a is already defined"},
+		{name: "rule without arrow", content: "layer a x/...\nallow a", want: "expected allow"},
+		{name: "unknown layer", content: "layer a x/...\ndeny a -> b", want: "unknown layer b"},
+		{name: "unknown directive", content: "forbid a -> b", want: "unknown directive forbid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadLayerRules(writeLayerRules(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadLayerRules() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLayerViolationsJSON(t *testing.T) {
+	var out strings.Builder
+	violations := []LayerViolation{{Kind: "type", Position: "app/app.go:3:2", From: "m/domain", To: "m/infra", Detail: "Service -> infra.DB", Reason: "domain must not depend on infra"}}
+	if err := WriteLayerViolations(&out, violations, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"detail": "Service -> infra.DB"`) {
+		t.Errorf("WriteLayerViolations() escaped the arrow:\n%s", out.String())
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const (
+	LayoutPerFile      = "file"
+	LayoutPerInterface = "interface"
+	LayoutPerPackage   = "package"
+)
+
+// SourceItems holds the items of one kind found in a single source file.
+type SourceItems[T any] struct {
+	File    string
+	Dir     string
+	Package string
+	Items   []T
+}
+
+type SourceItemsCollector[T any] struct {
+	results []SourceItems[T]
+}
+
+func NewSourceItemsCollector[T any]() *SourceItemsCollector[T] {
+	return &SourceItemsCollector[T]{results: make([]SourceItems[T], 0)}
+}
+
+func (sic *SourceItemsCollector[T]) CollectResults() []SourceItems[T] {
+	return sic.results
+}
+
+func (sic *SourceItemsCollector[T]) AddResult(item SourceItems[T]) {
+	sic.results = append(sic.results, item)
+}
+
+func (sic *SourceItemsCollector[T]) Merge(other *SourceItemsCollector[T]) {
+	sic.results = append(sic.results, other.results...)
+}
+
+// OutputNameData is what filename templates are executed against. Name is
+// the item name for the per-item layout, the source file name (without
+// extension) for the per-file layout and the package name for the
+// per-package layout.
+type OutputNameData struct {
+	Package  string
+	Name     string
+	File     string
+	Dir      string
+	Kind     string
+	Template string
+}
+
+type OutputLayout struct {
+	Mode         string
+	Mirror       bool
+	NameTemplate string
+	PackageName  string
+	Root         string
+	Kind         string
+	Template     string
+}
+
+func defaultNameTemplate(mode, prefix, suffix string) string {
+	switch mode {
+	case LayoutPerPackage:
+		return prefix + "_{{.Package}}_" + suffix + ".go"
+	case LayoutPerFile:
+		return prefix + "_{{.File}}_" + suffix + ".go"
+	default:
+		return prefix + "_{{snake .Name}}.go"
+	}
+}
+
+var outputNameFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"snake": toSnakeCase,
+}
+
+func toSnakeCase(name string) string {
+	var builder strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				builder.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// PlannedFile is one output file together with the sources it was built from.
+type PlannedFile[T any] struct {
+	Path    string
+	Package string
+	Sources []string
+	Items   []T
+}
+
+type OutputPlanner[T any] struct {
+	layout           OutputLayout
+	nameTemplate     *template.Template
+	typeNameProvider TypeNameProvider[T]
+	sorter           ItemSorter[T]
+}
+
+func NewOutputPlanner[T any](
+	layout OutputLayout,
+	defaultTemplate string,
+	nameProvider TypeNameProvider[T],
+	sorter ItemSorter[T],
+) (*OutputPlanner[T], error) {
+	nameTemplate := layout.NameTemplate
+	if nameTemplate == "" {
+		nameTemplate = defaultTemplate
+		if layout.Mirror {
+			nameTemplate = "{{if .Dir}}{{.Dir}}/{{end}}" + nameTemplate
+		}
+	}
+
+	tmpl, err := template.New("output").Funcs(outputNameFuncs).Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output name template %q: %v", nameTemplate, err)
+	}
+
+	switch layout.Mode {
+	case LayoutPerFile, LayoutPerInterface, LayoutPerPackage:
+	default:
+		return nil, fmt.Errorf("unknown output layout %q (want %s, %s or %s)", layout.Mode, LayoutPerFile, LayoutPerInterface, LayoutPerPackage)
+	}
+
+	return &OutputPlanner[T]{
+		layout:           layout,
+		nameTemplate:     tmpl,
+		typeNameProvider: nameProvider,
+		sorter:           sorter,
+	}, nil
+}
+
+// Plan groups the sources into output files according to the layout. It
+// fails without producing anything if two groups map to the same path.
+func (op *OutputPlanner[T]) Plan(sources []SourceItems[T]) ([]PlannedFile[T], error) {
+	planned := make(map[string]*PlannedFile[T])
+	order := make([]string, 0)
+	owners := make(map[string]map[string]bool)
+
+	add := func(key string, data OutputNameData, src SourceItems[T], items []T) error {
+		path, err := op.outputPath(data)
+		if err != nil {
+			return err
+		}
+
+		if owners[path] == nil {
+			owners[path] = make(map[string]bool)
+		}
+		owners[path][key] = true
+
+		file, exists := planned[path]
+		if !exists {
+			pkgName := op.layout.PackageName
+			if pkgName == "" {
+				pkgName = src.Package
+			}
+			file = &PlannedFile[T]{Path: path, Package: pkgName}
+			planned[path] = file
+			order = append(order, path)
+		}
+		file.Sources = appendUnique(file.Sources, src.File)
+		file.Items = append(file.Items, items...)
+		return nil
+	}
+
+	for _, src := range sources {
+		fileName := strings.TrimSuffix(filepath.Base(src.File), ".go")
+		data := OutputNameData{
+			Package:  src.Package,
+			File:     fileName,
+			Dir:      mirrorDir(src.Dir),
+			Kind:     op.layout.Kind,
+			Template: op.layout.Template,
+		}
+
+		switch op.layout.Mode {
+		case LayoutPerFile:
+			if len(src.Items) == 0 {
+				continue
+			}
+			data.Name = fileName
+			if err := add(src.File, data, src, src.Items); err != nil {
+				return nil, err
+			}
+		case LayoutPerPackage:
+			if len(src.Items) == 0 {
+				continue
+			}
+			data.Name = src.Package
+			if err := add(src.Dir, data, src, src.Items); err != nil {
+				return nil, err
+			}
+		case LayoutPerInterface:
+			for _, item := range src.Items {
+				data.Name = op.typeNameProvider.GetTypeName(item)
+				if err := add(src.Dir+"#"+data.Name, data, src, []T{item}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	collisions := make([]string, 0)
+	for _, path := range order {
+		if len(owners[path]) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s (from %s)", path, strings.Join(planned[path].Sources, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("output file collisions:\n  %s", strings.Join(collisions, "\n  "))
+	}
+
+	result := make([]PlannedFile[T], 0, len(order))
+	for _, path := range order {
+		file := planned[path]
+		if op.sorter != nil {
+			file.Items = op.sorter.SortItems(file.Items)
+		}
+		result = append(result, *file)
+	}
+	return result, nil
+}
+
+// plannedPaths returns the output paths of the planned files.
+func plannedPaths[T any](files []PlannedFile[T]) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+// PlannedOutput is the type-erased result of planning one generator, so
+// that outputs of different generators can be checked against each other
+// before anything is written.
+type PlannedOutput struct {
+	Label string
+	Paths []string
+	Write func() ([]string, error)
+}
+
+func planOutput[T any](
+	label string,
+	planner *OutputPlanner[T],
+	sources []SourceItems[T],
+	generator *GenericCodeGenerator[T],
+	renderer FileRenderer[T],
+) (PlannedOutput, error) {
+	files, err := planner.Plan(sources)
+	if err != nil {
+		return PlannedOutput{}, err
+	}
+
+	return PlannedOutput{
+		Label: label,
+		Paths: plannedPaths(files),
+		Write: func() ([]string, error) {
+			return writePlannedFiles(files, generator, renderer)
+		},
+	}, nil
+}
+
+// checkPathCollisions reports paths claimed by more than one output.
+func checkPathCollisions(outputs []PlannedOutput) error {
+	owners := make(map[string][]string)
+	for _, output := range outputs {
+		for _, path := range output.Paths {
+			owners[path] = append(owners[path], output.Label)
+		}
+	}
+
+	collisions := make([]string, 0)
+	for path, groups := range owners {
+		if len(groups) > 1 {
+			sort.Strings(groups)
+			collisions = append(collisions, fmt.Sprintf("%s (from %s)", path, strings.Join(groups, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return fmt.Errorf("output file collisions:\n  %s", strings.Join(collisions, "\n  "))
+	}
+	return nil
+}
+
+func (op *OutputPlanner[T]) outputPath(data OutputNameData) (string, error) {
+	var buf bytes.Buffer
+	if err := op.nameTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to expand output name: %v", err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(buf.String()))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output name %q escapes the output directory", buf.String())
+	}
+	return filepath.Join(op.layout.Root, rel), nil
+}
+
+// mirrorDir returns the directory to recreate under the output root. Paths
+// are taken relative to the working directory so that several -dirs
+// entries keep their own subtrees.
+func mirrorDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				dir = rel
+			}
+		}
+	}
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." {
+		return ""
+	}
+	return strings.TrimPrefix(dir, "/")
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// renderGeneratedFile joins generated snippets under the standard header.
+func renderGeneratedFile(pkgName string, snippets []string) (string, error) {
+	var builder strings.Builder
+	builder.WriteString(generatedHeader + "\n\n")
+	builder.WriteString(fmt.Sprintf("package %s\n\n", pkgName))
+	for _, snippet := range snippets {
+		builder.WriteString(snippet)
+		builder.WriteString("\n")
+	}
+	return formatGenerated(builder.String())
+}
+
+// formatGenerated gofmts a generated file, so that generated code passes
+// gofmt -l like the rest of the tree.
+func formatGenerated(content string) (string, error) {
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		return "", fmt.Errorf("generated code is not valid Go: %v", err)
+	}
+	return string(formatted), nil
+}
+
+type FileRenderer[T any] interface {
+	RenderFile(file PlannedFile[T], snippets []string) (string, error)
+}
+
+type GeneratedFileRenderer[T any] struct{}
+
+func (gfr *GeneratedFileRenderer[T]) RenderFile(file PlannedFile[T], snippets []string) (string, error) {
+	return renderGeneratedFile(file.Package, snippets)
+}
+
+// writePlannedFiles renders every planned file with the code generator and
+// hands it to the writer. It returns the paths that were written.
+func writePlannedFiles[T any](files []PlannedFile[T], generator *GenericCodeGenerator[T], renderer FileRenderer[T]) ([]string, error) {
+	written := make([]string, 0, len(files))
+	for _, file := range files {
+		snippets := make([]string, 0, len(file.Items))
+		for _, item := range file.Items {
+			if code := generator.GenerateImplementation(item); code != "" {
+				snippets = append(snippets, code)
+			}
+		}
+		if len(snippets) == 0 {
+			continue
+		}
+
+		content, err := renderer.RenderFile(file, snippets)
+		if err != nil {
+			return written, fmt.Errorf("failed to render %s: %v", file.Path, err)
+		}
+
+		if err := generator.WriteToFile(content, file.Path); err != nil {
+			return written, fmt.Errorf("failed to write %s: %v", file.Path, err)
+		}
+		written = append(written, file.Path)
+	}
+	return written, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputPlannerPlan(t *testing.T) {
+	sources := []SourceItems[GoInterface]{
+		{File: "a/store.go", Dir: "a", Package: "a", Items: []GoInterface{{Name: "Store"}, {Name: "Reader"}}},
+		{File: "a/cache.go", Dir: "a", Package: "a", Items: []GoInterface{{Name: "Cache"}}},
+		{File: "b/store.go", Dir: "b", Package: "b", Items: []GoInterface{{Name: "Store"}}},
+		{File: "b/empty.go", Dir: "b", Package: "b"},
+	}
+
+	tests := []struct {
+		name      string
+		layout    OutputLayout
+		want      []string
+		collision string
+	}{
+		{
+			name:      "per file collides on equal file names",
+			layout:    OutputLayout{Mode: LayoutPerFile},
+			collision: "noop_store_interfaces.go (from a/store.go, b/store.go)",
+		},
+		{
+			name:   "per file mirrored",
+			layout: OutputLayout{Mode: LayoutPerFile, Mirror: true},
+			want:   []string{"a/noop_store_interfaces.go", "a/noop_cache_interfaces.go", "b/noop_store_interfaces.go"},
+		},
+		{
+			name:   "per package",
+			layout: OutputLayout{Mode: LayoutPerPackage},
+			want:   []string{"noop_a_interfaces.go", "noop_b_interfaces.go"},
+		},
+		{
+			name:      "per interface collides on equal names",
+			layout:    OutputLayout{Mode: LayoutPerInterface},
+			collision: "noop_store.go (from a/store.go, b/store.go)",
+		},
+		{
+			name:   "per interface with a name template",
+			layout: OutputLayout{Mode: LayoutPerInterface, NameTemplate: "{{.Package}}/{{lower .Name}}_noop.go"},
+			want:   []string{"a/store_noop.go", "a/reader_noop.go", "a/cache_noop.go", "b/store_noop.go"},
+		},
+		{
+			name:      "name template escaping the root",
+			layout:    OutputLayout{Mode: LayoutPerPackage, NameTemplate: "../{{.Package}}.go"},
+			collision: "escapes the output directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planner, err := NewOutputPlanner[GoInterface](tt.layout, defaultNameTemplate(tt.layout.Mode, "noop", "interfaces"), &InterfaceTypeNameProvider{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := planner.Plan(sources)
+			if tt.collision != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.collision) {
+					t.Fatalf("Plan() error = %v, want one containing %q", err, tt.collision)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := make([]string, 0, len(tt.want))
+			for _, path := range tt.want {
+				want = append(want, filepath.FromSlash(path))
+			}
+			if got := plannedPaths(files); !reflect.DeepEqual(got, want) {
+				t.Errorf("Plan() paths = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewOutputPlannerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout OutputLayout
+	}{
+		{name: "unknown mode", layout: OutputLayout{Mode: "tree"}},
+		{name: "invalid name template", layout: OutputLayout{Mode: LayoutPerFile, NameTemplate: "{{.Name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOutputPlanner[GoInterface](tt.layout, "x.go", &InterfaceTypeNameProvider{}, nil); err == nil {
+				t.Error("NewOutputPlanner() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckPathCollisions(t *testing.T) {
+	outputs := []PlannedOutput{
+		{Label: "noop", Paths: []string{"gen/a.go", "gen/b.go"}},
+		{Label: "stub", Paths: []string{"gen/b.go", "gen/c.go"}},
+	}
+	err := checkPathCollisions(outputs)
+	if err == nil || !strings.Contains(err.Error(), "gen/b.go (from noop, stub)") {
+		t.Errorf("checkPathCollisions() = %v, want a collision on gen/b.go", err)
+	}
+	if err := checkPathCollisions(outputs[:1]); err != nil {
+		t.Errorf("checkPathCollisions() = %v, want nil", err)
+	}
+}
+
+func TestRenderGeneratedFile(t *testing.T) {
+	content, err := renderGeneratedFile("noop", []string{"type  NoOpStore struct{}\nfunc (n *NoOpStore) Get() error {\nreturn nil\n}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := generatedHeader + "\n\npackage noop\n\ntype NoOpStore struct{}\n\nfunc (n *NoOpStore) Get() error {\n\treturn nil\n}\n"
+	if content != want {
+		t.Errorf("renderGeneratedFile() = %q, want %q", content, want)
+	}
+
+	if _, err := renderGeneratedFile("noop", []string{"func broken( {"}); err == nil {
+		t.Error("renderGeneratedFile() succeeded on invalid code, want an error")
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
-	"flag"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +24,12 @@ type NodeVisitor[T any] interface {
 	VisitNode(node ast.Node) T
 }
 
+// NodeLeaveVisitor is implemented by node visitors whose items span the
+// nodes below a node; LeaveNode follows the last of them.
+type NodeLeaveVisitor[T any] interface {
+	LeaveNode(node ast.Node) T
+}
+
 type ResultCollector[T any] interface {
 	CollectResults() []T
 	AddResult(item T)
@@ -46,6 +59,10 @@ type PackageProvider[T any] interface {
 	GetPackage(item T) string
 }
 
+type PositionProvider[T any] interface {
+	GetPosition(item T) string
+}
+
 type ItemSorter[T any] interface {
 	SortItems(items []T) []T
 }
@@ -97,6 +114,17 @@ func (gv *GenericVisitor[T]) Visit(node ast.Node) T {
 	return result
 }
 
+func (gv *GenericVisitor[T]) Leave(node ast.Node) T {
+	var result T
+	if leaver, ok := gv.nodeVisitor.(NodeLeaveVisitor[T]); ok {
+		result = leaver.LeaveNode(node)
+		if gv.validator.IsValid(result) {
+			gv.collector.AddResult(result)
+		}
+	}
+	return result
+}
+
 func (gv *GenericVisitor[T]) GetResults() []T {
 	return gv.collector.CollectResults()
 }
@@ -123,6 +151,17 @@ func (ds *DependencySorter[T]) SortItems(items []T) []T {
 	return ds.dependencyResolver.ResolveDependencies(items)
 }
 
+func newItemSorter[T any](
+	extractor DependencyExtractor[T],
+	nameProvider TypeNameProvider[T],
+	useTopologicalSort bool,
+) ItemSorter[T] {
+	if useTopologicalSort {
+		return NewDependencySorter(extractor, nameProvider, NewTopologicalDependencyResolver(extractor, nameProvider))
+	}
+	return NewDependencySorter(extractor, nameProvider, NewAlphabeticalDependencyResolver(nameProvider))
+}
+
 type GenericFormatter[T any] struct {
 	itemRenderer    ItemRenderer[T]
 	outputFormatter OutputFormatter[T]
@@ -177,32 +216,51 @@ func (gcg *GenericCodeGenerator[T]) WriteToFile(content string, filename string)
 }
 
 type GoStruct struct {
-	Name     string
-	Package  string
-	Fields   []string
-	Methods  []string
-	Position string
-	Level    int
+	Name       string
+	Package    string
+	TypeParams []string
+	Fields     []string
+	Methods    []string
+	Position   string
+	Level      int
 }
 
 type GoInterface struct {
-	Name     string
-	Package  string
-	Methods  []string
-	Position string
-	Level    int
+	Name       string
+	Package    string
+	TypeParams []string
+	Methods    []string
+	Position   string
+	Level      int
 }
 
-type GoFunction struct {
+// GoType is a named type that is neither a struct nor an interface, such as
+// type ID string or an alias.
+type GoType struct {
 	Name       string
 	Package    string
-	Receiver   string
-	Parameters []string
-	Returns    []string
+	TypeParams []string
+	Underlying string
+	Alias      bool
 	Position   string
 	Level      int
 }
 
+type GoFunction struct {
+	Name         string
+	Package      string
+	Receiver     string
+	Parameters   []string
+	Returns      []string
+	Position     string
+	Level        int
+	Cyclomatic   int
+	Cognitive    int
+	Statements   int
+	Nesting      int
+	ReturnPoints int
+}
+
 type GoVariable struct {
 	Name     string
 	Package  string
@@ -223,6 +281,7 @@ type GoConstant struct {
 type GoImport struct {
 	Name     string
 	Path     string
+	Kind     string
 	Position string
 	Level    int
 }
@@ -255,10 +314,11 @@ func (snv *StructNodeVisitor) VisitNode(node ast.Node) GoStruct {
 			}
 
 			return GoStruct{
-				Name:     ts.Name.Name,
-				Package:  snv.pkg,
-				Fields:   fields,
-				Position: snv.fset.Position(ts.Pos()).String(),
+				Name:       ts.Name.Name,
+				Package:    snv.pkg,
+				TypeParams: formatTypeParams(ts.TypeParams),
+				Fields:     fields,
+				Position:   snv.fset.Position(ts.Pos()).String(),
 			}
 		}
 	}
@@ -366,16 +426,110 @@ func (inv *InterfaceNodeVisitor) VisitNode(node ast.Node) GoInterface {
 			}
 
 			return GoInterface{
-				Name:     ts.Name.Name,
-				Package:  inv.pkg,
-				Methods:  methods,
-				Position: inv.fset.Position(ts.Pos()).String(),
+				Name:       ts.Name.Name,
+				Package:    inv.pkg,
+				TypeParams: formatTypeParams(ts.TypeParams),
+				Methods:    methods,
+				Position:   inv.fset.Position(ts.Pos()).String(),
 			}
 		}
 	}
 	return GoInterface{}
 }
 
+type TypeNodeVisitor struct {
+	fset *token.FileSet
+	pkg  string
+}
+
+func NewTypeNodeVisitor(fset *token.FileSet, pkg string) *TypeNodeVisitor {
+	return &TypeNodeVisitor{fset: fset, pkg: pkg}
+}
+
+func (tnv *TypeNodeVisitor) VisitNode(node ast.Node) GoType {
+	if ts, ok := node.(*ast.TypeSpec); ok {
+		switch ts.Type.(type) {
+		case *ast.StructType, *ast.InterfaceType:
+			return GoType{}
+		}
+		return GoType{
+			Name:       ts.Name.Name,
+			Package:    tnv.pkg,
+			TypeParams: formatTypeParams(ts.TypeParams),
+			Underlying: formatType(ts.Type),
+			Alias:      ts.Assign.IsValid(),
+			Position:   tnv.fset.Position(ts.Pos()).String(),
+		}
+	}
+	return GoType{}
+}
+
+type TypeResultCollector struct {
+	results []GoType
+}
+
+func NewTypeResultCollector() *TypeResultCollector {
+	return &TypeResultCollector{results: make([]GoType, 0)}
+}
+
+func (trc *TypeResultCollector) CollectResults() []GoType {
+	return trc.results
+}
+
+func (trc *TypeResultCollector) AddResult(item GoType) {
+	trc.results = append(trc.results, item)
+}
+
+type TypeValidator struct{}
+
+func (tv *TypeValidator) IsValid(item GoType) bool {
+	return item.Name != ""
+}
+
+type TypeDependencyExtractor struct{}
+
+func (tde *TypeDependencyExtractor) ExtractDependencies(item GoType) []string {
+	deps := make([]string, 0)
+	for _, dep := range extractTypeDependencies(item.Underlying) {
+		if dep != item.Name {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+type TypeTypeNameProvider struct{}
+
+func (ttnp *TypeTypeNameProvider) GetTypeName(item GoType) string {
+	return item.Name
+}
+
+type TypePackageProvider struct{}
+
+func (tpp *TypePackageProvider) GetPackage(item GoType) string {
+	return item.Package
+}
+
+type TypeItemRenderer struct{}
+
+func (tir *TypeItemRenderer) RenderItem(item GoType) string {
+	if item.Name == "" {
+		return ""
+	}
+	result := fmt.Sprintf("Type: %s", item.Name)
+	if len(item.TypeParams) > 0 {
+		result += fmt.Sprintf("[%s]", strings.Join(item.TypeParams, ", "))
+	}
+	if item.Alias {
+		result += " ="
+	}
+	result += fmt.Sprintf(" %s (Package: %s) at %s", item.Underlying, item.Package, item.Position)
+	if item.Level > 0 {
+		result += fmt.Sprintf("\n  Level: %d", item.Level)
+	}
+	return result
+}
+
 type InterfaceResultCollector struct {
 	results []GoInterface
 }
@@ -495,9 +649,14 @@ func (iin *InterfaceImplementationNamer) GetImplementationName(item GoInterface)
 	return fmt.Sprintf("NoOp%s", item.Name)
 }
 
+// FunctionNodeVisitor starts a function at its declaration and measures
+// its body from the nodes visited after it, so it returns the function
+// from LeaveNode rather than VisitNode.
 type FunctionNodeVisitor struct {
-	fset *token.FileSet
-	pkg  string
+	fset    *token.FileSet
+	pkg     string
+	item    GoFunction
+	metrics *bodyMetrics
 }
 
 func NewFunctionNodeVisitor(fset *token.FileSet, pkg string) *FunctionNodeVisitor {
@@ -539,7 +698,7 @@ func (fnv *FunctionNodeVisitor) VisitNode(node ast.Node) GoFunction {
 			}
 		}
 
-		return GoFunction{
+		fnv.item = GoFunction{
 			Name:       fn.Name.Name,
 			Package:    fnv.pkg,
 			Receiver:   receiver,
@@ -547,10 +706,28 @@ func (fnv *FunctionNodeVisitor) VisitNode(node ast.Node) GoFunction {
 			Returns:    returns,
 			Position:   fnv.fset.Position(fn.Pos()).String(),
 		}
+		fnv.metrics = newBodyMetrics(fn)
+	} else if fnv.metrics != nil {
+		fnv.metrics.enter(node)
 	}
 	return GoFunction{}
 }
 
+func (fnv *FunctionNodeVisitor) LeaveNode(node ast.Node) GoFunction {
+	if fnv.metrics == nil {
+		return GoFunction{}
+	}
+	if node != fnv.metrics.fn {
+		fnv.metrics.leave(node)
+		return GoFunction{}
+	}
+
+	item := fnv.item
+	fnv.metrics.fill(&item)
+	fnv.item, fnv.metrics = GoFunction{}, nil
+	return item
+}
+
 type FunctionResultCollector struct {
 	results []GoFunction
 }
@@ -640,6 +817,9 @@ func (fir *FunctionItemRenderer) RenderItem(item GoFunction) string {
 	if len(item.Returns) > 0 {
 		result += fmt.Sprintf("\n  Returns: %s", strings.Join(item.Returns, ", "))
 	}
+	if metrics := formatFunctionMetrics(item); metrics != "" {
+		result += fmt.Sprintf("\n  Complexity: %s", metrics)
+	}
 	if item.Level > 0 {
 		result += fmt.Sprintf("\n  Level: %d", item.Level)
 	}
@@ -824,11 +1004,12 @@ func (cir *ConstantItemRenderer) RenderItem(item GoConstant) string {
 }
 
 type ImportNodeVisitor struct {
-	fset *token.FileSet
+	fset  *token.FileSet
+	graph *ImportGraph
 }
 
-func NewImportNodeVisitor(fset *token.FileSet) *ImportNodeVisitor {
-	return &ImportNodeVisitor{fset: fset}
+func NewImportNodeVisitor(fset *token.FileSet, graph *ImportGraph) *ImportNodeVisitor {
+	return &ImportNodeVisitor{fset: fset, graph: graph}
 }
 
 func (inv *ImportNodeVisitor) VisitNode(node ast.Node) GoImport {
@@ -843,9 +1024,17 @@ func (inv *ImportNodeVisitor) VisitNode(node ast.Node) GoImport {
 			path = is.Path.Value
 		}
 
+		kind := ""
+		if inv.graph != nil {
+			if unquoted, err := strconv.Unquote(path); err == nil {
+				kind = inv.graph.Kind(unquoted)
+			}
+		}
+
 		return GoImport{
 			Name:     name,
 			Path:     path,
+			Kind:     kind,
 			Position: inv.fset.Position(is.Pos()).String(),
 		}
 	}
@@ -874,10 +1063,27 @@ func (iv *ImportValidator) IsValid(item GoImport) bool {
 	return item.Path != ""
 }
 
-type ImportDependencyExtractor struct{}
+// ImportDependencyExtractor orders imports by the package import graph;
+// without a graph imports have no dependencies.
+type ImportDependencyExtractor struct {
+	graph *ImportGraph
+}
 
 func (ide *ImportDependencyExtractor) ExtractDependencies(item GoImport) []string {
-	return []string{} // Imports don't have dependencies in our model
+	if ide.graph == nil {
+		return []string{}
+	}
+
+	path, err := strconv.Unquote(item.Path)
+	if err != nil {
+		return []string{}
+	}
+
+	deps := make([]string, 0)
+	for _, dep := range ide.graph.TransitiveImports(path) {
+		deps = append(deps, strconv.Quote(dep))
+	}
+	return deps
 }
 
 type ImportTypeNameProvider struct{}
@@ -902,6 +1108,9 @@ func (iir *ImportItemRenderer) RenderItem(item GoImport) string {
 	if item.Name != "" && item.Name != "." {
 		result = fmt.Sprintf("Import: %s as %s", item.Path, item.Name)
 	}
+	if item.Kind != "" {
+		result += fmt.Sprintf(" (%s)", item.Kind)
+	}
 	result += fmt.Sprintf(" at %s", item.Position)
 	if item.Level > 0 {
 		result += fmt.Sprintf("\n  Level: %d", item.Level)
@@ -1034,14 +1243,35 @@ func (sof *SimpleOutputFormatter[T]) FormatOutput(items []T) string {
 type SimpleFileWriter struct{}
 
 func (sfw *SimpleFileWriter) WriteToFile(content string, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
 	return os.WriteFile(filename, []byte(content), 0644)
 }
 
+type MemoryFileWriter struct {
+	files map[string]string
+}
+
+func NewMemoryFileWriter() *MemoryFileWriter {
+	return &MemoryFileWriter{files: make(map[string]string)}
+}
+
+func (mfw *MemoryFileWriter) WriteToFile(content string, filename string) error {
+	mfw.files[filepath.Clean(filename)] = content
+	return nil
+}
+
+func (mfw *MemoryFileWriter) Files() map[string]string {
+	return mfw.files
+}
+
 type AnalysisEngine[T any] struct {
 	visitor       *GenericVisitor[T]
 	sorter        ItemSorter[T]
 	formatter     *GenericFormatter[T]
 	codeGenerator *GenericCodeGenerator[T]
+	filter        ItemValidator[T]
 }
 
 func NewAnalysisEngine[T any](
@@ -1058,11 +1288,32 @@ func NewAnalysisEngine[T any](
 	}
 }
 
-func (ae *AnalysisEngine[T]) Analyze(node ast.Node) {
-	ast.Inspect(node, func(n ast.Node) bool {
-		ae.visitor.Visit(n)
-		return true
-	})
+// SetFilter hides items from PrintResults. Sorted results and generated
+// code still include them.
+func (ae *AnalysisEngine[T]) SetFilter(filter ItemValidator[T]) {
+	ae.filter = filter
+}
+
+// AnalyzeNode visits node alone; a DeclarationTraversal supplies the
+// nodes below it, then calls LeaveNode.
+func (ae *AnalysisEngine[T]) AnalyzeNode(node ast.Node) {
+	ae.visitor.Visit(node)
+}
+
+func (ae *AnalysisEngine[T]) LeaveNode(node ast.Node) {
+	ae.visitor.Leave(node)
+}
+
+// Load adds items extracted earlier, as if they had just been visited.
+func (ae *AnalysisEngine[T]) Load(items []T) {
+	for _, item := range items {
+		ae.visitor.collector.AddResult(item)
+	}
+}
+
+// Results returns the items visited, unsorted.
+func (ae *AnalysisEngine[T]) Results() []T {
+	return ae.visitor.GetResults()
 }
 
 func (ae *AnalysisEngine[T]) GetSortedResults() []T {
@@ -1073,45 +1324,35 @@ func (ae *AnalysisEngine[T]) GetSortedResults() []T {
 	return results
 }
 
-func (ae *AnalysisEngine[T]) PrintResults() {
+func (ae *AnalysisEngine[T]) PrintResults(w io.Writer) {
 	results := ae.GetSortedResults()
+	if ae.filter != nil {
+		shown := make([]T, 0, len(results))
+		for _, result := range results {
+			if ae.filter.IsValid(result) {
+				shown = append(shown, result)
+			}
+		}
+		results = shown
+	}
 
 	for level, result := range results {
 		formatted := ae.formatter.FormatItem(result)
 		if formatted != "" {
-			fmt.Printf("[Level %d] %s\n", level, formatted)
+			fmt.Fprintf(w, "[Level %d] %s\n", level, formatted)
 
 			// Generate NoOp if available
 			if ae.codeGenerator != nil {
 				if noopImpl := ae.codeGenerator.GenerateImplementation(result); noopImpl != "" {
-					fmt.Printf("\n--- NoOp Implementation ---\n")
-					fmt.Println(noopImpl)
+					fmt.Fprintf(w, "\n--- NoOp Implementation ---\n")
+					fmt.Fprintln(w, noopImpl)
 				}
 			}
 		}
 	}
 }
 
-func (ae *AnalysisEngine[T]) GenerateCodeFile(filename string) error {
-	if ae.codeGenerator == nil {
-		return fmt.Errorf("code generator not available")
-	}
-
-	results := ae.GetSortedResults()
-
-	var builder strings.Builder
-	builder.WriteString("// Code generated by go-ast-analyzer; DO NOT EDIT.\n\n")
-	builder.WriteString("package main\n\n")
-
-	for _, result := range results {
-		if code := ae.codeGenerator.GenerateImplementation(result); code != "" {
-			builder.WriteString(code)
-			builder.WriteString("\n")
-		}
-	}
-
-	return ae.codeGenerator.WriteToFile(builder.String(), filename)
-}
+const generatedHeader = "// Code generated by go-ast-analyzer; DO NOT EDIT."
 
 func extractTypeDependencies(typeStr string) []string {
 	deps := make(map[string]bool)
@@ -1121,6 +1362,7 @@ func extractTypeDependencies(typeStr string) []string {
 	cleaned = strings.ReplaceAll(cleaned, "map[", "")
 	cleaned = strings.ReplaceAll(cleaned, "chan ", "")
 	cleaned = strings.ReplaceAll(cleaned, "<-", "")
+	cleaned = strings.ReplaceAll(cleaned, "...", "")
 
 	words := strings.FieldsFunc(cleaned, func(c rune) bool {
 		return c == '(' || c == ')' || c == '[' || c == ']' || c == '{' || c == '}' ||
@@ -1204,24 +1446,40 @@ func formatType(expr ast.Expr) string {
 	case *ast.MapType:
 		return fmt.Sprintf("map[%s]%s", formatType(t.Key), formatType(t.Value))
 	case *ast.ChanType:
-		dir := ""
-		if t.Dir == ast.SEND {
-			dir = "<-"
-		} else if t.Dir == ast.RECV {
-			dir = "<-"
+		switch t.Dir {
+		case ast.SEND:
+			return "chan<- " + formatType(t.Value)
+		case ast.RECV:
+			return "<-chan " + formatType(t.Value)
 		}
-		return fmt.Sprintf("%schan %s", dir, formatType(t.Value))
+		return "chan " + formatType(t.Value)
 	case *ast.FuncType:
 		return formatFuncType(t)
-	case *ast.InterfaceType:
-		return "interface{}"
 	case *ast.SelectorExpr:
 		return fmt.Sprintf("%s.%s", formatType(t.X), t.Sel.Name)
 	case *ast.IndexExpr:
 		return fmt.Sprintf("%s[%s]", formatType(t.X), formatType(t.Index))
+	case *ast.Ellipsis:
+		return "..." + formatType(t.Elt)
 	default:
-		return "unknown"
+		// Inline interfaces and structs, instantiations with several
+		// type arguments and parenthesized types
+		return types.ExprString(expr)
+	}
+}
+
+func formatTypeParams(fields *ast.FieldList) []string {
+	params := make([]string, 0)
+	if fields == nil {
+		return params
 	}
+	for _, field := range fields.List {
+		constraint := types.ExprString(field.Type)
+		for _, name := range field.Names {
+			params = append(params, fmt.Sprintf("%s %s", name.Name, constraint))
+		}
+	}
+	return params
 }
 
 func formatFuncType(ft *ast.FuncType) string {
@@ -1401,57 +1659,189 @@ func getZeroValue(typ string) string {
 	}
 }
 
-func analyzeDecl(decl ast.Decl, engines map[string]interface{}) {
-	switch d := decl.(type) {
-	case *ast.GenDecl:
-		switch d.Tok {
-		case token.TYPE:
-			for _, spec := range d.Specs {
-				if engine, ok := engines["structs"].(*AnalysisEngine[GoStruct]); ok {
-					engine.Analyze(spec)
-				}
-				if engine, ok := engines["interfaces"].(*AnalysisEngine[GoInterface]); ok {
-					engine.Analyze(spec)
-				}
-			}
-		case token.VAR:
-			if engine, ok := engines["variables"].(*AnalysisEngine[GoVariable]); ok {
+type NodeAnalyzer interface {
+	AnalyzeNode(node ast.Node)
+	LeaveNode(node ast.Node)
+}
+
+// DeclarationTraversal walks the declarations of a file once, handing every
+// node to each analyzer registered for the kind of declaration it belongs
+// to: token.TYPE, token.VAR, token.CONST and token.IMPORT for the specs of
+// a general declaration, token.FUNC for function declarations. Each node is
+// left once the nodes below it have been analyzed. Declarations no analyzer
+// is registered for are skipped.
+type DeclarationTraversal struct {
+	analyzers map[token.Token][]NodeAnalyzer
+	stack     []ast.Node
+}
+
+func NewDeclarationTraversal() *DeclarationTraversal {
+	return &DeclarationTraversal{analyzers: make(map[token.Token][]NodeAnalyzer)}
+}
+
+func (dt *DeclarationTraversal) Register(kind token.Token, analyzer NodeAnalyzer) {
+	dt.analyzers[kind] = append(dt.analyzers[kind], analyzer)
+}
+
+func (dt *DeclarationTraversal) Walk(file *ast.File) {
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if analyzers := dt.analyzers[d.Tok]; len(analyzers) > 0 {
 				for _, spec := range d.Specs {
-					engine.Analyze(spec)
+					dt.inspect(spec, analyzers)
 				}
 			}
-		case token.CONST:
-			if engine, ok := engines["constants"].(*AnalysisEngine[GoConstant]); ok {
-				for _, spec := range d.Specs {
-					engine.Analyze(spec)
-				}
+		case *ast.FuncDecl:
+			if analyzers := dt.analyzers[token.FUNC]; len(analyzers) > 0 {
+				dt.inspect(d, analyzers)
 			}
-		case token.IMPORT:
-			if engine, ok := engines["imports"].(*AnalysisEngine[GoImport]); ok {
-				for _, spec := range d.Specs {
-					engine.Analyze(spec)
-				}
+		}
+	}
+}
+
+func (dt *DeclarationTraversal) inspect(root ast.Node, analyzers []NodeAnalyzer) {
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil {
+			left := dt.stack[len(dt.stack)-1]
+			dt.stack = dt.stack[:len(dt.stack)-1]
+			for _, analyzer := range analyzers {
+				analyzer.LeaveNode(left)
 			}
+			return true
 		}
-	case *ast.FuncDecl:
-		if engine, ok := engines["functions"].(*AnalysisEngine[GoFunction]); ok {
-			engine.Analyze(d)
+		dt.stack = append(dt.stack, n)
+		for _, analyzer := range analyzers {
+			analyzer.AnalyzeNode(n)
 		}
+		return true
+	})
+}
+
+type AnalysisOptions struct {
+	SelectedTypes      map[string]bool
+	UseTopologicalSort bool
+	GenNoOp            bool
+	IncludeTests       bool
+	NonRecursive       bool
+	FunctionSort       string
+	FunctionFilter     ItemValidator[GoFunction]
+	InterfaceSources   *SourceItemsCollector[GoInterface]
+	StructSources      *SourceItemsCollector[GoStruct]
+	FunctionSources    *SourceItemsCollector[GoFunction]
+	VariableSources    *SourceItemsCollector[GoVariable]
+	ConstantSources    *SourceItemsCollector[GoConstant]
+	TypeSources        *SourceItemsCollector[GoType]
+	Imports            *ImportIndex
+	Graph              *ImportGraph
+	Changes            *ChangeScope
+	Cache              *AnalysisCache
+	Build              *build.Context
+	Files              *FileSelector
+	Diagnostics        *Diagnostics
+	Jobs               int
+	Output             io.Writer
+}
+
+// ensureCollectors selects kinds and sets up the source collectors of
+// those collecting into SourceItems, keeping collectors that already exist,
+// and the import index.
+func (opts *AnalysisOptions) ensureCollectors(kinds ...string) {
+	for _, kind := range kinds {
+		opts.SelectedTypes[kind] = true
+		switch kind {
+		case "structs":
+			if opts.StructSources == nil {
+				opts.StructSources = NewSourceItemsCollector[GoStruct]()
+			}
+		case "interfaces":
+			if opts.InterfaceSources == nil {
+				opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+			}
+		case "functions":
+			if opts.FunctionSources == nil {
+				opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+			}
+		case "variables":
+			if opts.VariableSources == nil {
+				opts.VariableSources = NewSourceItemsCollector[GoVariable]()
+			}
+		case "constants":
+			if opts.ConstantSources == nil {
+				opts.ConstantSources = NewSourceItemsCollector[GoConstant]()
+			}
+		case "types":
+			if opts.TypeSources == nil {
+				opts.TypeSources = NewSourceItemsCollector[GoType]()
+			}
+		}
+	}
+	if opts.Imports == nil {
+		opts.Imports = NewImportIndex()
 	}
 }
 
-func processFile(filename string, selectedTypes map[string]bool, useTopologicalSort, genNoOp bool, noOpDir string) error {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+func processFile(filename string, opts AnalysisOptions) error {
+	selectedTypes := opts.SelectedTypes
+	useTopologicalSort := opts.UseTopologicalSort
+	out := opts.Output
+
+	content, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("failed to parse %s: %v", filename, err)
+		if err := opts.Diagnostics.Report(filename, err); err != nil {
+			return fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		return nil
+	}
+
+	// Cached declarations stand in for parsing when they cover every
+	// selected kind
+	var decls *FileDeclarations
+	if opts.Cache != nil {
+		decls = opts.Cache.Load(filename, content)
+	}
+	fset := token.NewFileSet()
+	var node *ast.File
+	partial := false
+	if decls == nil || !decls.Has(selectedTypes) {
+		node, err = parser.ParseFile(fset, filename, content, parser.ParseComments)
+		if err != nil {
+			if err := opts.Diagnostics.Report(filename, err); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", filename, err)
+			}
+			// The declarations parsed before the errors are still analyzed
+			if !hasPackageClause(node) {
+				return nil
+			}
+			partial = true
+		}
+		if decls == nil {
+			decls = &FileDeclarations{}
+		}
+		decls.Package = node.Name.Name
+
+		importVisitor := NewImportNodeVisitor(fset, opts.Graph)
+		decls.Imports = make([]GoImport, 0, len(node.Imports))
+		for _, spec := range node.Imports {
+			decls.Imports = append(decls.Imports, importVisitor.VisitNode(spec))
+		}
+	} else {
+		classifyImports(decls.Imports, opts.Graph)
 	}
 
-	pkg := node.Name.Name
-	fmt.Printf("\n=== Analyzing file: %s ===\n", filename)
+	pkg := decls.Package
+	if opts.Changes != nil && !opts.Changes.ShowsFile(filename) {
+		out = io.Discard
+	}
+	fmt.Fprintf(out, "\n=== Analyzing file: %s ===\n", filename)
 
-	// Create analysis engines for selected types
+	if opts.Imports != nil {
+		opts.Imports.Add(filename, decls.Imports)
+	}
+
+	// Create analysis engines for selected types; one traversal feeds them all
 	engines := make(map[string]interface{})
+	traversal := NewDeclarationTraversal()
 
 	if selectedTypes["structs"] {
 		structVisitor := NewGenericVisitor(
@@ -1492,7 +1882,12 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			nil,
 		)
 
+		if opts.Changes != nil {
+			structEngine.SetFilter(NewChangedItemValidator[GoStruct](opts.Changes, &StructPositionProvider{}, nil))
+		}
+
 		engines["structs"] = structEngine
+		traversal.Register(token.TYPE, structEngine)
 	}
 
 	if selectedTypes["interfaces"] {
@@ -1528,7 +1923,7 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 		)
 
 		var interfaceCodeGen *GenericCodeGenerator[GoInterface]
-		if genNoOp {
+		if opts.GenNoOp {
 			interfaceCodeGen = NewGenericCodeGenerator(
 				&InterfaceNoOpCodeGenerator{},
 				&InterfaceImplementationNamer{},
@@ -1543,7 +1938,59 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			interfaceCodeGen,
 		)
 
+		if opts.Changes != nil {
+			interfaceEngine.SetFilter(NewChangedItemValidator[GoInterface](opts.Changes, &InterfacePositionProvider{}, nil))
+		}
+
 		engines["interfaces"] = interfaceEngine
+		traversal.Register(token.TYPE, interfaceEngine)
+	}
+
+	if selectedTypes["types"] {
+		typeVisitor := NewGenericVisitor(
+			NewTypeNodeVisitor(fset, pkg),
+			NewTypeResultCollector(),
+			&TypeValidator{},
+		)
+
+		var typeSorter ItemSorter[GoType]
+		if useTopologicalSort {
+			typeSorter = NewDependencySorter(
+				&TypeDependencyExtractor{},
+				&TypeTypeNameProvider{},
+				NewTopologicalDependencyResolver(
+					&TypeDependencyExtractor{},
+					&TypeTypeNameProvider{},
+				),
+			)
+		} else {
+			typeSorter = NewDependencySorter(
+				&TypeDependencyExtractor{},
+				&TypeTypeNameProvider{},
+				NewAlphabeticalDependencyResolver(
+					&TypeTypeNameProvider{},
+				),
+			)
+		}
+
+		typeFormatter := NewGenericFormatter(
+			&TypeItemRenderer{},
+			&SimpleOutputFormatter[GoType]{},
+		)
+
+		typeEngine := NewAnalysisEngine(
+			typeVisitor,
+			typeSorter,
+			typeFormatter,
+			nil,
+		)
+
+		if opts.Changes != nil {
+			typeEngine.SetFilter(NewChangedItemValidator[GoType](opts.Changes, &TypePositionProvider{}, nil))
+		}
+
+		engines["types"] = typeEngine
+		traversal.Register(token.TYPE, typeEngine)
 	}
 
 	if selectedTypes["functions"] {
@@ -1554,7 +2001,16 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 		)
 
 		var functionSorter ItemSorter[GoFunction]
-		if useTopologicalSort {
+		if metric, err := NewFunctionMetricProvider(opts.FunctionSort); err == nil {
+			functionSorter = NewDependencySorter(
+				&FunctionDependencyExtractor{},
+				&FunctionTypeNameProvider{},
+				NewMetricDependencyResolver[GoFunction](
+					metric,
+					&FunctionTypeNameProvider{},
+				),
+			)
+		} else if useTopologicalSort && opts.FunctionSort != SortByName {
 			functionSorter = NewDependencySorter(
 				&FunctionDependencyExtractor{},
 				&FunctionTypeNameProvider{},
@@ -1585,7 +2041,12 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			nil,
 		)
 
+		if opts.FunctionFilter != nil {
+			functionEngine.SetFilter(opts.FunctionFilter)
+		}
+
 		engines["functions"] = functionEngine
+		traversal.Register(token.FUNC, functionEngine)
 	}
 
 	if selectedTypes["variables"] {
@@ -1627,7 +2088,12 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			nil,
 		)
 
+		if opts.Changes != nil {
+			variableEngine.SetFilter(NewChangedItemValidator[GoVariable](opts.Changes, &VariablePositionProvider{}, nil))
+		}
+
 		engines["variables"] = variableEngine
+		traversal.Register(token.VAR, variableEngine)
 	}
 
 	if selectedTypes["constants"] {
@@ -1669,12 +2135,17 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			nil,
 		)
 
+		if opts.Changes != nil {
+			constantEngine.SetFilter(NewChangedItemValidator[GoConstant](opts.Changes, &ConstantPositionProvider{}, nil))
+		}
+
 		engines["constants"] = constantEngine
+		traversal.Register(token.CONST, constantEngine)
 	}
 
 	if selectedTypes["imports"] {
 		importVisitor := NewGenericVisitor(
-			NewImportNodeVisitor(fset),
+			NewImportNodeVisitor(fset, opts.Graph),
 			NewImportResultCollector(),
 			&ImportValidator{},
 		)
@@ -1682,16 +2153,16 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 		var importSorter ItemSorter[GoImport]
 		if useTopologicalSort {
 			importSorter = NewDependencySorter(
-				&ImportDependencyExtractor{},
+				&ImportDependencyExtractor{graph: opts.Graph},
 				&ImportTypeNameProvider{},
 				NewTopologicalDependencyResolver(
-					&ImportDependencyExtractor{},
+					&ImportDependencyExtractor{graph: opts.Graph},
 					&ImportTypeNameProvider{},
 				),
 			)
 		} else {
 			importSorter = NewDependencySorter(
-				&ImportDependencyExtractor{},
+				&ImportDependencyExtractor{graph: opts.Graph},
 				&ImportTypeNameProvider{},
 				NewAlphabeticalDependencyResolver(
 					&ImportTypeNameProvider{},
@@ -1711,159 +2182,176 @@ func processFile(filename string, selectedTypes map[string]bool, useTopologicalS
 			nil,
 		)
 
+		if opts.Changes != nil {
+			importEngine.SetFilter(NewChangedItemValidator[GoImport](opts.Changes, &ImportPositionProvider{}, nil))
+		}
+
 		engines["imports"] = importEngine
+		traversal.Register(token.IMPORT, importEngine)
 	}
 
 	// Analyze declarations
-	for _, decl := range node.Decls {
-		analyzeDecl(decl, engines)
+	if node != nil {
+		traversal.Walk(node)
+		if opts.Cache != nil && !partial {
+			decls.extractFrom(engines)
+			if err := opts.Cache.Store(filename, content, decls); err != nil {
+				opts.Cache.Warn(err)
+			}
+		}
+	} else {
+		decls.loadInto(engines)
 	}
 
 	// Print results for each selected type
 	if engine, ok := engines["structs"].(*AnalysisEngine[GoStruct]); ok {
-		fmt.Println("\n--- Structs (Dependency Order) ---")
-		engine.PrintResults()
+		fmt.Fprintln(out, "\n--- Structs (Dependency Order) ---")
+		engine.PrintResults(out)
+
+		if opts.StructSources != nil {
+			opts.StructSources.AddResult(SourceItems[GoStruct]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
+		}
 	}
 
 	if engine, ok := engines["interfaces"].(*AnalysisEngine[GoInterface]); ok {
-		fmt.Println("\n--- Interfaces (Dependency Order) ---")
-		engine.PrintResults()
-
-		// Generate NoOp file if requested
-		if genNoOp && noOpDir != "" {
-			baseFilename := filepath.Base(filename)
-			noOpFilename := filepath.Join(noOpDir, "noop_"+strings.TrimSuffix(baseFilename, ".go")+"_interfaces.go")
-			if err := engine.GenerateCodeFile(noOpFilename); err != nil {
-				log.Printf("Failed to generate NoOp file %s: %v", noOpFilename, err)
-			} else {
-				fmt.Printf("Generated NoOp implementations: %s\n", noOpFilename)
-			}
+		fmt.Fprintln(out, "\n--- Interfaces (Dependency Order) ---")
+		engine.PrintResults(out)
+
+		// Collect interfaces for code generation; files are laid out and
+		// written once every source has been analyzed
+		if opts.InterfaceSources != nil {
+			opts.InterfaceSources.AddResult(SourceItems[GoInterface]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
+		}
+	}
+
+	if engine, ok := engines["types"].(*AnalysisEngine[GoType]); ok {
+		fmt.Fprintln(out, "\n--- Named Types (Dependency Order) ---")
+		engine.PrintResults(out)
+
+		if opts.TypeSources != nil {
+			opts.TypeSources.AddResult(SourceItems[GoType]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
 		}
 	}
 
 	if engine, ok := engines["functions"].(*AnalysisEngine[GoFunction]); ok {
-		fmt.Println("\n--- Functions (Dependency Order) ---")
-		engine.PrintResults()
+		if _, err := NewFunctionMetricProvider(opts.FunctionSort); err == nil {
+			fmt.Fprintf(out, "\n--- Functions (by %s) ---\n", opts.FunctionSort)
+		} else {
+			fmt.Fprintln(out, "\n--- Functions (Dependency Order) ---")
+		}
+		engine.PrintResults(out)
+
+		if opts.FunctionSources != nil {
+			opts.FunctionSources.AddResult(SourceItems[GoFunction]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
+		}
 	}
 
 	if engine, ok := engines["variables"].(*AnalysisEngine[GoVariable]); ok {
-		fmt.Println("\n--- Variables (Dependency Order) ---")
-		engine.PrintResults()
+		fmt.Fprintln(out, "\n--- Variables (Dependency Order) ---")
+		engine.PrintResults(out)
+
+		if opts.VariableSources != nil {
+			opts.VariableSources.AddResult(SourceItems[GoVariable]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
+		}
 	}
 
 	if engine, ok := engines["constants"].(*AnalysisEngine[GoConstant]); ok {
-		fmt.Println("\n--- Constants (Dependency Order) ---")
-		engine.PrintResults()
+		fmt.Fprintln(out, "\n--- Constants (Dependency Order) ---")
+		engine.PrintResults(out)
+
+		if opts.ConstantSources != nil {
+			opts.ConstantSources.AddResult(SourceItems[GoConstant]{
+				File:    filename,
+				Dir:     filepath.Dir(filename),
+				Package: pkg,
+				Items:   engine.GetSortedResults(),
+			})
+		}
 	}
 
 	if engine, ok := engines["imports"].(*AnalysisEngine[GoImport]); ok {
-		fmt.Println("\n--- Imports (Dependency Order) ---")
-		engine.PrintResults()
+		fmt.Fprintln(out, "\n--- Imports (Dependency Order) ---")
+		engine.PrintResults(out)
 	}
 
 	return nil
 }
 
-func walkDirectory(dir string, selectedTypes map[string]bool, useTopologicalSort, genNoOp bool, noOpDir string) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go") {
-			return processFile(path, selectedTypes, useTopologicalSort, genNoOp, noOpDir)
-		}
-
-		return nil
-	})
-}
-
-func main() {
-	var (
-		dirs        = flag.String("dirs", ".", "Comma-separated list of directories to analyze")
-		showStructs = flag.Bool("structs", false, "Show structs")
-		showIfaces  = flag.Bool("interfaces", false, "Show interfaces")
-		showFuncs   = flag.Bool("functions", false, "Show functions")
-		showVars    = flag.Bool("variables", false, "Show variables")
-		showConsts  = flag.Bool("constants", false, "Show constants")
-		showImports = flag.Bool("imports", false, "Show imports")
-		showAll     = flag.Bool("all", false, "Show all types")
-		topoSort    = flag.Bool("topo", true, "Use topological sorting based on dependencies")
-		alphaSort   = flag.Bool("alpha", false, "Use alphabetical sorting instead of topological")
-		genNoOp     = flag.Bool("noop", false, "Generate NoOp implementations for interfaces")
-		noOpDir     = flag.String("noop-dir", "./noop", "Directory to save NoOp implementations")
-	)
-
-	flag.Parse()
-
-	useTopologicalSort := *topoSort && !*alphaSort
-
-	selectedTypes := make(map[string]bool)
-
-	if *showAll {
-		selectedTypes["structs"] = true
-		selectedTypes["interfaces"] = true
-		selectedTypes["functions"] = true
-		selectedTypes["variables"] = true
-		selectedTypes["constants"] = true
-		selectedTypes["imports"] = true
-	} else {
-		selectedTypes["structs"] = *showStructs
-		selectedTypes["interfaces"] = *showIfaces
-		selectedTypes["functions"] = *showFuncs
-		selectedTypes["variables"] = *showVars
-		selectedTypes["constants"] = *showConsts
-		selectedTypes["imports"] = *showImports
+// walkDirectory analyzes the files under dir on opts.Jobs goroutines.
+// Output and collected results are merged in file order.
+func walkDirectory(ctx context.Context, dir string, opts AnalysisOptions) error {
+	files, err := analyzableFiles(dir, opts)
+	if err != nil {
+		return err
 	}
 
-	// If no specific type is selected, show all
-	hasSelection := false
-	for _, selected := range selectedTypes {
-		if selected {
-			hasSelection = true
-			break
-		}
+	type fileResult struct {
+		opts AnalysisOptions
+		buf  *bytes.Buffer
 	}
+	return runOrdered(ctx, len(files), opts.Jobs,
+		func(ctx context.Context, i int) (fileResult, error) {
+			fork, buf := forkFileOptions(opts)
+			return fileResult{opts: fork, buf: buf}, processFile(files[i], fork)
+		},
+		func(result fileResult) error {
+			return mergeFileOptions(opts, result.opts, result.buf)
+		},
+	)
+}
 
-	if !hasSelection {
-		selectedTypes["structs"] = true
-		selectedTypes["interfaces"] = true
-		selectedTypes["functions"] = true
-		selectedTypes["variables"] = true
-		selectedTypes["constants"] = true
-		selectedTypes["imports"] = true
+// isAnalyzableFile selects .go files, tests only when asked for, and with
+// opts.Build only the files its build constraints and filename suffixes
+// match. Files whose constraints can't be read are kept so parse errors
+// are reported. opts.Files narrows the selection further.
+func isAnalyzableFile(path string, opts AnalysisOptions) bool {
+	if !strings.HasSuffix(path, ".go") || (!opts.IncludeTests && strings.HasSuffix(path, "_test.go")) {
+		return false
 	}
-
-	// Create NoOp output directory if needed
-	if *genNoOp && *noOpDir != "" {
-		if err := os.MkdirAll(*noOpDir, 0755); err != nil {
-			log.Fatalf("Failed to create NoOp directory %s: %v", *noOpDir, err)
+	if opts.Build != nil {
+		if match, err := opts.Build.MatchFile(filepath.Dir(path), filepath.Base(path)); !match && err == nil {
+			return false
 		}
 	}
+	return opts.Files == nil || opts.Files.SelectsFile(path)
+}
 
-	directories := strings.Split(*dirs, ",")
-	sort.Strings(directories)
-
-	sortType := "Topological"
-	if !useTopologicalSort {
-		sortType = "Alphabetical"
-	}
-	fmt.Printf("Using %s sorting", sortType)
-	if *genNoOp {
-		fmt.Printf(" with NoOp generation enabled (output: %s)", *noOpDir)
-	}
-	fmt.Println()
-
-	for _, dir := range directories {
-		dir = strings.TrimSpace(dir)
-		if dir == "" {
-			continue
-		}
+func main() {
+	cmd, cfg := parseCommandLine(os.Args[1:])
 
-		fmt.Printf("\n=== Analyzing directory: %s ===\n", dir)
+	// Interrupting stops the analysis between files
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-		if err := walkDirectory(dir, selectedTypes, useTopologicalSort, *genNoOp, *noOpDir); err != nil {
-			log.Printf("Error analyzing directory %s: %v", dir, err)
+	if err := cmd.run(ctx, cfg); err != nil {
+		if !errors.Is(err, errFindings) {
+			log.Print(err)
 		}
+		os.Exit(1)
 	}
 }
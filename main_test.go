@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSyntheticTree writes packages with files of structs, interfaces,
+// functions with control flow, methods, variables and constants under
+// root, and returns the files.
+func writeSyntheticTree(tb testing.TB, root string, packages, files, declsPerFile int) []string {
+	tb.Helper()
+	paths := make([]string, 0, packages*files)
+	for p := 0; p < packages; p++ {
+		pkg := fmt.Sprintf("pkg%d", p)
+		dir := filepath.Join(root, pkg)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			var src strings.Builder
+			fmt.Fprintf(&src, "package %s\n\nimport (\n\t\"fmt\"\n\t\"io\"\n)\n\n", pkg)
+			for d := 0; d < declsPerFile; d++ {
+				name := fmt.Sprintf("T%d_%d", f, d)
+				fmt.Fprintf(&src, "const Limit%s = %d\n\nvar Default%s = &%s{ID: %d}\n\n", name, d, name, name, d)
+				fmt.Fprintf(&src, "type %s struct {\n\tID    int\n\tName  string\n\tTags  map[string][]string\n\tOut   io.Writer\n\tNext  *%s\n}\n\n", name, name)
+				fmt.Fprintf(&src, "type %sStore interface {\n\tGet(id int) (*%s, error)\n\tPut(item *%s) error\n\tio.Closer\n}\n\n", name, name, name)
+				fmt.Fprintf(&src, "func (t *%s) Process(items []int, limit int) (int, error) {\n", name)
+				src.WriteString("\ttotal := 0\n\tfor i, item := range items {\n\t\tif item > limit && i%2 == 0 || item < 0 {\n\t\t\tcontinue\n\t\t}\n")
+				src.WriteString("\t\tswitch {\n\t\tcase item%3 == 0:\n\t\t\ttotal += item\n\t\tcase item%5 == 0:\n\t\t\ttotal -= item\n\t\tdefault:\n\t\t\tfn := func() int { return item * 2 }\n\t\t\ttotal += fn()\n\t\t}\n\t}\n")
+				src.WriteString("\tif total < 0 {\n\t\treturn 0, fmt.Errorf(\"negative total %d\", total)\n\t}\n\treturn total, nil\n}\n\n")
+				fmt.Fprintf(&src, "func New%s(id int, name string) *%s {\n\treturn &%s{ID: id, Name: name}\n}\n\n", name, name, name)
+			}
+			path := filepath.Join(dir, fmt.Sprintf("file%d.go", f))
+			if err := os.WriteFile(path, []byte(src.String()), 0644); err != nil {
+				tb.Fatal(err)
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// writeTestModule writes files, keyed by slash separated path, to a new
+// temporary directory and returns it.
+func writeTestModule(tb testing.TB, files map[string]string) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// analyzeTestModule builds the import graph of root and walks it with
+// collectors for kinds, the way a run reporting on them does.
+func analyzeTestModule(tb testing.TB, root string, kinds ...string) (AnalysisOptions, PackageResolver) {
+	tb.Helper()
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	opts := AnalysisOptions{SelectedTypes: make(map[string]bool), Output: io.Discard}
+	opts.ensureCollectors(kinds...)
+	if opts.Graph, err = BuildImportGraph([]string{root}, opts, resolver); err != nil {
+		tb.Fatal(err)
+	}
+	if err := walkDirectory(context.Background(), root, opts); err != nil {
+		tb.Fatal(err)
+	}
+	return opts, resolver
+}
+
+func allKinds() map[string]bool {
+	return map[string]bool{"structs": true, "interfaces": true, "functions": true, "variables": true, "constants": true, "types": true, "imports": true}
+}
+
+func BenchmarkProcessFile(b *testing.B) {
+	files := writeSyntheticTree(b, b.TempDir(), 1, 4, 50)
+	opts := AnalysisOptions{
+		SelectedTypes:      allKinds(),
+		UseTopologicalSort: true,
+		FunctionSort:       SortByDependency,
+		Output:             io.Discard,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, file := range files {
+			if err := processFile(file, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWalkDirectory(b *testing.B) {
+	root := b.TempDir()
+	writeSyntheticTree(b, root, 8, 4, 20)
+
+	for _, jobs := range []int{1, 0} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opts := AnalysisOptions{
+					SelectedTypes:      allKinds(),
+					UseTopologicalSort: true,
+					FunctionSort:       SortByDependency,
+					Jobs:               jobs,
+					Output:             io.Discard,
+				}
+				opts.ensureCollectors("structs", "interfaces", "functions")
+				if err := walkDirectory(context.Background(), root, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDeclarationTraversal compares one walk dispatching every node
+// to all engines, function bodies included, with the per-spec walks it
+// replaced: each engine walked every spec of its kind on its own, and the
+// function engine walked each body three more times to measure it.
+func BenchmarkDeclarationTraversal(b *testing.B) {
+	paths := writeSyntheticTree(b, b.TempDir(), 1, 1, 200)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, paths[0], nil, parser.ParseComments)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	pkg := file.Name.Name
+	engines := func() (structs, interfaces, functions NodeAnalyzer) {
+		return NewAnalysisEngine(NewGenericVisitor(NewStructNodeVisitor(fset, pkg), NewStructResultCollector(), &StructValidator{}), nil, nil, nil),
+			NewAnalysisEngine(NewGenericVisitor(NewInterfaceNodeVisitor(fset, pkg), NewInterfaceResultCollector(), &InterfaceValidator{}), nil, nil, nil),
+			NewAnalysisEngine(NewGenericVisitor(NewFunctionNodeVisitor(fset, pkg), NewFunctionResultCollector(), &FunctionValidator{}), nil, nil, nil)
+	}
+
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			structs, interfaces, functions := engines()
+			traversal := NewDeclarationTraversal()
+			traversal.Register(token.TYPE, structs)
+			traversal.Register(token.TYPE, interfaces)
+			traversal.Register(token.FUNC, functions)
+			traversal.Walk(file)
+		}
+	})
+
+	b.Run("per-spec", func(b *testing.B) {
+		analyze := func(analyzer NodeAnalyzer, node ast.Node) {
+			traversal := NewDeclarationTraversal()
+			traversal.inspect(node, []NodeAnalyzer{analyzer})
+		}
+		for i := 0; i < b.N; i++ {
+			structs, interfaces, functions := engines()
+			for _, decl := range file.Decls {
+				switch d := decl.(type) {
+				case *ast.GenDecl:
+					if d.Tok != token.TYPE {
+						continue
+					}
+					for _, spec := range d.Specs {
+						analyze(structs, spec)
+						analyze(interfaces, spec)
+					}
+				case *ast.FuncDecl:
+					analyze(functions, d)
+					for walk := 0; walk < 3 && d.Body != nil; walk++ {
+						ast.Inspect(d.Body, func(ast.Node) bool { return true })
+					}
+				}
+			}
+		}
+	})
+}
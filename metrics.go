@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// PackageMetrics holds Robert Martin's package metrics. Coupling is counted
+// in module packages: Ca is the number of analyzed packages that depend on
+// this one, Ce the number of module packages it depends on, by import or by
+// type reference.
+type PackageMetrics struct {
+	Package      string  `json:"package"`
+	Dir          string  `json:"dir"`
+	Ca           int     `json:"ca"`
+	Ce           int     `json:"ce"`
+	Instability  float64 `json:"instability"`
+	Interfaces   int     `json:"interfaces"`
+	Concrete     int     `json:"concrete"`
+	Abstractness float64 `json:"abstractness"`
+	Distance     float64 `json:"distance"`
+}
+
+// Value returns a metric by its short name: Ca, Ce, I, A or D.
+func (pm PackageMetrics) Value(name string) (float64, bool) {
+	switch name {
+	case "Ca":
+		return float64(pm.Ca), true
+	case "Ce":
+		return float64(pm.Ce), true
+	case "I":
+		return pm.Instability, true
+	case "A":
+		return pm.Abstractness, true
+	case "D":
+		return pm.Distance, true
+	}
+	return 0, false
+}
+
+// DeclarationCounts counts the named types abstractness is computed from:
+// interfaces, and concrete types such as structs and other named types.
+type DeclarationCounts struct {
+	Interfaces int
+	Concrete   int
+}
+
+// CountDeclarations counts the declarations of every analyzed package in
+// graph. Parse errors were reported when the graph was built; the
+// declarations parsed before them still count.
+func CountDeclarations(graph *ImportGraph, opts AnalysisOptions, resolver PackageResolver) map[string]DeclarationCounts {
+	counts := make(map[string]DeclarationCounts)
+	opts.NonRecursive = true
+	fset := token.NewFileSet()
+	for _, pkg := range graph.Sorted() {
+		if pkg.Dir == "" || pkg.Name == "" {
+			continue
+		}
+		files, err := analyzableFiles(pkg.Dir, opts)
+		if err != nil {
+			continue
+		}
+		for _, path := range files {
+			file, _ := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+			if !hasPackageClause(file) {
+				continue
+			}
+			importPath := resolver.ImportPath(filepath.Dir(path), strings.TrimSuffix(file.Name.Name, "_test"))
+			c := counts[importPath]
+			for _, decl := range file.Decls {
+				d, ok := decl.(*ast.GenDecl)
+				if !ok || d.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range d.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if ts.Name.Name == "_" {
+						continue
+					}
+					if _, ok := ts.Type.(*ast.InterfaceType); ok {
+						c.Interfaces++
+					} else {
+						c.Concrete++
+					}
+				}
+			}
+			counts[importPath] = c
+		}
+	}
+	return counts
+}
+
+// ComputePackageMetrics computes metrics for every analyzed package.
+// Coupling follows the imports of graph and references, the packages whose
+// types each package refers to (see DependencyGraph.PackageReferences).
+// Stdlib and third-party packages don't count. Abstractness is the share
+// of interfaces among the counted declarations.
+func ComputePackageMetrics(graph *ImportGraph, counts map[string]DeclarationCounts, references map[string][]string) []PackageMetrics {
+	afferent := make(map[string]map[string]bool)
+	efferent := make(map[string]map[string]bool)
+	depend := func(from, to string) {
+		dep, ok := graph.Package(to)
+		if from == to || !ok || dep.Dir == "" {
+			return
+		}
+		if afferent[to] == nil {
+			afferent[to] = make(map[string]bool)
+		}
+		if efferent[from] == nil {
+			efferent[from] = make(map[string]bool)
+		}
+		afferent[to][from] = true
+		efferent[from][to] = true
+	}
+
+	packages := graph.Sorted()
+	for _, pkg := range packages {
+		if pkg.Dir == "" {
+			continue
+		}
+		for _, dep := range pkg.Imports {
+			depend(pkg.ImportPath, dep)
+		}
+		for _, dep := range references[pkg.ImportPath] {
+			depend(pkg.ImportPath, dep)
+		}
+	}
+
+	metrics := make([]PackageMetrics, 0)
+	for _, pkg := range packages {
+		if pkg.Dir == "" {
+			continue
+		}
+
+		m := PackageMetrics{
+			Package:    pkg.ImportPath,
+			Dir:        pkg.Dir,
+			Ca:         len(afferent[pkg.ImportPath]),
+			Ce:         len(efferent[pkg.ImportPath]),
+			Interfaces: counts[pkg.ImportPath].Interfaces,
+			Concrete:   counts[pkg.ImportPath].Concrete,
+		}
+		if m.Ca+m.Ce > 0 {
+			m.Instability = float64(m.Ce) / float64(m.Ca+m.Ce)
+		}
+		if m.Interfaces+m.Concrete > 0 {
+			m.Abstractness = float64(m.Interfaces) / float64(m.Interfaces+m.Concrete)
+		}
+		m.Distance = math.Abs(m.Abstractness + m.Instability - 1)
+		metrics = append(metrics, m)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Package < metrics[j].Package
+	})
+	return metrics
+}
+
+// MetricThreshold fails a package when its metric compares true against
+// the limit, e.g. "D>0.7" or "Ce>=10".
+type MetricThreshold struct {
+	Metric string
+	Op     string
+	Limit  float64
+}
+
+func (mt MetricThreshold) String() string {
+	return fmt.Sprintf("%s%s%s", mt.Metric, mt.Op, strconv.FormatFloat(mt.Limit, 'f', -1, 64))
+}
+
+func (mt MetricThreshold) Breached(value float64) bool {
+	switch mt.Op {
+	case ">":
+		return value > mt.Limit
+	case ">=":
+		return value >= mt.Limit
+	case "<":
+		return value < mt.Limit
+	case "<=":
+		return value <= mt.Limit
+	}
+	return false
+}
+
+func ParseMetricThresholds(spec string) ([]MetricThreshold, error) {
+	thresholds := make([]MetricThreshold, 0)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		idx := strings.IndexAny(part, "<>")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid threshold %q (want e.g. D>0.7)", part)
+		}
+		op := part[idx : idx+1]
+		if idx+1 < len(part) && part[idx+1] == '=' {
+			op += "="
+		}
+
+		threshold := MetricThreshold{Metric: strings.TrimSpace(part[:idx]), Op: op}
+		if _, ok := (PackageMetrics{}).Value(threshold.Metric); !ok {
+			return nil, fmt.Errorf("invalid threshold %q: unknown metric %s (want Ca, Ce, I, A or D)", part, threshold.Metric)
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSpace(part[idx+len(op):]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %v", part, err)
+		}
+		threshold.Limit = limit
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+type MetricBreach struct {
+	Package   string  `json:"package"`
+	Threshold string  `json:"threshold"`
+	Value     float64 `json:"value"`
+}
+
+func CheckMetricThresholds(metrics []PackageMetrics, thresholds []MetricThreshold) []MetricBreach {
+	breaches := make([]MetricBreach, 0)
+	for _, m := range metrics {
+		for _, threshold := range thresholds {
+			value, _ := m.Value(threshold.Metric)
+			if threshold.Breached(value) {
+				breaches = append(breaches, MetricBreach{Package: m.Package, Threshold: threshold.String(), Value: value})
+			}
+		}
+	}
+	return breaches
+}
+
+func WritePackageMetrics(w io.Writer, metrics []PackageMetrics, breaches []MetricBreach, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(struct {
+			Packages []PackageMetrics `json:"packages"`
+			Breaches []MetricBreach   `json:"breaches"`
+		}{metrics, breaches})
+	case "text", "":
+		fmt.Fprintln(w, "\n--- Package Metrics ---")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Package\tCa\tCe\tI\tA\tD")
+		for _, m := range metrics {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\n", m.Package, m.Ca, m.Ce, m.Instability, m.Abstractness, m.Distance)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if len(breaches) > 0 {
+			fmt.Fprintln(w, "\n--- Metric Threshold Breaches ---")
+			for _, breach := range breaches {
+				fmt.Fprintf(w, "%s: %s (value %.2f)\n", breach.Package, breach.Threshold, breach.Value)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestComputePackageMetrics(t *testing.T) {
+	graph := &ImportGraph{packages: map[string]*GoPackage{
+		"m/app":          {ImportPath: "m/app", Dir: "app", Imports: []string{"m/domain", "m/infra", "fmt", "github.com/x/y"}},
+		"m/domain":       {ImportPath: "m/domain", Dir: "domain", Imports: []string{"errors"}},
+		"m/infra":        {ImportPath: "m/infra", Dir: "infra", Imports: []string{"m/domain", "database/sql"}},
+		"m/util":         {ImportPath: "m/util", Dir: "util"},
+		"fmt":            {ImportPath: "fmt", Kind: PackageKindStdlib},
+		"errors":         {ImportPath: "errors", Kind: PackageKindStdlib},
+		"database/sql":   {ImportPath: "database/sql", Kind: PackageKindStdlib},
+		"github.com/x/y": {ImportPath: "github.com/x/y", Kind: PackageKindThirdParty},
+	}}
+	counts := map[string]DeclarationCounts{
+		"m/app":    {Concrete: 2},
+		"m/domain": {Interfaces: 3, Concrete: 1},
+		"m/infra":  {Interfaces: 1, Concrete: 3},
+	}
+
+	tests := []struct {
+		name       string
+		references map[string][]string
+		want       map[string][5]float64
+	}{
+		{
+			name: "imports of module packages only",
+			want: map[string][5]float64{
+				"m/app":    {0, 2, 1, 0, 0},
+				"m/domain": {2, 0, 0, 0.75, 0.25},
+				"m/infra":  {1, 1, 0.5, 0.25, 0.25},
+				"m/util":   {0, 0, 0, 0, 1},
+			},
+		},
+		{
+			name:       "type references add dependencies",
+			references: map[string][]string{"m/util": {"m/domain", "m/util"}, "m/app": {"m/domain", "fmt"}},
+			want: map[string][5]float64{
+				"m/app":    {0, 2, 1, 0, 0},
+				"m/domain": {3, 0, 0, 0.75, 0.25},
+				"m/infra":  {1, 1, 0.5, 0.25, 0.25},
+				"m/util":   {0, 1, 1, 0, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := ComputePackageMetrics(graph, counts, tt.references)
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("ComputePackageMetrics() returned %d packages, want %d", len(metrics), len(tt.want))
+			}
+			for _, m := range metrics {
+				want := tt.want[m.Package]
+				got := [5]float64{float64(m.Ca), float64(m.Ce), m.Instability, m.Abstractness, m.Distance}
+				for i := range got {
+					if math.Abs(got[i]-want[i]) > 1e-9 {
+						t.Errorf("%s: Ca, Ce, I, A, D = %v, want %v", m.Package, got, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCountDeclarations(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod":         "module example.com/m\n",
+		"store/store.go": "package store\n\ntype Store interface{ Get() }\ntype Reader interface{ Read() }\ntype ID string\ntype Cache struct{}\ntype _ int\n\nfunc New() Store { return nil }\nfunc (Cache) Get() {}\n",
+		"store/ids.go":   "package store\n\ntype (\n\tIDs []ID\n\tMatcher func(ID) bool\n)\n\nvar Default = New()\n",
+		"app/app.go":     "package app\n\nimport \"example.com/m/store\"\n\nfunc Run(store.Store) {}\n",
+	})
+
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := BuildImportGraph([]string{root}, AnalysisOptions{}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]DeclarationCounts{
+		"example.com/m/store": {Interfaces: 2, Concrete: 4},
+		"example.com/m/app":   {}, // functions are not types
+	}
+	if got := CountDeclarations(graph, AnalysisOptions{}, resolver); !reflect.DeepEqual(got, want) {
+		t.Errorf("CountDeclarations() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GoModule is a module read from go.mod. Replaces only holds replacements
+// by a local directory; version replacements don't change where packages
+// live for astro.
+type GoModule struct {
+	Path     string
+	Dir      string
+	Replaces map[string]string
+}
+
+// Workspace is the set of modules astro resolves import paths against: the
+// modules of go.work, or the modules whose go.mod encloses the analyzed
+// directories.
+type Workspace struct {
+	Root     string
+	Modules  []GoModule
+	Replaces map[string]string
+}
+
+// modFileDirectives splits a go.mod or go.work file into directives, so
+// both "replace a => b" and the block form yield ["replace", "a", "=>", "b"].
+func modFileDirectives(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	directives := make([][]string, 0)
+	block := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case block != "" && fields[0] == ")":
+			block = ""
+			continue
+		case block != "":
+			fields = append([]string{block}, fields...)
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		}
+
+		for i, field := range fields {
+			if strings.HasPrefix(field, `"`) || strings.HasPrefix(field, "`") {
+				unquoted, err := strconv.Unquote(field)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid quoted string %s", path, lineNo, field)
+				}
+				fields[i] = unquoted
+			}
+		}
+		directives = append(directives, fields)
+	}
+	return directives, scanner.Err()
+}
+
+// parseReplace returns the module path and local directory of a replace
+// directive, or ok=false when it replaces with another module version.
+func parseReplace(fields []string, baseDir string) (string, string, bool) {
+	arrow := -1
+	for i, field := range fields {
+		if field == "=>" {
+			arrow = i
+		}
+	}
+	if arrow < 2 || arrow+1 >= len(fields) {
+		return "", "", false
+	}
+
+	target := fields[arrow+1]
+	if !filepath.IsAbs(target) && !strings.HasPrefix(target, "./") && !strings.HasPrefix(target, "../") {
+		return "", "", false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(baseDir, target)
+	}
+	return fields[1], filepath.Clean(target), true
+}
+
+func ParseGoMod(path string) (GoModule, error) {
+	directives, err := modFileDirectives(path)
+	if err != nil {
+		return GoModule{}, err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return GoModule{}, err
+	}
+
+	module := GoModule{Dir: dir, Replaces: make(map[string]string)}
+	for _, fields := range directives {
+		switch fields[0] {
+		case "module":
+			if len(fields) < 2 {
+				return GoModule{}, fmt.Errorf("%s: module directive without a path", path)
+			}
+			module.Path = fields[1]
+		case "replace":
+			if from, to, ok := parseReplace(fields, dir); ok {
+				module.Replaces[from] = to
+			}
+		}
+	}
+
+	if module.Path == "" {
+		return GoModule{}, fmt.Errorf("%s: no module directive", path)
+	}
+	return module, nil
+}
+
+// findUp returns the first file with the given name in dir or one of its
+// parents.
+func findUp(dir, name string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// LoadWorkspace finds go.work (honoring GOWORK) or go.mod starting at dir.
+// It returns nil without an error when dir is not inside a module.
+func LoadWorkspace(dir string) (*Workspace, error) {
+	workFile := os.Getenv("GOWORK")
+	switch workFile {
+	case "off":
+		workFile = ""
+	case "":
+		workFile, _ = findUp(dir, "go.work")
+	}
+	if workFile != "" {
+		return loadGoWork(workFile)
+	}
+
+	modFile, ok := findUp(dir, "go.mod")
+	if !ok {
+		return nil, nil
+	}
+	module, err := ParseGoMod(modFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{Root: module.Dir, Modules: []GoModule{module}, Replaces: module.Replaces}, nil
+}
+
+// NewPackageResolver resolves against the modules enclosing roots, so the
+// directories of another module resolve against its own go.mod rather than
+// the one of the working directory. Outside of any module packages are
+// named by their directories.
+func NewPackageResolver(roots []string) (PackageResolver, error) {
+	var merged *Workspace
+	for _, root := range roots {
+		workspace, err := LoadWorkspace(root)
+		if err != nil {
+			return nil, err
+		}
+		if workspace == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Workspace{Root: workspace.Root, Replaces: make(map[string]string)}
+		}
+		merged.merge(workspace)
+	}
+
+	if merged == nil {
+		return NewSuffixPackageResolver(), nil
+	}
+	return NewModuleResolver(merged), nil
+}
+
+// merge adds the modules of other that w doesn't have yet. Replaces
+// already in w win.
+func (w *Workspace) merge(other *Workspace) {
+	for _, module := range other.Modules {
+		known := false
+		for _, existing := range w.Modules {
+			known = known || existing.Dir == module.Dir
+		}
+		if !known {
+			w.Modules = append(w.Modules, module)
+		}
+	}
+	for from, to := range other.Replaces {
+		if _, ok := w.Replaces[from]; !ok {
+			w.Replaces[from] = to
+		}
+	}
+}
+
+// workspaceRoots returns the directories modules are looked up from: the
+// analyzed directories, or the base directories of local package patterns.
+// Import path patterns are resolved from the working directory.
+func workspaceRoots(dirs, patterns []string) []string {
+	if len(patterns) == 0 {
+		return dirs
+	}
+	roots := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		base, _ := splitPattern(pattern)
+		if !isLocalPattern(base) {
+			base = "."
+		}
+		roots = appendUnique(roots, base)
+	}
+	return roots
+}
+
+func loadGoWork(path string) (*Workspace, error) {
+	directives, err := modFileDirectives(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := &Workspace{Root: root, Replaces: make(map[string]string)}
+	workReplaces := make(map[string]string)
+	for _, fields := range directives {
+		switch fields[0] {
+		case "use":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s: use directive without a directory", path)
+			}
+			module, err := ParseGoMod(filepath.Join(root, fields[1], "go.mod"))
+			if err != nil {
+				return nil, err
+			}
+			workspace.Modules = append(workspace.Modules, module)
+		case "replace":
+			if from, to, ok := parseReplace(fields, root); ok {
+				workReplaces[from] = to
+			}
+		}
+	}
+
+	// Replaces in go.work take precedence over those of the used modules
+	for _, module := range workspace.Modules {
+		for from, to := range module.Replaces {
+			workspace.Replaces[from] = to
+		}
+	}
+	for from, to := range workReplaces {
+		workspace.Replaces[from] = to
+	}
+	return workspace, nil
+}
+
+// ModuleResolver names packages by their import path within the workspace
+// modules and maps import paths back to directories. Modules replaced by a
+// local directory are resolved to that directory but are not internal.
+type ModuleResolver struct {
+	workspace *Workspace
+	roots     []GoModule
+	fallback  *SuffixPackageResolver
+}
+
+func NewModuleResolver(workspace *Workspace) *ModuleResolver {
+	roots := make([]GoModule, 0, len(workspace.Modules)+len(workspace.Replaces))
+	roots = append(roots, workspace.Modules...)
+	for from, to := range workspace.Replaces {
+		roots = append(roots, GoModule{Path: from, Dir: to})
+	}
+	// Deepest directories first, so nested modules win over their parents
+	sort.Slice(roots, func(i, j int) bool {
+		if len(roots[i].Dir) != len(roots[j].Dir) {
+			return len(roots[i].Dir) > len(roots[j].Dir)
+		}
+		return roots[i].Path < roots[j].Path
+	})
+
+	return &ModuleResolver{
+		workspace: workspace,
+		roots:     roots,
+		fallback:  NewSuffixPackageResolver(),
+	}
+}
+
+func (mr *ModuleResolver) ImportPath(dir, pkgName string) string {
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		for _, module := range mr.roots {
+			if rel, ok := relativeTo(module.Dir, abs); ok {
+				return joinImportPath(module.Path, rel)
+			}
+		}
+	}
+	return mr.fallback.ImportPath(dir, pkgName)
+}
+
+func (mr *ModuleResolver) Modules() []GoModule {
+	return mr.workspace.Modules
+}
+
+// Resolve reports whether importPath belongs to one of the workspace
+// modules, falling back to suffix matching for directories outside them.
+func (mr *ModuleResolver) Resolve(importPath string) (string, bool) {
+	for _, module := range mr.workspace.Modules {
+		if importPath == module.Path || strings.HasPrefix(importPath, module.Path+"/") {
+			return importPath, true
+		}
+	}
+	return mr.fallback.Resolve(importPath)
+}
+
+// Dir maps an import path to its local directory.
+func (mr *ModuleResolver) Dir(importPath string) (string, bool) {
+	best := GoModule{}
+	for _, module := range mr.roots {
+		if importPath == module.Path || strings.HasPrefix(importPath, module.Path+"/") {
+			if len(module.Path) > len(best.Path) {
+				best = module
+			}
+		}
+	}
+	if best.Path == "" {
+		return mr.fallback.Dir(importPath)
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(importPath, best.Path), "/")
+	return workingDirRelative(filepath.Join(best.Dir, filepath.FromSlash(rel))), true
+}
+
+// workingDirRelative shortens dir to a path relative to the working
+// directory when it is inside it.
+func workingDirRelative(dir string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return dir
+	}
+	if rel, ok := relativeTo(wd, dir); ok {
+		return filepath.FromSlash(rel)
+	}
+	return dir
+}
+
+func relativeTo(base, path string) (string, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func joinImportPath(modulePath, rel string) string {
+	if rel == "." || rel == "" {
+		return modulePath
+	}
+	return modulePath + "/" + rel
+}
+
+// expandPatterns turns package patterns into package directories. A pattern
+// is a directory ("./pkg/store") or an import path, optionally ending in
+// "/..." to include every package below it. Like the go command, "..."
+// skips testdata, vendor, directories starting with "." or "_", and nested
+// modules.
+func expandPatterns(patterns []string, resolver PackageResolver) ([]string, error) {
+	dirs := make([]string, 0)
+	for _, pattern := range patterns {
+		base, recursive := splitPattern(pattern)
+
+		dir := base
+		if !isLocalPattern(base) {
+			resolved, ok := resolver.Dir(base)
+			if !ok {
+				return nil, fmt.Errorf("cannot find package directory for %s", pattern)
+			}
+			dir = resolved
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s: %v", pattern, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("pattern %s: %s is not a directory", pattern, dir)
+		}
+
+		if !recursive {
+			dirs = appendUnique(dirs, filepath.Clean(dir))
+			continue
+		}
+
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if path != dir {
+				name := info.Name()
+				if name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			if hasGoFiles(path) {
+				dirs = appendUnique(dirs, filepath.Clean(path))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// splitPattern returns the directory or import path a pattern starts
+// from and whether it ends in "/...".
+func splitPattern(pattern string) (string, bool) {
+	recursive := pattern == "..." || strings.HasSuffix(pattern, "/...")
+	base := strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+	if base == "" {
+		base = "."
+	}
+	return base, recursive
+}
+
+func isLocalPattern(pattern string) bool {
+	return pattern == "." || pattern == ".." || filepath.IsAbs(pattern) ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")
+}
+
+func hasGoFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
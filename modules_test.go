@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoMod(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		path     string
+		replaces map[string]string // module path to directory relative to the module
+		err      bool
+	}{
+		{
+			name:     "module only",
+			content:  "module example.com/app\n\ngo 1.21\n",
+			path:     "example.com/app",
+			replaces: map[string]string{},
+		},
+		{
+			name:     "quoted path and comments",
+			content:  "// Deprecated: use example.com/v2\nmodule \"example.com/app\" // the app\n\nrequire example.com/dep v1.0.0\n",
+			path:     "example.com/app",
+			replaces: map[string]string{},
+		},
+		{
+			name:     "replace directive",
+			content:  "module example.com/app\n\nreplace example.com/dep => ../dep\n",
+			path:     "example.com/app",
+			replaces: map[string]string{"example.com/dep": "../dep"},
+		},
+		{
+			name: "replace block",
+			content: "module example.com/app\n\nreplace (\n" +
+				"\texample.com/fork v1.0.0 => ./third_party/fork\n" +
+				"\texample.com/pinned => example.com/pinned v1.2.0 // a version, not a directory\n" +
+				"\t\"example.com/quoted\" => \"./quoted\"\n" +
+				")\n",
+			path:     "example.com/app",
+			replaces: map[string]string{"example.com/fork": "third_party/fork", "example.com/quoted": "quoted"},
+		},
+		{
+			name:    "no module directive",
+			content: "go 1.21\n",
+			err:     true,
+		},
+		{
+			name:    "module without a path",
+			content: "module\n",
+			err:     true,
+		},
+		{
+			name:    "invalid quoted string",
+			content: "module \"example.com/app\n",
+			err:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTestModule(t, map[string]string{"go.mod": tt.content})
+			module, err := ParseGoMod(filepath.Join(root, "go.mod"))
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParseGoMod() = %+v, want an error", module)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			replaces := make(map[string]string)
+			for from, to := range tt.replaces {
+				replaces[from] = filepath.Join(root, filepath.FromSlash(to))
+			}
+			if module.Path != tt.path || module.Dir != root || !reflect.DeepEqual(module.Replaces, replaces) {
+				t.Errorf("ParseGoMod() = %+v, want path %s in %s replacing %v", module, tt.path, root, replaces)
+			}
+		})
+	}
+}
+
+func TestNewPackageResolver(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		roots       []string
+		importPaths map[string]string // directory to import path
+		dirs        map[string]string // import path to directory, "" if not found
+		internal    map[string]bool
+	}{
+		{
+			name:        "single module",
+			files:       map[string]string{"go.mod": "module example.com/app\n"},
+			roots:       []string{"."},
+			importPaths: map[string]string{".": "example.com/app", "pkg/store": "example.com/app/pkg/store"},
+			dirs:        map[string]string{"example.com/app/pkg/store": "pkg/store", "example.com/other": ""},
+			internal:    map[string]bool{"example.com/app/pkg": true, "example.com/application": false, "fmt": false},
+		},
+		{
+			name: "nested module",
+			files: map[string]string{
+				"go.mod":       "module example.com/outer\n",
+				"inner/go.mod": "module example.com/inner\n",
+			},
+			roots:       []string{".", "inner"},
+			importPaths: map[string]string{"lib": "example.com/outer/lib", "inner": "example.com/inner", "inner/store": "example.com/inner/store"},
+			dirs:        map[string]string{"example.com/inner/store": "inner/store", "example.com/outer/inner": "inner"},
+			internal:    map[string]bool{"example.com/inner/store": true, "example.com/outer/lib": true},
+		},
+		{
+			name: "modules located per root",
+			files: map[string]string{
+				"svc/go.mod": "module example.com/svc\n",
+				"lib/go.mod": "module example.com/lib\n\nreplace example.com/shared => ../shared\n",
+			},
+			roots:       []string{"svc", "lib"},
+			importPaths: map[string]string{"svc/api": "example.com/svc/api", "lib/util": "example.com/lib/util", "shared/x": "example.com/shared/x"},
+			dirs:        map[string]string{"example.com/svc/api": "svc/api", "example.com/lib": "lib", "example.com/shared/x": "shared/x"},
+			internal:    map[string]bool{"example.com/svc/api": true, "example.com/lib/util": true, "example.com/shared/x": false},
+		},
+		{
+			name: "workspace",
+			files: map[string]string{
+				"go.work":       "go 1.21\n\nuse (\n\t./api\n\t./tools // generators\n)\n\nuse ./extra\n\nreplace example.com/dep => ./dep\n",
+				"api/go.mod":    "module example.com/api\n\nreplace example.com/dep => ../dep-old\n",
+				"tools/go.mod":  "module example.com/tools\n",
+				"extra/go.mod":  "module example.com/extra\n",
+				"unused/go.mod": "module example.com/unused\n",
+			},
+			roots:       []string{"api"},
+			importPaths: map[string]string{"api/v1": "example.com/api/v1", "tools/gen": "example.com/tools/gen", "extra": "example.com/extra"},
+			dirs:        map[string]string{"example.com/tools/gen": "tools/gen", "example.com/dep/x": "dep/x", "example.com/unused": ""},
+			internal:    map[string]bool{"example.com/api": true, "example.com/extra/x": true, "example.com/dep": false, "example.com/unused": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTestModule(t, tt.files)
+			roots := make([]string, 0, len(tt.roots))
+			for _, dir := range tt.roots {
+				roots = append(roots, filepath.Join(root, dir))
+			}
+			resolver, err := NewPackageResolver(roots)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for dir, want := range tt.importPaths {
+				if got := resolver.ImportPath(filepath.Join(root, filepath.FromSlash(dir)), filepath.Base(dir)); got != want {
+					t.Errorf("ImportPath(%s) = %s, want %s", dir, got, want)
+				}
+			}
+			for importPath, want := range tt.dirs {
+				got, ok := resolver.Dir(importPath)
+				if want == "" {
+					if ok {
+						t.Errorf("Dir(%s) = %s, want none", importPath, got)
+					}
+					continue
+				}
+				if want = filepath.Join(root, filepath.FromSlash(want)); !ok || got != want {
+					t.Errorf("Dir(%s) = %s, %v, want %s", importPath, got, ok, want)
+				}
+			}
+			for importPath, want := range tt.internal {
+				if _, got := resolver.Resolve(importPath); got != want {
+					t.Errorf("Resolve(%s) internal = %v, want %v", importPath, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPackageResolverErrors(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "invalid go.mod", files: map[string]string{"go.mod": "go 1.21\n"}},
+		{name: "used directory without go.mod", files: map[string]string{"go.work": "go 1.21\n\nuse ./missing\n"}},
+		{name: "use without a directory", files: map[string]string{"go.work": "use\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTestModule(t, tt.files)
+			if _, err := NewPackageResolver([]string{root}); err == nil {
+				t.Error("NewPackageResolver() succeeded")
+			}
+		})
+	}
+
+	// Outside of any module directories name packages
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "store"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resolver.(*SuffixPackageResolver); !ok {
+		t.Errorf("NewPackageResolver() outside a module = %T, want *SuffixPackageResolver", resolver)
+	}
+}
+
+func TestExpandPatterns(t *testing.T) {
+	t.Setenv("GOWORK", "")
+	root := writeTestModule(t, map[string]string{
+		"go.mod":                    "module example.com/app\n",
+		"main.go":                   "package main\n",
+		"store/store.go":            "package store\n",
+		"store/sql/sql.go":          "package sql\n",
+		"store/docs/README":         "docs\n",
+		"store/testdata/x.go":       "package x\n",
+		"store/_old/old.go":         "package old\n",
+		"store/nested/go.mod":       "module example.com/nested\n",
+		"store/nested/nest.go":      "package nested\n",
+		"vendor/example.com/v/v.go": "package v\n",
+	})
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		patterns []string
+		want     []string
+		err      bool
+	}{
+		{patterns: []string{root + "/..."}, want: []string{".", "store", "store/sql"}},
+		{patterns: []string{root + "/store"}, want: []string{"store"}},
+		{patterns: []string{"example.com/app/store/..."}, want: []string{"store", "store/sql"}},
+		{patterns: []string{"example.com/app/store/sql", root + "/store/sql"}, want: []string{"store/sql"}},
+		{patterns: []string{"example.com/other/..."}, err: true},
+		{patterns: []string{root + "/missing"}, err: true},
+		{patterns: []string{root + "/main.go"}, err: true},
+	}
+	for _, tt := range tests {
+		dirs, err := expandPatterns(tt.patterns, resolver)
+		if tt.err {
+			if err == nil {
+				t.Errorf("expandPatterns(%v) = %v, want an error", tt.patterns, dirs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandPatterns(%v): %v", tt.patterns, err)
+			continue
+		}
+		want := make([]string, 0, len(tt.want))
+		for _, dir := range tt.want {
+			want = append(want, filepath.Join(root, filepath.FromSlash(dir)))
+		}
+		if !reflect.DeepEqual(dirs, want) {
+			t.Errorf("expandPatterns(%v) = %v, want %v", tt.patterns, dirs, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// NewBuildContext returns the go/build context files are selected with:
+// build.Default for goos and goarch with the extra tags. Cgo follows
+// CGO_ENABLED when set and is otherwise only enabled for the host, like
+// the go command does.
+func NewBuildContext(goos, goarch string, tags []string) *build.Context {
+	ctxt := build.Default
+	ctxt.GOOS = goos
+	ctxt.GOARCH = goarch
+	ctxt.BuildTags = append([]string(nil), tags...)
+	switch os.Getenv("CGO_ENABLED") {
+	case "0":
+		ctxt.CgoEnabled = false
+	case "1":
+		ctxt.CgoEnabled = true
+	default:
+		ctxt.CgoEnabled = build.Default.CgoEnabled && goos == runtime.GOOS && goarch == runtime.GOARCH
+	}
+	return &ctxt
+}
+
+// parseBuildTags splits a -tags value, comma or space separated like the
+// go command accepts.
+func parseBuildTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// parsePlatforms splits a comma-separated list of goos/goarch pairs.
+func parsePlatforms(value string) ([][2]string, error) {
+	platforms := make([][2]string, 0)
+	for _, platform := range strings.Split(value, ",") {
+		if platform = strings.TrimSpace(platform); platform == "" {
+			continue
+		}
+		goos, goarch, ok := strings.Cut(platform, "/")
+		if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+			return nil, fmt.Errorf("invalid platform %q (want goos/goarch)", platform)
+		}
+		platforms = append(platforms, [2]string{goos, goarch})
+	}
+	if len(platforms) < 2 {
+		return nil, fmt.Errorf("at least two platforms are needed to compare")
+	}
+	return platforms, nil
+}
+
+// PlatformDeclaration is a declaration that exists on some of the compared
+// platforms only.
+type PlatformDeclaration struct {
+	Package   string   `json:"package"`
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Signature string   `json:"signature,omitempty"`
+	Platforms []string `json:"platforms"`
+}
+
+type PlatformReport struct {
+	Platforms    []string              `json:"platforms"`
+	Declarations []PlatformDeclaration `json:"declarations"`
+}
+
+// ComparePlatforms analyzes dirs once per goos/goarch pair and reports the
+// declarations missing from at least one of them.
+func ComparePlatforms(ctx context.Context, dirs []string, base AnalysisOptions, resolver PackageResolver, platforms [][2]string, tags []string) (PlatformReport, error) {
+	report := PlatformReport{Platforms: make([]string, 0, len(platforms)), Declarations: make([]PlatformDeclaration, 0)}
+	found := make(map[string]*PlatformDeclaration)
+
+	for _, platform := range platforms {
+		name := platform[0] + "/" + platform[1]
+		report.Platforms = append(report.Platforms, name)
+
+		opts := base
+		opts.Build = NewBuildContext(platform[0], platform[1], tags)
+		opts.SelectedTypes = map[string]bool{
+			"structs":    true,
+			"interfaces": true,
+			"functions":  true,
+			"variables":  true,
+			"constants":  true,
+			"types":      true,
+		}
+		opts.GenNoOp = false
+		opts.FunctionFilter = nil
+		opts.Changes = nil
+		opts.StructSources = NewSourceItemsCollector[GoStruct]()
+		opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+		opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+		opts.VariableSources = NewSourceItemsCollector[GoVariable]()
+		opts.ConstantSources = NewSourceItemsCollector[GoConstant]()
+		opts.TypeSources = NewSourceItemsCollector[GoType]()
+		opts.Imports = nil
+		opts.Output = io.Discard
+
+		for _, dir := range dirs {
+			if err := walkDirectory(ctx, dir, opts); err != nil {
+				return report, fmt.Errorf("analyzing %s for %s: %v", dir, name, err)
+			}
+		}
+
+		for _, entry := range buildDeclarationSnapshot(opts, resolver, false).Entries {
+			decl, ok := found[entry.key()]
+			if !ok {
+				decl = &PlatformDeclaration{Package: entry.Package, Kind: entry.Kind, Name: entry.Name, Signature: entry.Signature}
+				found[entry.key()] = decl
+			}
+			if len(decl.Platforms) == 0 || decl.Platforms[len(decl.Platforms)-1] != name {
+				decl.Platforms = append(decl.Platforms, name)
+			}
+		}
+	}
+
+	for _, decl := range found {
+		if len(decl.Platforms) < len(platforms) {
+			report.Declarations = append(report.Declarations, *decl)
+		}
+	}
+	sort.Slice(report.Declarations, func(i, j int) bool {
+		a, b := report.Declarations[i], report.Declarations[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+func WritePlatformReport(w io.Writer, report PlatformReport, format string) error {
+	switch format {
+	case "json":
+		return newJSONEncoder(w).Encode(report)
+	case "text", "":
+		fmt.Fprintf(w, "\n--- Platform-Specific Declarations (%s) ---\n", strings.Join(report.Platforms, ", "))
+		pkg := ""
+		for _, decl := range report.Declarations {
+			if decl.Package != pkg {
+				pkg = decl.Package
+				fmt.Fprintf(w, "%s\n", pkg)
+			}
+			fmt.Fprintf(w, "  %s: only %s\n", describeAPIEntry(decl.Kind, decl.Name, decl.Signature), strings.Join(decl.Platforms, ", "))
+		}
+		fmt.Fprintf(w, "%d declaration(s) missing on some platforms\n", len(report.Declarations))
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+}
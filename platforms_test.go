@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// platformModule has files selected by file name suffixes, build
+// constraints and tags.
+var platformModule = map[string]string{
+	"go.mod":                   "module example.com/p\n",
+	"sys/sys.go":               "package sys\n\nfunc Name() string { return \"\" }\n",
+	"sys/sys_linux.go":         "package sys\n\nfunc Epoll() {}\n\nconst PathSeparator = '/'\n",
+	"sys/sys_windows.go":       "package sys\n\nfunc IOCP() {}\n\nconst PathSeparator = '\\\\'\n",
+	"sys/sys_linux_arm64.go":   "package sys\n\nfunc Arm() {}\n",
+	"sys/unix.go":              "//go:build linux || darwin\n\npackage sys\n\ntype Fd int\n",
+	"sys/integration.go":       "//go:build integration\n\npackage sys\n\nfunc Fixture() {}\n",
+	"sys/not_integration.go":   "//go:build !integration\n\npackage sys\n\nfunc Stub() {}\n",
+	"sys/debug_linux.go":       "//go:build debug\n\npackage sys\n\nfunc Trace() {}\n",
+	"sys/cgo.go":               "//go:build cgo\n\npackage sys\n\nfunc Native() {}\n",
+	"sys/ignored.go":           "//go:build ignore\n\npackage main\n",
+	"sys/sys_linux_test.go":    "package sys\n",
+	"sys/legacy_plan9_386.go":  "package sys\n",
+	"sys/sys_darwin_arm64.go":  "package sys\n\nfunc Kqueue() {}\n",
+	"sys/sys_windows_amd64.go": "package sys\n",
+}
+
+func TestBuildContextSelection(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	root := writeTestModule(t, platformModule)
+
+	tests := []struct {
+		name   string
+		goos   string
+		goarch string
+		tags   string
+		want   []string
+	}{
+		{
+			name: "linux/amd64", goos: "linux", goarch: "amd64",
+			want: []string{"not_integration.go", "sys.go", "sys_linux.go", "unix.go"},
+		},
+		{
+			name: "linux/arm64", goos: "linux", goarch: "arm64",
+			want: []string{"not_integration.go", "sys.go", "sys_linux.go", "sys_linux_arm64.go", "unix.go"},
+		},
+		{
+			name: "windows/amd64", goos: "windows", goarch: "amd64",
+			want: []string{"not_integration.go", "sys.go", "sys_windows.go", "sys_windows_amd64.go"},
+		},
+		{
+			name: "darwin/arm64", goos: "darwin", goarch: "arm64",
+			want: []string{"not_integration.go", "sys.go", "sys_darwin_arm64.go", "unix.go"},
+		},
+		{
+			name: "tags", goos: "linux", goarch: "amd64", tags: "integration, debug",
+			want: []string{"debug_linux.go", "integration.go", "sys.go", "sys_linux.go", "unix.go"},
+		},
+		{
+			name: "tag for another platform", goos: "windows", goarch: "amd64", tags: "debug",
+			want: []string{"not_integration.go", "sys.go", "sys_windows.go", "sys_windows_amd64.go"},
+		},
+		{
+			name: "cgo tag", goos: "linux", goarch: "amd64", tags: "cgo",
+			want: []string{"cgo.go", "not_integration.go", "sys.go", "sys_linux.go", "unix.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through the flags, like a run selects files
+			cfg := &cliConfig{}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			addAnalyzeFlags(fs, cfg)
+			if err := fs.Parse([]string{"-cache-dir", "", "-goos", tt.goos, "-goarch", tt.goarch, "-tags", tt.tags}); err != nil {
+				t.Fatal(err)
+			}
+			run, err := newAnalysisRun(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := analyzableFiles(filepath.Join(root, "sys"), AnalysisOptions{Build: run.opts.Build})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(files))
+			for _, file := range files {
+				got = append(got, filepath.Base(file))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBuildContextCgo(t *testing.T) {
+	for _, tt := range []struct {
+		env  string
+		want bool
+	}{
+		{env: "0", want: false},
+		{env: "1", want: true},
+	} {
+		t.Setenv("CGO_ENABLED", tt.env)
+		if got := NewBuildContext("plan9", "386", nil).CgoEnabled; got != tt.want {
+			t.Errorf("CGO_ENABLED=%s: CgoEnabled = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+
+	// Without CGO_ENABLED, cgo is off when cross-compiling
+	os.Unsetenv("CGO_ENABLED")
+	if NewBuildContext("plan9", "386", nil).CgoEnabled {
+		t.Error("cgo enabled for another platform")
+	}
+}
+
+func TestComparePlatforms(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	root := writeTestModule(t, platformModule)
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	platforms, err := parsePlatforms("linux/amd64, windows/amd64,darwin/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ComparePlatforms(context.Background(), []string{root}, AnalysisOptions{}, resolver, platforms, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"linux/amd64", "windows/amd64", "darwin/arm64"}; !reflect.DeepEqual(report.Platforms, want) {
+		t.Errorf("Platforms = %v, want %v", report.Platforms, want)
+	}
+
+	got := make(map[string][]string)
+	for _, decl := range report.Declarations {
+		got[decl.Kind+" "+decl.Name] = decl.Platforms
+	}
+	want := map[string][]string{
+		"func Epoll":          {"linux/amd64"},
+		"func IOCP":           {"windows/amd64"},
+		"func Kqueue":         {"darwin/arm64"},
+		"type Fd":             {"linux/amd64", "darwin/arm64"},
+		"const PathSeparator": {"linux/amd64", "windows/amd64"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("declarations = %v, want %v", got, want)
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	for _, value := range []string{"linux/amd64", "linux/amd64,windows", "linux,windows/amd64", "linux/amd64,/arm64", "linux/amd64,linux/arm/v7"} {
+		if _, err := parsePlatforms(value); err == nil {
+			t.Errorf("parsePlatforms(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// orderedResult is the outcome of one unit of work; done is closed once
+// value and err are set.
+type orderedResult[R any] struct {
+	value R
+	err   error
+	done  chan struct{}
+}
+
+// runOrdered calls work for every index below n on at most jobs goroutines
+// and hands the results to merge in index order, so the merged output does
+// not depend on scheduling. The first error, in index order, stops the run;
+// so does cancelling ctx.
+func runOrdered[R any](ctx context.Context, n, jobs int, work func(context.Context, int) (R, error), merge func(R) error) error {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	if jobs > n {
+		jobs = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]orderedResult[R], n)
+	for i := range results {
+		results[i].done = make(chan struct{})
+	}
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < n; i++ {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				for ; i < n; i++ {
+					results[i].err = ctx.Err()
+					close(results[i].done)
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					results[i].err = err
+				} else {
+					results[i].value, results[i].err = work(ctx, i)
+				}
+				close(results[i].done)
+			}
+		}()
+	}
+
+	var err error
+	for i := range results {
+		<-results[i].done
+		// Results finished before a cancellation are not merged after it
+		if err = results[i].err; err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = merge(results[i].value)
+		}
+		if err != nil {
+			break
+		}
+		// Let the finished result be collected before the run ends
+		results[i] = orderedResult[R]{}
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// analyzableFiles lists the files under dir that are analyzed, in lexical
+// order.
+func analyzableFiles(dir string, opts AnalysisOptions) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (opts.NonRecursive || (opts.Files != nil && opts.Files.SkipsDir(path))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isAnalyzableFile(path, opts) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// forkFileOptions returns options for analyzing one file concurrently with
+// others: output is buffered and results go to collectors of its own,
+// which mergeFileOptions adds to the shared ones afterwards.
+func forkFileOptions(opts AnalysisOptions) (AnalysisOptions, *bytes.Buffer) {
+	fork := opts
+	var buf *bytes.Buffer
+	if opts.Output != io.Discard {
+		buf = new(bytes.Buffer)
+		fork.Output = buf
+	}
+	if opts.StructSources != nil {
+		fork.StructSources = NewSourceItemsCollector[GoStruct]()
+	}
+	if opts.InterfaceSources != nil {
+		fork.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	}
+	if opts.FunctionSources != nil {
+		fork.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	}
+	if opts.VariableSources != nil {
+		fork.VariableSources = NewSourceItemsCollector[GoVariable]()
+	}
+	if opts.ConstantSources != nil {
+		fork.ConstantSources = NewSourceItemsCollector[GoConstant]()
+	}
+	if opts.TypeSources != nil {
+		fork.TypeSources = NewSourceItemsCollector[GoType]()
+	}
+	if opts.Imports != nil {
+		fork.Imports = NewImportIndex()
+	}
+	return fork, buf
+}
+
+func mergeFileOptions(opts, fork AnalysisOptions, buf *bytes.Buffer) error {
+	if buf != nil {
+		if _, err := buf.WriteTo(opts.Output); err != nil {
+			return err
+		}
+	}
+	if opts.StructSources != nil {
+		opts.StructSources.Merge(fork.StructSources)
+	}
+	if opts.InterfaceSources != nil {
+		opts.InterfaceSources.Merge(fork.InterfaceSources)
+	}
+	if opts.FunctionSources != nil {
+		opts.FunctionSources.Merge(fork.FunctionSources)
+	}
+	if opts.VariableSources != nil {
+		opts.VariableSources.Merge(fork.VariableSources)
+	}
+	if opts.ConstantSources != nil {
+		opts.ConstantSources.Merge(fork.ConstantSources)
+	}
+	if opts.TypeSources != nil {
+		opts.TypeSources.Merge(fork.TypeSources)
+	}
+	if opts.Imports != nil {
+		opts.Imports.Merge(fork.Imports)
+	}
+	return nil
+}
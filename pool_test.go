@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunOrdered(t *testing.T) {
+	errWork := errors.New("work failed")
+	errMerge := errors.New("merge failed")
+
+	tests := []struct {
+		name      string
+		n, jobs   int
+		failWork  int // index whose work fails, -1 for none
+		failMerge int // index whose merge fails, -1 for none
+		cancelAt  int // index after whose merge the caller cancels, -1 for none
+		want      []int
+		wantErr   error
+	}{
+		{name: "sequential", n: 5, jobs: 1, failWork: -1, failMerge: -1, cancelAt: -1, want: []int{0, 1, 2, 3, 4}},
+		{name: "parallel", n: 20, jobs: 4, failWork: -1, failMerge: -1, cancelAt: -1, want: seq(20)},
+		{name: "more jobs than work", n: 3, jobs: 8, failWork: -1, failMerge: -1, cancelAt: -1, want: []int{0, 1, 2}},
+		{name: "default jobs", n: 6, jobs: 0, failWork: -1, failMerge: -1, cancelAt: -1, want: seq(6)},
+		{name: "no work", n: 0, jobs: 4, failWork: -1, failMerge: -1, cancelAt: -1, want: []int{}},
+		{name: "work error", n: 10, jobs: 4, failWork: 3, failMerge: -1, cancelAt: -1, want: []int{0, 1, 2}, wantErr: errWork},
+		{name: "merge error", n: 10, jobs: 4, failWork: -1, failMerge: 5, cancelAt: -1, want: []int{0, 1, 2, 3, 4}, wantErr: errMerge},
+		{name: "cancelled", n: 50, jobs: 2, failWork: -1, failMerge: -1, cancelAt: 2, want: []int{0, 1, 2}, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			merged := make([]int, 0)
+			err := runOrdered(ctx, tt.n, tt.jobs,
+				func(ctx context.Context, i int) (int, error) {
+					// Later indexes finish first
+					time.Sleep(time.Duration(tt.n-i) * 100 * time.Microsecond)
+					if i == tt.failWork {
+						return 0, errWork
+					}
+					return i, nil
+				},
+				func(i int) error {
+					if i == tt.failMerge {
+						return errMerge
+					}
+					merged = append(merged, i)
+					if i == tt.cancelAt {
+						cancel()
+					}
+					return nil
+				},
+			)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("runOrdered() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(merged, tt.want) {
+				t.Errorf("merged %v, want %v", merged, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOrderedStopsWork(t *testing.T) {
+	var started, running, peak atomic.Int32
+	err := runOrdered(context.Background(), 100, 3,
+		func(ctx context.Context, i int) (int, error) {
+			started.Add(1)
+			if current := running.Add(1); current > peak.Load() {
+				peak.Store(current)
+			}
+			defer running.Add(-1)
+			if i == 0 {
+				return 0, errors.New("first fails")
+			}
+			time.Sleep(time.Millisecond)
+			return i, nil
+		},
+		func(int) error { return nil },
+	)
+
+	if err == nil {
+		t.Fatal("runOrdered() succeeded, want the error of the first item")
+	}
+	if n := started.Load(); n >= 100 {
+		t.Errorf("work started for %d items after the first failed, want fewer", n)
+	}
+	if n := peak.Load(); n > 3 {
+		t.Errorf("%d items ran at once, want at most 3", n)
+	}
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
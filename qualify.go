@@ -0,0 +1,157 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeQualifier rewrites the types of items generated into another
+// directory than their sources: identifiers declared in the source package
+// get its name as qualifier, so the output refers to them through an
+// import of the package. Unexported names can't be referred to that way.
+type TypeQualifier struct {
+	Name       string
+	ImportPath string
+}
+
+// ImportSpec is the import of the source package, aliased when its name
+// differs from the last element of its path.
+func (tq TypeQualifier) ImportSpec() string {
+	spec := strconv.Quote(tq.ImportPath)
+	if importLocalName(GoImport{Path: spec}) != tq.Name {
+		return tq.Name + " " + spec
+	}
+	return spec
+}
+
+// Type qualifies the identifiers of typ that aren't predeclared or one of
+// typeParams. typ is returned unchanged if it doesn't parse.
+func (tq TypeQualifier) Type(typ string, typeParams map[string]bool) string {
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", typ, 0)
+	if err != nil {
+		return typ
+	}
+
+	offsets := make([]int, 0)
+	var visit func(node ast.Node) bool
+	visit = func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.SelectorExpr:
+			// Already qualified
+			return false
+		case *ast.Field:
+			// Parameter, field and method names stay as they are
+			if n.Type != nil {
+				ast.Inspect(n.Type, visit)
+			}
+			return false
+		case *ast.Ident:
+			if n.Name != "_" && !typeParams[n.Name] && types.Universe.Lookup(n.Name) == nil {
+				offsets = append(offsets, fset.Position(n.Pos()).Offset)
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+	if len(offsets) == 0 {
+		return typ
+	}
+
+	sort.Ints(offsets)
+	var builder strings.Builder
+	last := 0
+	for _, offset := range offsets {
+		builder.WriteString(typ[last:offset])
+		builder.WriteString(tq.Name + ".")
+		last = offset
+	}
+	builder.WriteString(typ[last:])
+	return builder.String()
+}
+
+// Method qualifies a method signature as formatted by formatFuncSignature,
+// or the type of an embedded interface.
+func (tq TypeQualifier) Method(signature string, typeParams map[string]bool) string {
+	idx := strings.Index(signature, "(")
+	if idx < 0 {
+		return tq.Type(signature, typeParams)
+	}
+	return signature[:idx] + strings.TrimPrefix(tq.Type("func"+signature[idx:], typeParams), "func")
+}
+
+// Field qualifies a "name type" pair, such as a struct field or a type
+// parameter, or the type of an embedded field.
+func (tq TypeQualifier) Field(field string, typeParams map[string]bool) string {
+	if idx := strings.Index(field, " "); idx > 0 && token.IsIdentifier(field[:idx]) {
+		return field[:idx+1] + tq.Type(field[idx+1:], typeParams)
+	}
+	return tq.Type(field, typeParams)
+}
+
+// Interface returns item with qualified method signatures and type
+// parameter constraints and whether anything was qualified.
+func (tq TypeQualifier) Interface(item GoInterface) (GoInterface, bool) {
+	params := typeParamNames(item.TypeParams)
+	typeParams, paramsQualified := qualifyList(item.TypeParams, params, tq.Field)
+	methods, methodsQualified := qualifyList(item.Methods, params, tq.Method)
+	item.TypeParams, item.Methods = typeParams, methods
+	return item, paramsQualified || methodsQualified
+}
+
+// Struct returns item with qualified fields, methods and type parameter
+// constraints and whether anything was qualified.
+func (tq TypeQualifier) Struct(item GoStruct) (GoStruct, bool) {
+	params := typeParamNames(item.TypeParams)
+	typeParams, paramsQualified := qualifyList(item.TypeParams, params, tq.Field)
+	fields, fieldsQualified := qualifyList(item.Fields, params, tq.Field)
+	methods, methodsQualified := qualifyList(item.Methods, params, tq.Method)
+	item.TypeParams, item.Fields, item.Methods = typeParams, fields, methods
+	return item, paramsQualified || fieldsQualified || methodsQualified
+}
+
+// qualifyList applies qualify to every element of list and reports whether
+// any of them changed.
+func qualifyList(list []string, params map[string]bool, qualify func(string, map[string]bool) string) ([]string, bool) {
+	qualified := false
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		q := qualify(s, params)
+		qualified = qualified || q != s
+		result = append(result, q)
+	}
+	return result, qualified
+}
+
+// typeParamNames returns the names of type parameters formatted by
+// formatTypeParams.
+func typeParamNames(typeParams []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, param := range typeParams {
+		if fields := strings.Fields(param); len(fields) > 0 {
+			names[fields[0]] = true
+		}
+	}
+	return names
+}
+
+var namedTypePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?(\[.*\])?$`)
+
+// templateZeroValue is the zero value of typ in generated code that has to
+// compile. A named type may be a struct, an interface or anything else, so
+// its zero value is spelled *new(T).
+func templateZeroValue(typ string) string {
+	if typ == "any" || strings.HasPrefix(typ, "func") {
+		return "nil"
+	}
+	if namedTypePattern.MatchString(typ) && types.Universe.Lookup(typ) == nil {
+		return "*new(" + typ + ")"
+	}
+	return getZeroValue(typ)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTypeQualifier(t *testing.T) {
+	q := TypeQualifier{Name: "store", ImportPath: "example.com/app/store"}
+	params := map[string]bool{"T": true}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "local type", got: q.Type("User", nil), want: "store.User"},
+		{name: "predeclared", got: q.Type("error", nil), want: "error"},
+		{name: "already qualified", got: q.Type("*io.Reader", nil), want: "*io.Reader"},
+		{name: "composite", got: q.Type("map[ID][]*User", nil), want: "map[store.ID][]*store.User"},
+		{name: "type parameter", got: q.Type("Box[T]", params), want: "store.Box[T]"},
+		{name: "func type", got: q.Type("func(User) error", nil), want: "func(store.User) error"},
+		{name: "unparsable", got: q.Type("unknown(", nil), want: "unknown("},
+		{name: "method", got: q.Method("Get(context.Context, ID) (*User, error)", nil), want: "Get(context.Context, store.ID) (*store.User, error)"},
+		{name: "embedded interface", got: q.Method("Reader", nil), want: "store.Reader"},
+		{name: "named field", got: q.Field("Owner *User", nil), want: "Owner *store.User"},
+		{name: "embedded field", got: q.Field("*Base", nil), want: "*store.Base"},
+		{name: "type parameter constraint", got: q.Field("T Number", params), want: "T store.Number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeQualifierInterface(t *testing.T) {
+	q := TypeQualifier{Name: "store", ImportPath: "example.com/app/store"}
+	item := GoInterface{Name: "Store", TypeParams: []string{"T Entity"}, Methods: []string{"Get(ID) (T, error)", "Reader"}}
+
+	got, qualified := q.Interface(item)
+	want := GoInterface{Name: "Store", TypeParams: []string{"T store.Entity"}, Methods: []string{"Get(store.ID) (T, error)", "store.Reader"}}
+	if !qualified || !reflect.DeepEqual(got, want) {
+		t.Errorf("Interface() = %+v, %v, want %+v, true", got, qualified, want)
+	}
+
+	plain := GoInterface{Name: "Set", TypeParams: []string{"T comparable"}, Methods: []string{"Add(T) bool"}}
+	if got, qualified := q.Interface(plain); qualified || !reflect.DeepEqual(got, plain) {
+		t.Errorf("Interface() = %+v, %v, want it unchanged", got, qualified)
+	}
+}
+
+func TestTypeQualifierImportSpec(t *testing.T) {
+	tests := []struct {
+		q    TypeQualifier
+		want string
+	}{
+		{q: TypeQualifier{Name: "store", ImportPath: "example.com/app/store"}, want: `"example.com/app/store"`},
+		{q: TypeQualifier{Name: "store", ImportPath: "example.com/app/store/v2"}, want: `"example.com/app/store/v2"`},
+		{q: TypeQualifier{Name: "db", ImportPath: "example.com/app/go-db"}, want: `db "example.com/app/go-db"`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.q.ImportSpec(); got != tt.want {
+			t.Errorf("ImportSpec() of %s = %s, want %s", tt.q.ImportPath, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateZeroValue(t *testing.T) {
+	tests := map[string]string{
+		"error":         "nil",
+		"string":        `""`,
+		"int":           "0",
+		"*User":         "nil",
+		"[]User":        "nil",
+		"map[ID]User":   "nil",
+		"func() error":  "nil",
+		"any":           "nil",
+		"User":          "*new(User)",
+		"store.User":    "*new(store.User)",
+		"Box[int]":      "*new(Box[int])",
+		"[2]int":        "[2]int{}",
+		"chan<- string": "nil",
+	}
+
+	for typ, want := range tests {
+		if got := templateZeroValue(typ); got != want {
+			t.Errorf("templateZeroValue(%q) = %s, want %s", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// analysisRun is one run of analyze, which every command but "api diff"
+// and "diff" runs with the flags its name implies: the per-file listing
+// and whichever reports, generators and checks are enabled.
+type analysisRun struct {
+	cfg                *cliConfig
+	opts               AnalysisOptions
+	diagnostics        *Diagnostics
+	resolver           PackageResolver
+	directories        []string
+	useTopologicalSort bool
+	reportOnly         bool
+	rankFunctions      bool
+
+	fileWriter   FileWriter
+	memWriter    *MemoryFileWriter
+	noOpPlanner  *OutputPlanner[GoInterface]
+	templateSets []*TemplateSet
+	extraction   InterfaceExtractionOptions
+	layerRules   *LayerRules
+	thresholds   []MetricThreshold
+}
+
+func runAnalysis(ctx context.Context, cfg *cliConfig) error {
+	run, err := newAnalysisRun(cfg)
+	if err != nil {
+		return err
+	}
+	if err := run.load(ctx); err != nil {
+		return err
+	}
+	if err := run.walk(ctx); err != nil {
+		return err
+	}
+	failures, err := run.report(ctx)
+	if err != nil {
+		return err
+	}
+	templates, err := run.generate()
+	if err != nil {
+		return err
+	}
+
+	WriteDiagnostics(os.Stderr, run.diagnostics.List())
+
+	if cfg.checkMode {
+		if err := run.checkGenerated(); err != nil {
+			return err
+		}
+	}
+	if cfg.watchMode {
+		watcher := NewWatcher(run.directories, run.opts, run.resolver, run.noOpPlanner, templates, os.Stdout)
+		if err := watcher.Run(ctx, cfg.watchInterval); err != nil {
+			return fmt.Errorf("Watch failed: %v", err)
+		}
+		return nil
+	}
+	if failures > 0 {
+		return errFindings
+	}
+	return nil
+}
+
+func newAnalysisRun(cfg *cliConfig) (*analysisRun, error) {
+	selector, err := newFileSelector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	run := &analysisRun{
+		cfg:                cfg,
+		diagnostics:        NewDiagnostics(cfg.strict),
+		useTopologicalSort: cfg.topoSort && !cfg.alphaSort,
+	}
+
+	selectedTypes := make(map[string]bool)
+	if cfg.showAll {
+		selectedTypes["structs"] = true
+		selectedTypes["interfaces"] = true
+		selectedTypes["functions"] = true
+		selectedTypes["variables"] = true
+		selectedTypes["constants"] = true
+		selectedTypes["types"] = true
+		selectedTypes["imports"] = true
+	} else {
+		selectedTypes["structs"] = cfg.showStructs
+		selectedTypes["interfaces"] = cfg.showIfaces
+		selectedTypes["functions"] = cfg.showFuncs
+		selectedTypes["variables"] = cfg.showVars
+		selectedTypes["constants"] = cfg.showConsts
+		selectedTypes["types"] = cfg.showTypes
+		selectedTypes["imports"] = cfg.showImports
+	}
+
+	// If no specific type is selected, show all
+	hasSelection := false
+	for _, selected := range selectedTypes {
+		if selected {
+			hasSelection = true
+			break
+		}
+	}
+
+	// Reports on their own replace the per-file listing
+	run.reportOnly = (cfg.importGraph || cfg.layersFile != "" || cfg.showMetrics || cfg.callQuery() || cfg.impactOf != "" || cfg.deadCode || cfg.apiSnapshot || cfg.platforms != "") && !hasSelection && !cfg.showAll
+	run.reportOnly = run.reportOnly || cfg.noListing
+
+	if !hasSelection {
+		selectedTypes["structs"] = true
+		selectedTypes["interfaces"] = true
+		selectedTypes["functions"] = true
+		selectedTypes["variables"] = true
+		selectedTypes["constants"] = true
+		selectedTypes["types"] = true
+		selectedTypes["imports"] = true
+	}
+
+	// Check mode regenerates interfaces in memory and compares with disk;
+	// without templates it checks NoOps
+	if cfg.checkMode && cfg.builtinTemplate == "" {
+		cfg.genNoOp = true
+		selectedTypes["interfaces"] = true
+	}
+
+	run.opts = AnalysisOptions{
+		SelectedTypes:      selectedTypes,
+		UseTopologicalSort: run.useTopologicalSort,
+		GenNoOp:            cfg.genNoOp,
+		IncludeTests:       cfg.withTests,
+		Jobs:               cfg.jobs,
+		Build:              NewBuildContext(cfg.goos, cfg.goarch, parseBuildTags(cfg.buildTags)),
+		Files:              selector,
+		Diagnostics:        run.diagnostics,
+		Output:             os.Stdout,
+	}
+
+	if cfg.clearCache {
+		if err := ClearAnalysisCache(cfg.cacheDir); err != nil {
+			return nil, fmt.Errorf("Failed to clear analysis cache: %v", err)
+		}
+	}
+	if cfg.cacheDir != "" {
+		cache, err := OpenAnalysisCache(cfg.cacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open analysis cache: %v", err)
+		}
+		run.opts.Cache = cache
+	}
+
+	switch cfg.sortBy {
+	case SortByDependency, SortByName, SortByComplexity, SortByCognitive, SortByStatements, SortByNesting, SortByReturns:
+		run.opts.FunctionSort = cfg.sortBy
+	default:
+		return nil, fmt.Errorf("Unknown -sort value %q", cfg.sortBy)
+	}
+	if cfg.minComplexity > 0 || cfg.minCognitive > 0 {
+		run.opts.FunctionFilter = &FunctionComplexityFilter{MinCyclomatic: cfg.minComplexity, MinCognitive: cfg.minCognitive}
+	}
+
+	_, metricSort := NewFunctionMetricProvider(run.opts.FunctionSort)
+	run.rankFunctions = metricSort == nil && selectedTypes["functions"]
+	if run.rankFunctions {
+		run.opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	}
+
+	run.fileWriter = &SimpleFileWriter{}
+	if cfg.checkMode {
+		run.memWriter = NewMemoryFileWriter()
+		run.fileWriter = run.memWriter
+		run.opts.Output = io.Discard
+	}
+
+	if err := run.setupGenerators(); err != nil {
+		return nil, err
+	}
+	if err := run.setupReports(); err != nil {
+		return nil, err
+	}
+	if err := run.resolvePackages(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// newFileSelector selects the files to analyze by the source flags,
+// leaving out the output directories of the run.
+func newFileSelector(cfg *cliConfig) (*FileSelector, error) {
+	excluded, err := ParsePathPatterns(cfg.excludes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -exclude: %v", err)
+	}
+	included, err := ParsePathPatterns(cfg.includes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -include: %v", err)
+	}
+	// Never analyze what this run generates
+	outputDirs := make([]string, 0)
+	if cfg.genNoOp || cfg.checkMode {
+		outputDirs = append(outputDirs, cfg.noOpDir)
+	}
+	if cfg.tmplPath != "" || cfg.builtinTemplate != "" {
+		outputDirs = append(outputDirs, cfg.tmplOut)
+	}
+	return NewFileSelector(excluded, included, outputDirs, cfg.withGenerated, cfg.useGitignore), nil
+}
+
+// setupGenerators prepares the NoOp planner, interface extraction, test
+// scaffolds and templates, and the collectors they generate code from.
+func (run *analysisRun) setupGenerators() error {
+	cfg := run.cfg
+	if cfg.genNoOp && cfg.noOpDir != "" {
+		layout := OutputLayout{
+			Mode:         cfg.noOpLayout,
+			Mirror:       cfg.noOpMirror,
+			NameTemplate: cfg.noOpName,
+			PackageName:  cfg.noOpPackage,
+			Root:         cfg.noOpDir,
+			Kind:         TemplateKindInterface,
+			Template:     "noop",
+		}
+		planner, err := NewOutputPlanner(
+			layout,
+			defaultNameTemplate(layout.Mode, "noop", "interfaces"),
+			&InterfaceTypeNameProvider{},
+			newItemSorter[GoInterface](&InterfaceDependencyExtractor{}, &InterfaceTypeNameProvider{}, run.useTopologicalSort),
+		)
+		if err != nil {
+			return fmt.Errorf("Invalid NoOp layout: %v", err)
+		}
+		run.noOpPlanner = planner
+		run.opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	}
+
+	if cfg.extractFrom != "" {
+		run.extraction = InterfaceExtractionOptions{
+			StructName:    cfg.extractFrom,
+			InterfaceName: cfg.extractName,
+			Assert:        cfg.extractAssert,
+		}
+		if cfg.extractOnly != "" {
+			filter, err := regexp.Compile(cfg.extractOnly)
+			if err != nil {
+				return fmt.Errorf("Invalid -extract-methods pattern: %v", err)
+			}
+			run.extraction.MethodFilter = filter
+		}
+
+		run.opts.ensureCollectors("structs", "functions")
+		run.opts.Output = io.Discard
+	}
+
+	if cfg.genTests || cfg.genFuzz {
+		run.opts.ensureCollectors("structs", "interfaces", "functions")
+	}
+
+	if cfg.tmplPath != "" || cfg.builtinTemplate != "" {
+		// Templates render the imports of their items
+		run.opts.ensureCollectors()
+		if cfg.builtinTemplate != "" {
+			set, err := BuiltinTemplate(cfg.builtinTemplate, run.opts.Imports)
+			if err != nil {
+				return fmt.Errorf("Failed to load templates: %v", err)
+			}
+			run.templateSets = []*TemplateSet{set}
+		} else {
+			sets, err := LoadTemplates(cfg.tmplPath, run.opts.Imports)
+			if err != nil {
+				return fmt.Errorf("Failed to load templates: %v", err)
+			}
+			run.templateSets = sets
+		}
+
+		for _, set := range run.templateSets {
+			for _, kind := range set.Kinds() {
+				switch kind {
+				case TemplateKindInterface:
+					run.opts.ensureCollectors("interfaces")
+				case TemplateKindStruct:
+					run.opts.ensureCollectors("structs")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setupReports loads the layering rules and metric thresholds and selects
+// the collectors the reports need.
+func (run *analysisRun) setupReports() error {
+	cfg := run.cfg
+	if cfg.impactOf != "" {
+		run.opts.ensureCollectors("structs", "interfaces", "functions")
+	}
+
+	if cfg.layersFile != "" {
+		rules, err := LoadLayerRules(cfg.layersFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load layering rules: %v", err)
+		}
+		run.layerRules = rules
+
+		run.opts.ensureCollectors("structs", "interfaces", "functions")
+	}
+
+	if cfg.apiSnapshot {
+		run.opts.ensureCollectors("structs", "interfaces", "functions", "variables", "constants", "types")
+	}
+
+	if cfg.deadUpdate && cfg.deadBaseline == "" {
+		return fmt.Errorf("-dead-code-update-baseline requires -dead-code-baseline")
+	}
+
+	if cfg.showMetrics || cfg.metricsFail != "" {
+		thresholds, err := ParseMetricThresholds(cfg.metricsFail)
+		if err != nil {
+			return fmt.Errorf("Invalid -metrics-fail: %v", err)
+		}
+		run.thresholds = thresholds
+		cfg.showMetrics = true
+
+		// Type references between packages come from the dependency graph
+		run.opts.ensureCollectors("structs", "interfaces", "functions")
+	}
+	return nil
+}
+
+// resolvePackages finds the modules of -dirs and the packages and expands
+// the package patterns into the directories to analyze.
+func (run *analysisRun) resolvePackages() error {
+	run.directories = splitDirs(run.cfg.dirs)
+	sort.Strings(run.directories)
+
+	patterns := run.cfg.patterns
+	resolver, err := NewPackageResolver(workspaceRoots(run.directories, patterns))
+	if err != nil {
+		return fmt.Errorf("Failed to load module: %v", err)
+	}
+	run.resolver = resolver
+	if len(patterns) > 0 {
+		// Package patterns name packages, so their subdirectories are not walked
+		expanded, err := expandPatterns(patterns, resolver)
+		if err != nil {
+			return fmt.Errorf("Invalid package pattern: %v", err)
+		}
+		run.directories = expanded
+		run.opts.NonRecursive = true
+	}
+	return nil
+}
+
+// load builds the import graph and the scope of -changed-since before the
+// files are analyzed.
+func (run *analysisRun) load(ctx context.Context) error {
+	cfg := run.cfg
+	if run.reportOnly {
+		run.opts.Output = io.Discard
+	}
+	if cfg.importGraph || cfg.layersFile != "" || cfg.showMetrics || cfg.callQuery() || cfg.impactOf != "" || cfg.deadCode || run.opts.SelectedTypes["imports"] || cfg.changedSince != "" {
+		graph, err := BuildImportGraph(run.directories, run.opts, run.resolver)
+		if err != nil {
+			return fmt.Errorf("Failed to build import graph: %v", err)
+		}
+		run.opts.Graph = graph
+	}
+
+	if cfg.changedSince != "" {
+		files, err := ChangedGoFiles(cfg.changedSince)
+		if err != nil {
+			return fmt.Errorf("Failed to list changed files: %v", err)
+		}
+		changes := NewChangeScope(cfg.changedSince, files)
+		if err := changes.AddDependents(ctx, run.directories, run.opts, run.resolver); err != nil {
+			return fmt.Errorf("Failed to find dependents of changed files: %v", err)
+		}
+		run.opts.Changes = changes
+		run.opts.FunctionFilter = NewChangedItemValidator[GoFunction](changes, &FunctionPositionProvider{}, run.opts.FunctionFilter)
+	}
+	return nil
+}
+
+// walk analyzes every directory, printing the per-file listing unless it
+// is discarded.
+func (run *analysisRun) walk(ctx context.Context) error {
+	opts := run.opts
+	sortType := "Topological"
+	if !run.useTopologicalSort {
+		sortType = "Alphabetical"
+	}
+	fmt.Fprintf(opts.Output, "Using %s sorting", sortType)
+	if run.cfg.genNoOp {
+		fmt.Fprintf(opts.Output, " with NoOp generation enabled (output: %s)", run.cfg.noOpDir)
+	}
+	fmt.Fprintln(opts.Output)
+	if modules, ok := run.resolver.(*ModuleResolver); ok {
+		for _, module := range modules.Modules() {
+			fmt.Fprintf(opts.Output, "Module: %s (%s)\n", module.Path, module.Dir)
+		}
+	}
+	if opts.Changes != nil {
+		fmt.Fprintf(opts.Output, "Changed since %s: %d file(s), %d directly dependent declaration(s)\n", opts.Changes.Revision, opts.Changes.Files(), opts.Changes.Dependents())
+	}
+
+	for _, dir := range run.directories {
+		fmt.Fprintf(opts.Output, "\n=== Analyzing directory: %s ===\n", dir)
+
+		if err := walkDirectory(ctx, dir, opts); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("Analysis interrupted")
+			}
+			if run.cfg.strict {
+				return fmt.Errorf("Error analyzing directory %s: %v", dir, err)
+			}
+			log.Printf("Error analyzing directory %s: %v", dir, err)
+		}
+	}
+
+	if run.rankFunctions {
+		printFunctionRanking(opts.Output, opts.FunctionSources.CollectResults(), opts.FunctionSort, opts.FunctionFilter)
+	}
+	return nil
+}
+
+// report writes the enabled reports and returns the number of layer
+// violations and metric threshold breaches.
+func (run *analysisRun) report(ctx context.Context) (int, error) {
+	cfg, opts := run.cfg, run.opts
+	if cfg.importGraph {
+		if err := WriteImportGraph(os.Stdout, opts.Graph, cfg.format); err != nil {
+			return 0, fmt.Errorf("Failed to write import graph: %v", err)
+		}
+	}
+
+	if err := run.reportCalls(ctx); err != nil {
+		return 0, err
+	}
+
+	if cfg.deadCode {
+		if err := run.reportDeadCode(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	if cfg.apiSnapshot {
+		if err := run.writeAPISnapshot(); err != nil {
+			return 0, fmt.Errorf("Failed to write API snapshot: %v", err)
+		}
+	}
+
+	failures := 0
+	if run.layerRules != nil {
+		violations := CheckLayers(run.layerRules, opts.Graph, opts, run.resolver)
+		if opts.Changes != nil {
+			violations = filterValid[LayerViolation](violations, NewChangedItemValidator[LayerViolation](opts.Changes, &LayerViolationPositionProvider{}, nil))
+		}
+		if err := WriteLayerViolations(os.Stdout, violations, cfg.format); err != nil {
+			return 0, fmt.Errorf("Failed to write layer violations: %v", err)
+		}
+		failures += len(violations)
+	}
+
+	if cfg.platforms != "" {
+		targets, err := parsePlatforms(cfg.platforms)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid -platforms: %v", err)
+		}
+		report, err := ComparePlatforms(ctx, run.directories, opts, run.resolver, targets, parseBuildTags(cfg.buildTags))
+		if err != nil {
+			return 0, fmt.Errorf("Failed to compare platforms: %v", err)
+		}
+		if err := WritePlatformReport(os.Stdout, report, cfg.format); err != nil {
+			return 0, fmt.Errorf("Failed to write platform report: %v", err)
+		}
+	}
+
+	if cfg.showMetrics {
+		counts := CountDeclarations(opts.Graph, opts, run.resolver)
+		references := BuildDependencyGraph(opts, run.resolver, nil).PackageReferences()
+		metrics := ComputePackageMetrics(opts.Graph, counts, references)
+		breaches := CheckMetricThresholds(metrics, run.thresholds)
+		if err := WritePackageMetrics(os.Stdout, metrics, breaches, cfg.format); err != nil {
+			return 0, fmt.Errorf("Failed to write package metrics: %v", err)
+		}
+		failures += len(breaches)
+	}
+	return failures, nil
+}
+
+// reportCalls exports the call graph, answers -callers and -callees and
+// reports the impact of a declaration.
+func (run *analysisRun) reportCalls(ctx context.Context) error {
+	cfg, opts := run.cfg, run.opts
+	if !cfg.callQuery() && cfg.impactOf == "" {
+		return nil
+	}
+	calls, err := BuildCallGraph(ctx, run.directories, opts.Graph, opts, run.resolver)
+	if err != nil {
+		return fmt.Errorf("Failed to build call graph: %v", err)
+	}
+
+	if cfg.callGraph {
+		if err := WriteCallGraph(os.Stdout, calls, cfg.format); err != nil {
+			return fmt.Errorf("Failed to write call graph: %v", err)
+		}
+	}
+	for _, query := range []struct {
+		name, title, arrow string
+		walk               func(string, int) *CallTree
+	}{
+		{cfg.calleesOf, "Callees of", "->", calls.Callees},
+		{cfg.callersOf, "Callers of", "<-", calls.Callers},
+	} {
+		if query.name == "" {
+			continue
+		}
+		matches := calls.Find(query.name)
+		if len(matches) == 0 {
+			return fmt.Errorf("Function %s not found in the call graph", query.name)
+		}
+		for _, key := range matches {
+			if err := WriteCallTree(os.Stdout, query.title, query.walk(key, cfg.callDepth), query.arrow, cfg.format); err != nil {
+				return fmt.Errorf("Failed to write call tree: %v", err)
+			}
+		}
+	}
+
+	if cfg.impactOf != "" {
+		dependencies := BuildDependencyGraph(opts, run.resolver, calls)
+		matches := dependencies.Find(cfg.impactOf)
+		if len(matches) == 0 {
+			return fmt.Errorf("Declaration %s not found", cfg.impactOf)
+		}
+		for _, key := range matches {
+			if err := WriteImpact(os.Stdout, dependencies.Impact(key), cfg.format); err != nil {
+				return fmt.Errorf("Failed to write impact: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (run *analysisRun) reportDeadCode(ctx context.Context) error {
+	cfg, opts := run.cfg, run.opts
+	findings, err := FindDeadCode(ctx, run.directories, opts.Graph, opts, run.resolver)
+	if err != nil {
+		return fmt.Errorf("Failed to find dead code: %v", err)
+	}
+	if cfg.deadUpdate {
+		if err := WriteDeadCodeBaseline(cfg.deadBaseline, findings); err != nil {
+			return fmt.Errorf("Failed to write dead code baseline: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "Wrote %d finding(s) to %s\n", len(findings), cfg.deadBaseline)
+		return nil
+	}
+
+	suppressed := 0
+	if cfg.deadBaseline != "" {
+		baseline, err := LoadDeadCodeBaseline(cfg.deadBaseline)
+		if err != nil {
+			return fmt.Errorf("Failed to load dead code baseline: %v", err)
+		}
+		findings, suppressed = FilterDeadCode(findings, baseline)
+	}
+	if opts.Changes != nil {
+		findings = filterValid[DeadCode](findings, NewChangedItemValidator[DeadCode](opts.Changes, &DeadCodePositionProvider{}, nil))
+	}
+	if err := WriteDeadCode(os.Stdout, findings, suppressed, cfg.format); err != nil {
+		return fmt.Errorf("Failed to write dead code: %v", err)
+	}
+	return nil
+}
+
+func (run *analysisRun) writeAPISnapshot() error {
+	out := io.Writer(os.Stdout)
+	if run.cfg.apiOut != "" {
+		f, err := os.Create(run.cfg.apiOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return WriteAPISnapshot(out, BuildAPISnapshot(run.opts, run.resolver), run.cfg.format)
+}
+
+// generate extracts interfaces and writes NoOps, template output and test
+// scaffolds. It returns the template outputs for watch mode.
+func (run *analysisRun) generate() (*TemplateOutputs, error) {
+	cfg, opts := run.cfg, run.opts
+	if opts.StructSources != nil && opts.FunctionSources != nil {
+		linkStructMethods(opts.StructSources.CollectResults(), opts.FunctionSources.CollectResults())
+	}
+
+	if cfg.extractFrom != "" {
+		if err := runInterfaceExtraction(run.extraction, opts, cfg.extractOut); err != nil {
+			return nil, fmt.Errorf("Failed to extract interface: %v", err)
+		}
+	}
+
+	outputs := make([]PlannedOutput, 0)
+	if run.noOpPlanner != nil {
+		output, err := planOutput[GoInterface](
+			"noop",
+			run.noOpPlanner,
+			opts.InterfaceSources.CollectResults(),
+			NewGenericCodeGenerator(&InterfaceNoOpCodeGenerator{}, &InterfaceImplementationNamer{}, run.fileWriter),
+			&GeneratedFileRenderer[GoInterface]{},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to plan NoOp files: %v", err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	var templates *TemplateOutputs
+	if len(run.templateSets) > 0 {
+		templateLayout := OutputLayout{
+			Mode:         cfg.tmplLayout,
+			Mirror:       cfg.tmplMirror,
+			NameTemplate: cfg.tmplName,
+			PackageName:  cfg.tmplPackage,
+			Root:         cfg.tmplOut,
+		}
+		templates = NewTemplateOutputs(run.templateSets, templateLayout, run.useTopologicalSort, run.resolver)
+		planned, err := templates.Plan(opts, run.fileWriter)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, planned...)
+	}
+
+	if (cfg.genTests || cfg.genFuzz) && !cfg.checkMode {
+		if err := run.generateScaffolds(); err != nil {
+			return nil, fmt.Errorf("Failed to generate test scaffolds: %v", err)
+		}
+	}
+
+	if err := checkPathCollisions(outputs); err != nil {
+		return nil, fmt.Errorf("Failed to plan generated files: %v", err)
+	}
+
+	// Commands without the listing still list the files they write
+	messages := opts.Output
+	if cfg.noListing && !cfg.checkMode {
+		messages = os.Stdout
+	}
+	for _, output := range outputs {
+		written, err := output.Write()
+		for _, path := range written {
+			if output.Label == "noop" {
+				fmt.Fprintf(messages, "Generated NoOp implementations: %s\n", path)
+			} else {
+				fmt.Fprintf(messages, "Generated %s: %s\n", output.Label, path)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate files: %v", err)
+		}
+	}
+	return templates, nil
+}
+
+// generateScaffolds writes the test and fuzz scaffolds and the fakes they
+// use, listing what it wrote even if it fails halfway.
+func (run *analysisRun) generateScaffolds() error {
+	cfg, opts := run.cfg, run.opts
+	index := NewScaffoldIndex(opts, run.resolver)
+	written := make([]string, 0)
+	var unfuzzable []UnfuzzableFunction
+	var err error
+
+	if cfg.genTests {
+		var tests []string
+		tests, err = generateTestScaffolds(opts, index, run.fileWriter)
+		written = append(written, tests...)
+	}
+	if cfg.genFuzz && err == nil {
+		var targets []string
+		targets, unfuzzable, err = generateFuzzScaffolds(opts, index, run.fileWriter)
+		written = append(written, targets...)
+	}
+	if err == nil {
+		var fakes []string
+		fakes, err = index.WriteFakes(opts.Imports, run.fileWriter, opts.Output)
+		written = append(written, fakes...)
+	}
+
+	for _, path := range written {
+		fmt.Fprintf(opts.Output, "Generated test scaffold: %s\n", path)
+	}
+	printUnfuzzable(opts.Output, unfuzzable)
+	return err
+}
+
+// checkGenerated compares the files generated in memory with the output
+// directories on disk.
+func (run *analysisRun) checkGenerated() error {
+	roots := make([]string, 0)
+	if run.cfg.genNoOp {
+		roots = append(roots, run.cfg.noOpDir)
+	}
+	if len(run.templateSets) > 0 {
+		roots = append(roots, run.cfg.tmplOut)
+	}
+
+	stale, err := checkGeneratedFiles(roots, run.memWriter.Files(), os.Stdout)
+	if err != nil {
+		return fmt.Errorf("Check failed: %v", err)
+	}
+	if stale > 0 {
+		fmt.Printf("%d generated file(s) in %s are out of date\n", stale, strings.Join(roots, ", "))
+		return errFindings
+	}
+	fmt.Printf("Generated files in %s are up to date\n", strings.Join(roots, ", "))
+	return nil
+}
+
+// runArchitectureDiff analyzes two git revisions and compares their
+// architecture.
+func runArchitectureDiff(ctx context.Context, cfg *cliConfig) error {
+	selector, err := newFileSelector(cfg)
+	if err != nil {
+		return err
+	}
+	diagnostics := NewDiagnostics(cfg.strict)
+	base := AnalysisOptions{
+		IncludeTests: cfg.withTests,
+		Jobs:         cfg.jobs,
+		Build:        NewBuildContext(cfg.goos, cfg.goarch, parseBuildTags(cfg.buildTags)),
+		Files:        selector,
+		Diagnostics:  diagnostics,
+	}
+
+	scope, err := NewRevisionScope(splitDirs(cfg.dirs), cfg.patterns)
+	if err != nil {
+		return fmt.Errorf("Failed to locate the analyzed directories: %v", err)
+	}
+	old, err := AnalyzeRevision(ctx, cfg.fromRev, scope, base)
+	if err != nil {
+		return fmt.Errorf("Failed to analyze %s: %v", cfg.fromRev, err)
+	}
+	current, err := AnalyzeRevision(ctx, cfg.toRev, scope, base)
+	if err != nil {
+		return fmt.Errorf("Failed to analyze %s: %v", cfg.toRev, err)
+	}
+	if err := WriteArchitectureDiff(os.Stdout, DiffArchitecture(cfg.fromRev, cfg.toRev, old, current), cfg.format); err != nil {
+		return fmt.Errorf("Failed to write architecture diff: %v", err)
+	}
+	WriteDiagnostics(os.Stderr, diagnostics.List())
+	return nil
+}
+
+// runAPIDiff compares the two API snapshots named by the arguments.
+func runAPIDiff(ctx context.Context, cfg *cliConfig) error {
+	old, err := LoadAPISnapshot(cfg.patterns[0])
+	if err != nil {
+		return fmt.Errorf("Failed to diff API snapshots: %v", err)
+	}
+	current, err := LoadAPISnapshot(cfg.patterns[1])
+	if err != nil {
+		return fmt.Errorf("Failed to diff API snapshots: %v", err)
+	}
+	if err := WriteAPIDiff(os.Stdout, DiffAPISnapshots(old, current), cfg.format); err != nil {
+		return fmt.Errorf("Failed to diff API snapshots: %v", err)
+	}
+	return nil
+}
+
+// splitDirs splits the comma-separated -dirs.
+func splitDirs(dirs string) []string {
+	list := make([]string, 0)
+	for _, dir := range strings.Split(dirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			list = append(list, dir)
+		}
+	}
+	return list
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// generatedComment is the header marking generated Go files, see
+// https://go.dev/s/generatedcode.
+var generatedComment = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// FileSelector decides which directories are walked and which files are
+// analyzed, beyond the build constraints: -exclude and -include patterns,
+// .gitignore rules, generated files and astro's own output directories.
+// Paths are matched relative to the working directory, or to base if set.
+type FileSelector struct {
+	base      string
+	excludes  []*PathPattern
+	includes  []*PathPattern
+	outputs   []string
+	generated bool
+	gitignore *GitignoreMatcher
+}
+
+// NewFileSelector returns a selector skipping what the patterns exclude
+// and, if includes is not empty, every file they don't match. Generated
+// files are skipped unless generated is set, .gitignore rules only apply
+// with gitignore and nothing below outputs is analyzed.
+func NewFileSelector(excludes, includes []*PathPattern, outputs []string, generated, gitignore bool) *FileSelector {
+	fs := &FileSelector{
+		excludes:  excludes,
+		includes:  includes,
+		outputs:   make([]string, 0, len(outputs)),
+		generated: generated,
+	}
+	for _, dir := range outputs {
+		if dir != "" {
+			fs.outputs = append(fs.outputs, absolutePath(dir))
+		}
+	}
+	if gitignore {
+		fs.gitignore = NewGitignoreMatcher()
+	}
+	return fs
+}
+
+// RelativeTo returns a copy of the selector matching paths relative to dir
+// instead of the working directory.
+func (fs *FileSelector) RelativeTo(dir string) *FileSelector {
+	rebased := *fs
+	rebased.base = dir
+	return &rebased
+}
+
+// SkipsDir reports whether the walk skips dir, a directory below the one
+// walked. Like "./..." for the go command, testdata, vendor and directories
+// starting with "." or "_" are always skipped.
+func (fs *FileSelector) SkipsDir(dir string) bool {
+	name := filepath.Base(dir)
+	if name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return true
+	}
+	rel := selectionPath(fs.base, dir)
+	for _, pattern := range fs.excludes {
+		if pattern.Matches(rel) {
+			return true
+		}
+	}
+	return fs.inOutput(dir) || (fs.gitignore != nil && fs.gitignore.Ignored(dir, true))
+}
+
+// SelectsFile reports whether filename is analyzed. Exclusions also apply
+// to the directories filename is in, so files of an excluded directory
+// named explicitly are skipped too.
+func (fs *FileSelector) SelectsFile(filename string) bool {
+	rel := selectionPath(fs.base, filename)
+	for _, pattern := range fs.excludes {
+		if pattern.Matches(rel) {
+			return false
+		}
+	}
+	if len(fs.includes) > 0 {
+		included := false
+		for _, pattern := range fs.includes {
+			if pattern.Matches(rel) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	if fs.inOutput(filename) || (fs.gitignore != nil && fs.gitignore.Ignored(filename, false)) {
+		return false
+	}
+	return fs.generated || !isGeneratedFile(filename)
+}
+
+func (fs *FileSelector) inOutput(path string) bool {
+	if len(fs.outputs) == 0 {
+		return false
+	}
+	path = absolutePath(path)
+	for _, dir := range fs.outputs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectionPath is path relative to base, or to the working directory if
+// base is empty, with slashes. Paths outside of it stay as they are.
+func selectionPath(base, path string) string {
+	if filepath.IsAbs(path) {
+		if base == "" {
+			base, _ = os.Getwd()
+		}
+		if rel, ok := relativeTo(base, path); ok {
+			return rel
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// isGeneratedFile reports whether filename has the generated code comment
+// before its package clause. Unreadable files are not generated, so the
+// error is reported when they are parsed.
+func isGeneratedFile(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+		if generatedComment.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// PathPattern matches slash-separated paths. Glob patterns support *, ?,
+// [...] and ** for any number of directories; a glob without a slash
+// matches any element of the path, one with a slash the path from its
+// start or a directory the path is in. Patterns starting with "re:" are
+// regular expressions searched for in the path.
+type PathPattern struct {
+	re      *regexp.Regexp
+	glob    bool
+	element bool
+}
+
+func NewPathPattern(pattern string) (*PathPattern, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		return &PathPattern{re: re}, nil
+	}
+
+	glob := strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/")
+	if glob == "" {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+	re, err := globRegexp(glob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return &PathPattern{re: re, glob: true, element: !strings.Contains(glob, "/")}, nil
+}
+
+// ParsePathPatterns parses a comma-separated list of patterns.
+func ParsePathPatterns(value string) ([]*PathPattern, error) {
+	patterns := make([]*PathPattern, 0)
+	for _, source := range strings.Split(value, ",") {
+		if source = strings.TrimSpace(source); source == "" {
+			continue
+		}
+		pattern, err := NewPathPattern(source)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func (pp *PathPattern) Matches(path string) bool {
+	if !pp.glob {
+		return pp.re.MatchString(path)
+	}
+	elements := strings.Split(path, "/")
+	if pp.element {
+		for _, element := range elements {
+			if element != "." && element != ".." && pp.re.MatchString(element) {
+				return true
+			}
+		}
+		return false
+	}
+	for i := len(elements); i > 0; i-- {
+		if pp.re.MatchString(strings.Join(elements[:i], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp translates a glob into an anchored regular expression. *
+// and ? don't match slashes; ** matches across them, and "**/" also
+// matches no directory at all.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// ignoreRule is one pattern of a .gitignore file. Rules without a slash
+// match the name of a file or directory at any depth, the others the path
+// relative to the directory of the .gitignore file.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	basename bool
+}
+
+func (ir ignoreRule) matches(rel string, isDir bool) bool {
+	if ir.dirOnly && !isDir {
+		return false
+	}
+	if ir.basename {
+		return ir.re.MatchString(filepath.Base(rel))
+	}
+	return ir.re.MatchString(rel)
+}
+
+// parseIgnoreRules reads the rules of a .gitignore or info/exclude file.
+// Lines that aren't valid patterns are ignored, like git does.
+func parseIgnoreRules(content string) []ignoreRule {
+	rules := make([]ignoreRule, 0)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.basename = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		re, err := globRegexp(line)
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// GitignoreMatcher applies the .gitignore files of the repositories paths
+// are in, and their info/exclude file. Files are read once.
+type GitignoreMatcher struct {
+	mu    sync.Mutex
+	rules map[string][]ignoreRule
+	repos map[string]bool
+}
+
+func NewGitignoreMatcher() *GitignoreMatcher {
+	return &GitignoreMatcher{
+		rules: make(map[string][]ignoreRule),
+		repos: make(map[string]bool),
+	}
+}
+
+// Ignored reports whether git ignores path or a directory it is in. Paths
+// outside of a repository are never ignored.
+func (gm *GitignoreMatcher) Ignored(path string, isDir bool) bool {
+	path = absolutePath(path)
+
+	// Directories from path up to the repository root
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if gm.isRepository(dir) {
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+
+	// A file in an ignored directory can't be included again
+	for i := len(dirs) - 2; i >= 0; i-- {
+		if gm.ignores(dirs[i+1:], dirs[i], true) {
+			return true
+		}
+	}
+	return gm.ignores(dirs, path, isDir)
+}
+
+// ignores applies the rules of dirs, innermost first, to path; the last
+// matching rule of the innermost file with one decides.
+func (gm *GitignoreMatcher) ignores(dirs []string, path string, isDir bool) bool {
+	for _, dir := range dirs {
+		rules := gm.load(dir)
+		rel, ok := relativeTo(dir, path)
+		if !ok {
+			continue
+		}
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].matches(rel, isDir) {
+				return !rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+func (gm *GitignoreMatcher) isRepository(dir string) bool {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	repo, ok := gm.repos[dir]
+	if !ok {
+		_, err := os.Stat(filepath.Join(dir, ".git"))
+		repo = err == nil
+		gm.repos[dir] = repo
+	}
+	return repo
+}
+
+func (gm *GitignoreMatcher) load(dir string) []ignoreRule {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	rules, ok := gm.rules[dir]
+	if !ok {
+		if content, err := os.ReadFile(filepath.Join(dir, ".gitignore")); err == nil {
+			rules = parseIgnoreRules(string(content))
+		}
+		if gm.repos[dir] {
+			// The repository's own exclusions apply before its .gitignore
+			if content, err := os.ReadFile(filepath.Join(dir, ".git", "info", "exclude")); err == nil {
+				rules = append(parseIgnoreRules(string(content)), rules...)
+			}
+		}
+		gm.rules[dir] = rules
+	}
+	return rules
+}
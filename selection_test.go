@@ -0,0 +1,245 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "mocks", path: "mocks/store.go", want: true},
+		{pattern: "mocks", path: "internal/mocks/store.go", want: true},
+		{pattern: "mocks", path: "internal/mockstore/store.go", want: false},
+		{pattern: "*_gen.go", path: "api/v1/types_gen.go", want: true},
+		{pattern: "*_gen.go", path: "api/v1/types.go", want: false},
+		{pattern: "./internal/", path: "internal/store/store.go", want: true},
+		{pattern: "internal/*.go", path: "internal/store.go", want: true},
+		{pattern: "internal/*.go", path: "internal/store/store.go", want: false},
+		{pattern: "internal/**/*.go", path: "internal/store.go", want: true},
+		{pattern: "internal/**/*.go", path: "internal/a/b/store.go", want: true},
+		{pattern: "api/v?", path: "api/v1/api.go", want: true},
+		{pattern: "api/v[!1]", path: "api/v1/api.go", want: false},
+		{pattern: "api/v[!1]", path: "api/v2/api.go", want: true},
+		{pattern: `re:_gen\.go$`, path: "api/types_gen.go", want: true},
+		{pattern: `re:_gen\.go$`, path: "api/gen.go", want: false},
+		{pattern: `re:^cmd/`, path: "internal/cmd/main.go", want: false},
+		{pattern: `re:/cmd/`, path: "internal/cmd/main.go", want: true},
+	}
+
+	for _, tt := range tests {
+		pattern, err := NewPathPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("NewPathPattern(%q): %v", tt.pattern, err)
+		}
+		if got := pattern.Matches(tt.path); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"re:(", "[abc", "./", ""} {
+		if _, err := NewPathPattern(invalid); err == nil {
+			t.Errorf("NewPathPattern(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestFileSelector(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		".git/info/exclude":         "*_local.go\n",
+		".gitignore":                "# build output\nout/\n*_gen.go\n!keep_gen.go\n/root_only.go\n",
+		"main.go":                   "package main\n",
+		"config_local.go":           "package main\n",
+		"root_only.go":              "package main\n",
+		"zz_generated.go":           "// Code generated by stringer. DO NOT EDIT.\n\npackage main\n",
+		"late_header.go":            "package main\n\n// Code generated by stringer. DO NOT EDIT.\n",
+		"out/out.go":                "package out\n",
+		"sub/root_only.go":          "package sub\n",
+		"sub/out.go":                "package sub\n",
+		"api/.gitignore":            "internal_only.go\n!/v1/*_gen.go\n",
+		"api/api.go":                "package api\n",
+		"api/api_gen.go":            "package api\n",
+		"api/keep_gen.go":           "package api\n",
+		"api/internal_only.go":      "package api\n",
+		"api/v1/types_gen.go":       "package v1\n",
+		"api/v1/internal_only.go":   "package v1\n",
+		"mocks/mock_store.go":       "package mocks\n",
+		"vendor/example.com/v.go":   "package v\n",
+		"testdata/fixture.go":       "package fixture\n",
+		"_scratch/scratch.go":       "package scratch\n",
+		".hidden/hidden.go":         "package hidden\n",
+		"internal/store/store.go":   "package store\n",
+		"internal/store/cmd/cmd.go": "package cmd\n",
+	})
+
+	all := []string{
+		"api/api.go", "api/api_gen.go", "api/internal_only.go", "api/keep_gen.go",
+		"api/v1/internal_only.go", "api/v1/types_gen.go",
+		"config_local.go", "internal/store/cmd/cmd.go", "internal/store/store.go",
+		"late_header.go", "main.go", "mocks/mock_store.go", "out/out.go",
+		"root_only.go", "sub/out.go", "sub/root_only.go",
+	}
+	without := func(excluded ...string) []string {
+		files := make([]string, 0)
+		for _, file := range all {
+			keep := true
+			for _, e := range excluded {
+				keep = keep && file != e
+			}
+			if keep {
+				files = append(files, file)
+			}
+		}
+		return files
+	}
+	patterns := func(values ...string) []*PathPattern {
+		parsed := make([]*PathPattern, 0, len(values))
+		for _, value := range values {
+			pattern, err := NewPathPattern(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed = append(parsed, pattern)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name      string
+		excludes  []*PathPattern
+		includes  []*PathPattern
+		outputs   []string
+		generated bool
+		gitignore bool
+		want      []string
+	}{
+		{
+			name: "defaults skip generated files only",
+			want: all,
+		},
+		{
+			name:      "generated files included",
+			generated: true,
+			want:      append(without(), "zz_generated.go"),
+		},
+		{
+			name:    "output directories",
+			outputs: []string{filepath.Join(root, "mocks"), filepath.Join(root, "api", "v1")},
+			want:    without("mocks/mock_store.go", "api/v1/internal_only.go", "api/v1/types_gen.go"),
+		},
+		{
+			name:     "glob exclude of a directory name",
+			excludes: patterns("api"),
+			want:     without("api/api.go", "api/api_gen.go", "api/internal_only.go", "api/keep_gen.go", "api/v1/internal_only.go", "api/v1/types_gen.go"),
+		},
+		{
+			name:     "glob exclude of a path",
+			excludes: patterns("internal/store/cmd", "sub/*.go"),
+			want:     without("internal/store/cmd/cmd.go", "sub/out.go", "sub/root_only.go"),
+		},
+		{
+			name:     "regular expression exclude",
+			excludes: patterns(`re:_(gen|local)\.go$`),
+			want:     without("api/api_gen.go", "api/keep_gen.go", "api/v1/types_gen.go", "config_local.go"),
+		},
+		{
+			name:     "include",
+			includes: patterns("internal/**/*.go", `re:^api/[^/]*$`),
+			want:     []string{"api/api.go", "api/api_gen.go", "api/internal_only.go", "api/keep_gen.go", "internal/store/cmd/cmd.go", "internal/store/store.go"},
+		},
+		{
+			name:     "exclude wins over include",
+			includes: patterns("internal"),
+			excludes: patterns("cmd"),
+			want:     []string{"internal/store/store.go"},
+		},
+		{
+			name:      "gitignore",
+			gitignore: true,
+			// out/ is dir-only: sub/out.go stays. /root_only.go is anchored:
+			// sub/root_only.go stays. keep_gen.go and api/v1 are negated,
+			// internal_only.go is ignored at any depth below api.
+			want: []string{
+				"api/api.go", "api/keep_gen.go", "api/v1/types_gen.go",
+				"internal/store/cmd/cmd.go", "internal/store/store.go",
+				"late_header.go", "main.go", "mocks/mock_store.go",
+				"sub/out.go", "sub/root_only.go",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := NewFileSelector(tt.excludes, tt.includes, tt.outputs, tt.generated, tt.gitignore).RelativeTo(root)
+			files, err := analyzableFiles(root, AnalysisOptions{Files: selector})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(files))
+			for _, file := range files {
+				rel, err := filepath.Rel(root, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("selected %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestGitignoreMatcher(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		".git/HEAD":            "ref: refs/heads/main\n",
+		".gitignore":           "build/\nlogs\n!logs/keep.go\n",
+		"build/main.go":        "package main\n",
+		"logs/keep.go":         "package logs\n",
+		"pkg/build":            "not a directory\n",
+		"nested/.gitignore":    "*.go\n!api.go\n",
+		"nested/api.go":        "package nested\n",
+		"nested/impl.go":       "package nested\n",
+		"nested/deep/impl.go":  "package deep\n",
+		"nested/deep/api.go":   "package deep\n",
+		"nested/deep/build.go": "package deep\n",
+	})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "build", isDir: true, want: true},
+		{path: "build/main.go", want: true},
+		{path: "pkg/build", want: false},
+		{path: "pkg/build", isDir: true, want: true},
+		// Files of an ignored directory can't be included again
+		{path: "logs/keep.go", want: true},
+		{path: "nested/api.go", want: false},
+		{path: "nested/impl.go", want: true},
+		{path: "nested/deep/impl.go", want: true},
+		{path: "nested/deep/api.go", want: false},
+		{path: "nested/deep/build.go", want: true},
+	}
+
+	matcher := NewGitignoreMatcher()
+	for _, tt := range tests {
+		path := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := matcher.Ignored(path, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%s, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	outside := writeTestModule(t, map[string]string{".gitignore": "*.go\n", "main.go": "package main\n"})
+	if matcher.Ignored(filepath.Join(outside, "main.go"), false) {
+		t.Error("file outside of a repository ignored")
+	}
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const (
+	TemplateKindInterface = "interface"
+	TemplateKindStruct    = "struct"
+	TemplateBlockHeader   = "header"
+)
+
+// TemplateMethod is a method signature split into its parts for templates.
+// NamedParams names unnamed and blank parameters p0, p1, ..., so Args can
+// pass them on; a variadic argument ends in "...".
+type TemplateMethod struct {
+	Name        string
+	Params      string
+	Returns     string
+	ZeroValues  string
+	NamedParams string
+	Args        string
+	ArgNames    []string
+}
+
+// TemplateFile is what the optional "header" block is executed against.
+type TemplateFile struct {
+	Package string
+	Imports []string
+	Sources []string
+}
+
+type ImportIndex struct {
+	files map[string][]GoImport
+	names map[string]string
+}
+
+func NewImportIndex() *ImportIndex {
+	return &ImportIndex{files: make(map[string][]GoImport)}
+}
+
+func (ii *ImportIndex) Add(filename string, imports []GoImport) {
+	ii.files[filepath.Clean(filename)] = imports
+}
+
+// WithPackageNames returns an index that knows the package clause names of
+// the given import paths, instead of guessing them from the last element
+// of the path.
+func (ii *ImportIndex) WithPackageNames(names map[string]string) *ImportIndex {
+	return &ImportIndex{files: ii.files, names: names}
+}
+
+func (ii *ImportIndex) localName(imp GoImport) string {
+	if imp.Name == "" {
+		if path, err := strconv.Unquote(imp.Path); err == nil {
+			if name, ok := ii.names[path]; ok {
+				return name
+			}
+		}
+	}
+	return importLocalName(imp)
+}
+
+func (ii *ImportIndex) Merge(other *ImportIndex) {
+	for filename, imports := range other.files {
+		ii.files[filename] = imports
+	}
+}
+
+// ImportsFor returns the import specs of the file at position that are
+// referenced by the given type strings, e.g. `"io"` or `pb "example.com/pb"`.
+func (ii *ImportIndex) ImportsFor(position string, typeStrs []string) []string {
+	specs := make([]string, 0)
+	for _, imp := range ii.referencedImports(position, typeStrs) {
+		if imp.Name != "" {
+			specs = append(specs, fmt.Sprintf("%s %s", imp.Name, imp.Path))
+		} else {
+			specs = append(specs, imp.Path)
+		}
+	}
+	sortImportSpecs(specs)
+	return specs
+}
+
+// ImportPathsFor is like ImportsFor but returns unquoted import paths.
+func (ii *ImportIndex) ImportPathsFor(position string, typeStrs []string) []string {
+	paths := make([]string, 0)
+	for _, imp := range ii.referencedImports(position, typeStrs) {
+		if path, err := strconv.Unquote(imp.Path); err == nil {
+			paths = appendUnique(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (ii *ImportIndex) referencedImports(position string, typeStrs []string) []GoImport {
+	qualifiers := make(map[string]bool)
+	for _, typeStr := range typeStrs {
+		for _, match := range qualifierPattern.FindAllStringSubmatch(typeStr, -1) {
+			qualifiers[match[1]] = true
+		}
+	}
+
+	imports := make([]GoImport, 0)
+	for _, imp := range ii.files[positionFile(position)] {
+		if qualifiers[ii.localName(imp)] {
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
+
+// sortImportSpecs orders specs by import path, ignoring any alias.
+func sortImportSpecs(specs []string) {
+	sort.Slice(specs, func(i, j int) bool {
+		fi, fj := strings.Fields(specs[i]), strings.Fields(specs[j])
+		return fi[len(fi)-1] < fj[len(fj)-1]
+	})
+}
+
+func (ii *ImportIndex) InterfaceImports(item GoInterface) []string {
+	return ii.ImportsFor(item.Position, item.Methods)
+}
+
+func (ii *ImportIndex) StructImports(item GoStruct) []string {
+	return ii.ImportsFor(item.Position, append(append([]string{}, item.Fields...), item.Methods...))
+}
+
+var qualifierPattern = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\.[A-Za-z_]`)
+
+var majorVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+func importLocalName(imp GoImport) string {
+	if imp.Name != "" {
+		return imp.Name
+	}
+
+	path, err := strconv.Unquote(imp.Path)
+	if err != nil {
+		path = strings.Trim(imp.Path, `"`)
+	}
+
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	if majorVersionPattern.MatchString(name) && len(parts) > 1 {
+		name = parts[len(parts)-2]
+	}
+	return name
+}
+
+// positionFile strips the line and column from a token.Position string.
+func positionFile(position string) string {
+	for i := 0; i < 2; i++ {
+		idx := strings.LastIndex(position, ":")
+		if idx < 0 {
+			break
+		}
+		if _, err := strconv.Atoi(position[idx+1:]); err != nil {
+			break
+		}
+		position = position[:idx]
+	}
+	return filepath.Clean(position)
+}
+
+// positionLine returns the line of a token.Position string, or 0.
+func positionLine(position string) int {
+	parts := strings.Split(position, ":")
+	if len(parts) < 3 {
+		return 0
+	}
+	line, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return 0
+	}
+	return line
+}
+
+func receiverName(typeName string) string {
+	typeName = strings.TrimLeft(typeName, "*")
+	if idx := strings.Index(typeName, "["); idx >= 0 {
+		typeName = typeName[:idx]
+	}
+	if idx := strings.LastIndex(typeName, "."); idx >= 0 {
+		typeName = typeName[idx+1:]
+	}
+
+	var builder strings.Builder
+	for _, r := range typeName {
+		if unicode.IsUpper(r) {
+			builder.WriteRune(unicode.ToLower(r))
+		}
+	}
+	if builder.Len() == 0 && typeName != "" {
+		builder.WriteRune(unicode.ToLower([]rune(typeName)[0]))
+	}
+	return builder.String()
+}
+
+// typeParamList is the type parameter list of a declaration, e.g.
+// "[K comparable, V any]", or "" without type parameters.
+func typeParamList(typeParams []string) string {
+	if len(typeParams) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(typeParams, ", ") + "]"
+}
+
+// typeArgList instantiates a generic type with its own type parameters,
+// e.g. "[K, V]" for a receiver.
+func typeArgList(typeParams []string) string {
+	if len(typeParams) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(typeParams))
+	for _, param := range typeParams {
+		names = append(names, strings.Fields(param)[0])
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func parseTemplateMethod(signature string) TemplateMethod {
+	name, params, returns := parseMethodSignature(signature)
+	method := TemplateMethod{
+		Name:        name,
+		Params:      params,
+		Returns:     returns,
+		ZeroValues:  generateZeroValues(returns),
+		NamedParams: params,
+		ArgNames:    make([]string, 0),
+	}
+	nameParams(&method)
+	return method
+}
+
+// nameParams fills in NamedParams, Args and ArgNames, and ZeroValues from
+// the parsed result types. Types are copied from the signature as written.
+func nameParams(method *TemplateMethod) {
+	src := "func(" + method.Params + ") " + method.Returns
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		return
+	}
+	funcType, ok := expr.(*ast.FuncType)
+	if !ok {
+		return
+	}
+	source := func(node ast.Node) string {
+		start, end := fset.Position(node.Pos()).Offset, fset.Position(node.End()).Offset
+		return src[start:end]
+	}
+
+	params := make([]string, 0)
+	args := make([]string, 0)
+	for _, field := range funcType.Params.List {
+		names := make([]string, 0, len(field.Names))
+		for _, ident := range field.Names {
+			names = append(names, ident.Name)
+		}
+		if len(names) == 0 {
+			names = append(names, "_")
+		}
+		for i, name := range names {
+			if name == "_" {
+				names[i] = fmt.Sprintf("p%d", len(method.ArgNames))
+			}
+			method.ArgNames = append(method.ArgNames, names[i])
+			args = append(args, names[i])
+		}
+		params = append(params, strings.Join(names, ", ")+" "+source(field.Type))
+		if _, variadic := field.Type.(*ast.Ellipsis); variadic {
+			args[len(args)-1] += "..."
+		}
+	}
+	method.NamedParams = strings.Join(params, ", ")
+	method.Args = strings.Join(args, ", ")
+
+	if funcType.Results != nil {
+		zeroValues := make([]string, 0)
+		for _, field := range funcType.Results.List {
+			zero := templateZeroValue(source(field.Type))
+			for i := 0; i < len(field.Names) || i == 0; i++ {
+				zeroValues = append(zeroValues, zero)
+			}
+		}
+		method.ZeroValues = strings.Join(zeroValues, ", ")
+	}
+}
+
+type TemplateSet struct {
+	Name string
+	Path string
+	tmpl *template.Template
+
+	// packageSuffix is appended to the source package name to name the
+	// package of output written to another directory, e.g. storemock.
+	packageSuffix string
+}
+
+// LoadTemplates parses a single template file or every *.tmpl file in a
+// directory. The imports helper resolves against index, which is filled in
+// while the sources are analyzed.
+func LoadTemplates(path string, index *ImportIndex) ([]*TemplateSet, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		paths, err = filepath.Glob(filepath.Join(path, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no *.tmpl files in %s", path)
+		}
+		sort.Strings(paths)
+	}
+
+	sets := make([]*TemplateSet, 0, len(paths))
+	for _, p := range paths {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		tmpl, err := template.New(name).Funcs(templateFuncs(index)).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %v", p, err)
+		}
+
+		sets = append(sets, &TemplateSet{Name: name, Path: p, tmpl: tmpl})
+	}
+	return sets, nil
+}
+
+// builtinTemplates are the templates of "astro gen mock" and "astro gen
+// fake". Both give every method a func field named after it.
+var builtinTemplates = map[string]string{
+	"mock": `{{define "interface"}}{{$mock := printf "Mock%s" .Name}}{{$args := typeArgs .TypeParams}}
+// {{$mock}} is a mock of {{.Name}}. Each method calls the field named after
+// it with a Func suffix and panics when that is nil.
+type {{$mock}}{{typeParams .TypeParams}} struct {
+{{- range .Methods}}{{with method .}}{{if .Name}}
+	{{.Name}}Func func({{.NamedParams}}){{if .Returns}} {{.Returns}}{{end}}
+{{- end}}{{end}}{{end}}
+}
+{{range .Methods}}{{with method .}}{{if .Name}}
+func (mock *{{$mock}}{{$args}}) {{.Name}}({{.NamedParams}}){{if .Returns}} {{.Returns}}{{end}} {
+	if mock.{{.Name}}Func == nil {
+		panic("{{$mock}}.{{.Name}} called unexpectedly")
+	}
+	{{if .Returns}}return {{end}}mock.{{.Name}}Func({{.Args}})
+}
+{{end}}{{end}}{{end}}{{end}}`,
+
+	"fake": `{{define "header"}}package {{.Package}}
+
+import (
+{{- range .Imports}}{{if ne . "\"sync\""}}
+	{{.}}
+{{- end}}{{end}}
+	"sync"
+)
+{{end}}{{define "interface"}}{{$fake := printf "Fake%s" .Name}}{{$args := typeArgs .TypeParams}}
+// {{$fake}} is a fake {{.Name}} recording its calls. Each method returns
+// what the field named after it with a Func suffix returns, or zero values
+// when that is nil.
+type {{$fake}}{{typeParams .TypeParams}} struct {
+{{- range .Methods}}{{with method .}}{{if .Name}}
+	{{.Name}}Func func({{.NamedParams}}){{if .Returns}} {{.Returns}}{{end}}
+{{- end}}{{end}}{{end}}
+
+	mu    sync.Mutex
+	calls []{{$fake}}Call
+}
+
+// {{$fake}}Call is a call of a {{$fake}} method.
+type {{$fake}}Call struct {
+	Method string
+	Args   []any
+}
+
+// Calls returns the calls made so far, in order.
+func (fake *{{$fake}}{{$args}}) Calls() []{{$fake}}Call {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]{{$fake}}Call(nil), fake.calls...)
+}
+
+// CallCount returns how often method was called.
+func (fake *{{$fake}}{{$args}}) CallCount(method string) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	count := 0
+	for _, call := range fake.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+func (fake *{{$fake}}{{$args}}) record(method string, args ...any) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls = append(fake.calls, {{$fake}}Call{Method: method, Args: args})
+}
+{{range .Methods}}{{with method .}}{{if .Name}}
+func (fake *{{$fake}}{{$args}}) {{.Name}}({{.NamedParams}}){{if .Returns}} {{.Returns}}{{end}} {
+	fake.record("{{.Name}}"{{range .ArgNames}}, {{.}}{{end}})
+	if fake.{{.Name}}Func != nil {
+		{{if .Returns}}return {{end}}fake.{{.Name}}Func({{.Args}})
+	{{- if not .Returns}}
+		return
+	{{- end}}
+	}
+	{{- if .Returns}}
+	return {{.ZeroValues}}
+	{{- end}}
+}
+{{end}}{{end}}{{end}}{{end}}`,
+}
+
+// BuiltinTemplate parses one of builtinTemplates.
+func BuiltinTemplate(name string, index *ImportIndex) (*TemplateSet, error) {
+	content, ok := builtinTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("no built-in template %q", name)
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs(index)).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built-in template %s: %v", name, err)
+	}
+	return &TemplateSet{Name: name, Path: name, tmpl: tmpl, packageSuffix: name}, nil
+}
+
+func templateFuncs(index *ImportIndex) template.FuncMap {
+	funcs := template.FuncMap{
+		"zeroValue":    getZeroValue,
+		"exported":     ast.IsExported,
+		"receiverName": receiverName,
+		"method":       parseTemplateMethod,
+		"join":         strings.Join,
+		"typeParams":   typeParamList,
+		"typeArgs":     typeArgList,
+		"imports": func(item any) []string {
+			switch v := item.(type) {
+			case GoInterface:
+				return index.InterfaceImports(v)
+			case GoStruct:
+				return index.StructImports(v)
+			default:
+				return []string{}
+			}
+		},
+	}
+	for name, fn := range outputNameFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// Kinds returns the item kinds the template produces code for. A template
+// without "interface" or "struct" blocks is executed for every interface.
+func (ts *TemplateSet) Kinds() []string {
+	kinds := make([]string, 0, 2)
+	for _, kind := range []string{TemplateKindInterface, TemplateKindStruct} {
+		if ts.tmpl.Lookup(kind) != nil {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		kinds = append(kinds, TemplateKindInterface)
+	}
+	return kinds
+}
+
+func (ts *TemplateSet) execute(kind string, data any) (string, error) {
+	tmpl := ts.tmpl.Lookup(kind)
+	if tmpl == nil {
+		tmpl = ts.tmpl
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %s: %v", ts.Path, err)
+	}
+	return buf.String(), nil
+}
+
+// TemplateCodeGenerator runs a user template against every item. Execution
+// errors are kept so the caller can fail after generation.
+type TemplateCodeGenerator[T any] struct {
+	set  *TemplateSet
+	kind string
+	err  error
+}
+
+func NewTemplateCodeGenerator[T any](set *TemplateSet, kind string) *TemplateCodeGenerator[T] {
+	return &TemplateCodeGenerator[T]{set: set, kind: kind}
+}
+
+func (tcg *TemplateCodeGenerator[T]) GenerateCode(item T) string {
+	code, err := tcg.set.execute(tcg.kind, item)
+	if err != nil {
+		if tcg.err == nil {
+			tcg.err = err
+		}
+		return ""
+	}
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+	return strings.TrimRight(code, "\n") + "\n"
+}
+
+func (tcg *TemplateCodeGenerator[T]) Err() error {
+	return tcg.err
+}
+
+type TemplateImplementationNamer[T any] struct {
+	set              *TemplateSet
+	typeNameProvider TypeNameProvider[T]
+}
+
+func (tin *TemplateImplementationNamer[T]) GetImplementationName(item T) string {
+	return tin.set.Name + tin.typeNameProvider.GetTypeName(item)
+}
+
+// TemplateFileRenderer adds the imports referenced by the items, or the
+// template's own "header" block, below the generated-code header. Files
+// whose items were qualified also import their source package.
+type TemplateFileRenderer[T any] struct {
+	set           *TemplateSet
+	importsFor    func(item T) []string
+	sourceImports map[string]string
+}
+
+func (tfr *TemplateFileRenderer[T]) RenderFile(file PlannedFile[T], snippets []string) (string, error) {
+	seen := make(map[string]bool)
+	imports := make([]string, 0)
+	if spec, ok := tfr.sourceImports[file.Path]; ok {
+		seen[spec] = true
+		imports = append(imports, spec)
+	}
+	for _, item := range file.Items {
+		for _, spec := range tfr.importsFor(item) {
+			if !seen[spec] {
+				seen[spec] = true
+				imports = append(imports, spec)
+			}
+		}
+	}
+	sortImportSpecs(imports)
+
+	var builder strings.Builder
+	builder.WriteString(generatedHeader + "\n\n")
+
+	if tfr.set.tmpl.Lookup(TemplateBlockHeader) != nil {
+		header, err := tfr.set.execute(TemplateBlockHeader, TemplateFile{
+			Package: file.Package,
+			Imports: imports,
+			Sources: file.Sources,
+		})
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(strings.TrimRight(header, "\n") + "\n\n")
+	} else {
+		builder.WriteString(fmt.Sprintf("package %s\n\n", file.Package))
+		if len(imports) > 0 {
+			builder.WriteString("import (\n")
+			for _, spec := range imports {
+				builder.WriteString("\t" + spec + "\n")
+			}
+			builder.WriteString(")\n\n")
+		}
+	}
+
+	builder.WriteString(strings.Join(snippets, "\n"))
+	return formatGenerated(builder.String())
+}
+
+func planTemplateOutput[T any](
+	set *TemplateSet,
+	kind string,
+	layout OutputLayout,
+	sources []SourceItems[T],
+	nameProvider TypeNameProvider[T],
+	sorter ItemSorter[T],
+	importsFor func(item T) []string,
+	qualify func(q TypeQualifier, item T) (T, bool),
+	resolver PackageResolver,
+	writer FileWriter,
+) (PlannedOutput, error) {
+	layout.Kind = kind
+	layout.Template = set.Name
+
+	planner, err := NewOutputPlanner(layout, defaultNameTemplate(layout.Mode, set.Name, kind+"s"), nameProvider, sorter)
+	if err != nil {
+		return PlannedOutput{}, err
+	}
+	files, err := planner.Plan(sources)
+	if err != nil {
+		return PlannedOutput{}, err
+	}
+
+	// Items generated into another directory refer to the types of their
+	// own package through an import of it. The planner puts the items of
+	// one directory in each file.
+	packages := make(map[string]string)
+	for _, src := range sources {
+		packages[src.File] = src.Package
+	}
+	outputPackages := make(map[string]map[string]bool)
+	for _, file := range files {
+		dir := absolutePath(filepath.Dir(file.Path))
+		if outputPackages[dir] == nil {
+			outputPackages[dir] = make(map[string]bool)
+		}
+		outputPackages[dir][packages[file.Sources[0]]] = true
+	}
+	sourceImports := make(map[string]string)
+	for i := range files {
+		file := &files[i]
+		srcDir := filepath.Dir(file.Sources[0])
+		if absolutePath(srcDir) == absolutePath(filepath.Dir(file.Path)) {
+			continue
+		}
+		pkgName := packages[file.Sources[0]]
+		if layout.PackageName == "" {
+			file.Package = outputPackageName(filepath.Dir(file.Path), pkgName+set.packageSuffix, len(outputPackages[absolutePath(filepath.Dir(file.Path))]) > 1)
+		}
+		q := TypeQualifier{Name: pkgName, ImportPath: resolver.ImportPath(srcDir, pkgName)}
+		used := false
+		for j, item := range file.Items {
+			var qualified bool
+			file.Items[j], qualified = qualify(q, item)
+			used = used || qualified
+		}
+		if used {
+			sourceImports[file.Path] = q.ImportSpec()
+		}
+	}
+
+	codeGen := NewTemplateCodeGenerator[T](set, kind)
+	generator := NewGenericCodeGenerator[T](
+		codeGen,
+		&TemplateImplementationNamer[T]{set: set, typeNameProvider: nameProvider},
+		writer,
+	)
+
+	renderer := &TemplateFileRenderer[T]{set: set, importsFor: importsFor, sourceImports: sourceImports}
+	return PlannedOutput{
+		Label: fmt.Sprintf("%s (%s)", set.Path, kind),
+		Paths: plannedPaths(files),
+		Write: func() ([]string, error) {
+			written, err := writePlannedFiles(files, generator, renderer)
+			if err == nil {
+				err = codeGen.Err()
+			}
+			return written, err
+		},
+	}, nil
+}
+
+// outputPackageName names the package of output written to dir when no
+// -package is given: name, unless the output of several source packages
+// shares dir, which is then a package named after dir.
+func outputPackageName(dir, name string, shared bool) string {
+	if !shared {
+		return name
+	}
+	var builder strings.Builder
+	for _, r := range strings.ToLower(filepath.Base(absolutePath(dir))) {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		}
+	}
+	if !token.IsIdentifier(builder.String()) {
+		return name
+	}
+	return builder.String()
+}
+
+// TemplateOutputs plans the files of template sets for the items of an
+// analysis, once or, in watch mode, after every change.
+type TemplateOutputs struct {
+	sets               []*TemplateSet
+	layout             OutputLayout
+	useTopologicalSort bool
+	resolver           PackageResolver
+}
+
+func NewTemplateOutputs(sets []*TemplateSet, layout OutputLayout, useTopologicalSort bool, resolver PackageResolver) *TemplateOutputs {
+	return &TemplateOutputs{sets: sets, layout: layout, useTopologicalSort: useTopologicalSort, resolver: resolver}
+}
+
+// Plan plans the output of every kind of every set. The templates render
+// imports from opts.Imports, the index they were loaded with.
+func (to *TemplateOutputs) Plan(opts AnalysisOptions, writer FileWriter) ([]PlannedOutput, error) {
+	outputs := make([]PlannedOutput, 0)
+	for _, set := range to.sets {
+		for _, kind := range set.Kinds() {
+			var output PlannedOutput
+			var err error
+			switch kind {
+			case TemplateKindInterface:
+				output, err = planTemplateOutput[GoInterface](
+					set, kind, to.layout,
+					opts.InterfaceSources.CollectResults(),
+					&InterfaceTypeNameProvider{},
+					newItemSorter[GoInterface](&InterfaceDependencyExtractor{}, &InterfaceTypeNameProvider{}, to.useTopologicalSort),
+					opts.Imports.InterfaceImports,
+					TypeQualifier.Interface,
+					to.resolver,
+					writer,
+				)
+			case TemplateKindStruct:
+				output, err = planTemplateOutput[GoStruct](
+					set, kind, to.layout,
+					opts.StructSources.CollectResults(),
+					&StructTypeNameProvider{},
+					newItemSorter[GoStruct](&StructDependencyExtractor{}, &StructTypeNameProvider{}, to.useTopologicalSort),
+					opts.Imports.StructImports,
+					TypeQualifier.Struct,
+					to.resolver,
+					writer,
+				)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("planning template %s: %w", set.Path, err)
+			}
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinMockInAnotherDirectory(t *testing.T) {
+	opts := AnalysisOptions{
+		InterfaceSources: NewSourceItemsCollector[GoInterface](),
+		Imports:          NewImportIndex(),
+	}
+	opts.InterfaceSources.AddResult(SourceItems[GoInterface]{
+		File: "store/store.go", Dir: "store", Package: "store",
+		Items: []GoInterface{{Name: "Store", TypeParams: []string{"T any"}, Methods: []string{"Get(id ID) (T, error)"}}},
+	})
+	opts.InterfaceSources.AddResult(SourceItems[GoInterface]{
+		File: "cache/cache.go", Dir: "cache", Package: "cache",
+		Items: []GoInterface{{Name: "Cache", Methods: []string{"Len() int"}}},
+	})
+
+	tests := []struct {
+		name     string
+		layout   OutputLayout
+		packages map[string]string // output file relative to mocks, package clause
+	}{
+		{
+			name:     "shared directory",
+			layout:   OutputLayout{Mode: LayoutPerFile},
+			packages: map[string]string{"mock_store_interfaces.go": "mocks", "mock_cache_interfaces.go": "mocks"},
+		},
+		{
+			name:     "mirrored",
+			layout:   OutputLayout{Mode: LayoutPerFile, Mirror: true},
+			packages: map[string]string{"store/mock_store_interfaces.go": "storemock", "cache/mock_cache_interfaces.go": "cachemock"},
+		},
+		{
+			name:     "explicit package",
+			layout:   OutputLayout{Mode: LayoutPerFile, Mirror: true, PackageName: "fakes"},
+			packages: map[string]string{"store/mock_store_interfaces.go": "fakes", "cache/mock_cache_interfaces.go": "fakes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := BuiltinTemplate("mock", opts.Imports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.layout.Root = "mocks"
+			writer := NewMemoryFileWriter()
+			outputs, err := NewTemplateOutputs([]*TemplateSet{set}, tt.layout, false, NewSuffixPackageResolver()).Plan(opts, writer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, output := range outputs {
+				if _, err := output.Write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for name, pkg := range tt.packages {
+				path := filepath.Join("mocks", filepath.FromSlash(name))
+				content, ok := writer.Files()[path]
+				if !ok {
+					t.Fatalf("%s not written, got %v", name, writer.Files())
+				}
+				file, err := parser.ParseFile(token.NewFileSet(), path, content, 0)
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", name, err, content)
+				}
+				if file.Name.Name != pkg {
+					t.Errorf("%s: package %s, want %s", name, file.Name.Name, pkg)
+				}
+				if formatted, err := format.Source([]byte(content)); err != nil || string(formatted) != content {
+					t.Errorf("%s is not gofmt'ed:\n%s", name, content)
+				}
+				if !strings.Contains(name, "store") {
+					continue
+				}
+				for _, want := range []string{
+					"type MockStore[T any] struct",
+					"GetFunc func(id store.ID) (T, error)",
+					"func (mock *MockStore[T]) Get(id store.ID) (T, error) {",
+				} {
+					if !strings.Contains(content, want) {
+						t.Errorf("%s does not contain %q:\n%s", name, want, content)
+					}
+				}
+			}
+		})
+	}
+}
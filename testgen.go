@@ -0,0 +1,565 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const fakesTestFile = "noop_fakes_test.go"
+
+// splitParam splits a parameter or result string from GoFunction into its
+// name and type. Unnamed entries return an empty name.
+func splitParam(param string) (name, typ string) {
+	fields := strings.SplitN(param, " ", 2)
+	if len(fields) == 2 && isValidIdentifier(fields[0]) {
+		switch fields[0] {
+		case "func", "chan", "map", "struct", "interface":
+		default:
+			return fields[0], fields[1]
+		}
+	}
+	return "", param
+}
+
+type testField struct {
+	Name     string
+	Type     string
+	Variadic bool
+}
+
+func testFields(params []string, prefix string) []testField {
+	fields := make([]testField, 0, len(params))
+	for i, param := range params {
+		name, typ := splitParam(param)
+		if name == "" || name == "_" {
+			name = fmt.Sprintf("%s%d", prefix, i)
+		}
+
+		field := testField{Name: name, Type: typ}
+		if strings.HasPrefix(typ, "...") {
+			field.Type = "[]" + strings.TrimPrefix(typ, "...")
+			field.Variadic = true
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// ReceiverBuilder writes the statement that constructs a method receiver
+// in a scaffold. Receivers are built from the struct declaration, with
+// interface-typed fields filled by NoOp fakes: those of the same package
+// by name, those of other analyzed packages through resolve. It remembers
+// which fakes were used so they can be generated afterwards.
+type ReceiverBuilder struct {
+	structs    map[string]GoStruct
+	interfaces map[string]GoInterface
+	resolve    func(position, typ string) (string, *GoInterface)
+	fakes      map[string]bool
+	imported   map[string]importedFake
+}
+
+// importedFake is the interface of another package a fake implements,
+// renamed after its package and with qualified types, and the interface
+// as declared.
+type importedFake struct {
+	iface     GoInterface
+	source    GoInterface
+	qualifier TypeQualifier
+}
+
+// NewReceiverBuilder returns a builder for the structs and interfaces of
+// one package. resolve returns the import path of the analyzed package a
+// qualified type in the file at position refers to, and the interface if
+// the type is one; it may be nil.
+func NewReceiverBuilder(structs map[string]GoStruct, interfaces map[string]GoInterface, resolve func(position, typ string) (string, *GoInterface)) *ReceiverBuilder {
+	return &ReceiverBuilder{
+		structs:    structs,
+		interfaces: interfaces,
+		resolve:    resolve,
+		fakes:      make(map[string]bool),
+		imported:   make(map[string]importedFake),
+	}
+}
+
+// Fakes returns the interfaces of the package that generated receivers
+// depend on.
+func (rb *ReceiverBuilder) Fakes() []string {
+	names := make([]string, 0, len(rb.fakes))
+	for name := range rb.fakes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ImportedFakes returns the interfaces of other packages that generated
+// receivers depend on, by fake name.
+func (rb *ReceiverBuilder) ImportedFakes() []importedFake {
+	fakes := make([]importedFake, 0, len(rb.imported))
+	for _, fake := range rb.imported {
+		fakes = append(fakes, fake)
+	}
+	sort.Slice(fakes, func(i, j int) bool {
+		return fakes[i].iface.Name < fakes[j].iface.Name
+	})
+	return fakes
+}
+
+func (rb *ReceiverBuilder) Build(item GoFunction, indent string) string {
+	receiverType := receiverBaseName(item.Receiver)
+	pointer := strings.HasPrefix(item.Receiver, "*")
+
+	st, ok := rb.structs[receiverType]
+	if !ok {
+		if pointer {
+			return fmt.Sprintf("%sreceiver := new(%s)\n", indent, receiverType)
+		}
+		return fmt.Sprintf("%svar receiver %s\n", indent, receiverType)
+	}
+
+	var builder strings.Builder
+	inits := make([]string, 0)
+	for _, field := range st.Fields {
+		name, typ := splitParam(field)
+		if name == "" {
+			// Embedded field: the field name is the unqualified type name
+			name = strings.TrimPrefix(typ, "*")
+			name = name[strings.LastIndex(name, ".")+1:]
+		}
+		if _, isInterface := rb.interfaces[typ]; isInterface {
+			rb.fakes[typ] = true
+			inits = append(inits, fmt.Sprintf("%s: NewNoOp%s(0)", name, typ))
+			continue
+		}
+
+		qualifier, typeName, qualified := strings.Cut(typ, ".")
+		if !qualified || !isValidIdentifier(qualifier) || !isValidIdentifier(typeName) {
+			continue
+		}
+		path, iface := "", (*GoInterface)(nil)
+		if rb.resolve != nil {
+			path, iface = rb.resolve(st.Position, typ)
+		}
+		if iface != nil {
+			if fake, ok := newImportedFake(*iface, TypeQualifier{Name: qualifier, ImportPath: path}); ok {
+				rb.imported[fake.iface.Name] = fake
+				inits = append(inits, fmt.Sprintf("%s: NewNoOp%s(0)", name, fake.iface.Name))
+				continue
+			}
+		}
+		// Types of packages that weren't analyzed may be interfaces too
+		if iface != nil || path == "" {
+			builder.WriteString(fmt.Sprintf("%s// TODO: provide %s\n", indent, typ))
+		}
+	}
+
+	literal := fmt.Sprintf("%s{%s}", receiverType, strings.Join(inits, ", "))
+	if pointer {
+		literal = "&" + literal
+	}
+	builder.WriteString(fmt.Sprintf("%sreceiver := %s\n", indent, literal))
+	return builder.String()
+}
+
+// newImportedFake qualifies iface for a fake in another package. Generic
+// interfaces and those with unexported methods or types can't be faked
+// there.
+func newImportedFake(iface GoInterface, qualifier TypeQualifier) (importedFake, bool) {
+	if len(iface.TypeParams) > 0 {
+		return importedFake{}, false
+	}
+	for _, method := range iface.Methods {
+		name, _, _ := parseMethodSignature(method)
+		if !strings.Contains(method, "(") || !ast.IsExported(name) {
+			return importedFake{}, false
+		}
+	}
+
+	qualified, _ := qualifier.Interface(iface)
+	unexported := regexp.MustCompile(`\b` + regexp.QuoteMeta(qualifier.Name) + `\.[a-z_]`)
+	for _, method := range qualified.Methods {
+		if unexported.MatchString(method) {
+			return importedFake{}, false
+		}
+	}
+	qualified.Name = strings.ToUpper(qualifier.Name[:1]) + qualifier.Name[1:] + iface.Name
+	return importedFake{iface: qualified, source: iface, qualifier: qualifier}, true
+}
+
+// scaffoldable reports whether a test or fuzz target is generated for fn:
+// exported functions and exported methods of non-generic exported types.
+func scaffoldable(fn GoFunction) bool {
+	if fn.Name == "" || !ast.IsExported(fn.Name) {
+		return false
+	}
+	if fn.Receiver == "" {
+		return true
+	}
+	return ast.IsExported(receiverBaseName(fn.Receiver)) && !strings.Contains(fn.Receiver, "[")
+}
+
+// TestScaffoldCodeGenerator renders a table-driven test for a function or
+// method.
+type TestScaffoldCodeGenerator struct {
+	receivers *ReceiverBuilder
+}
+
+func NewTestScaffoldCodeGenerator(receivers *ReceiverBuilder) *TestScaffoldCodeGenerator {
+	return &TestScaffoldCodeGenerator{receivers: receivers}
+}
+
+func (tscg *TestScaffoldCodeGenerator) GenerateCode(item GoFunction) string {
+	if !scaffoldable(item) {
+		return ""
+	}
+
+	receiverType := receiverBaseName(item.Receiver)
+
+	testName := "Test" + item.Name
+	callName := item.Name
+	if item.Receiver != "" {
+		testName = fmt.Sprintf("Test%s_%s", receiverType, item.Name)
+		callName = fmt.Sprintf("%s.%s", receiverType, item.Name)
+	}
+
+	args := testFields(item.Parameters, "arg")
+	returns := testFields(item.Returns, "want")
+
+	hasErr := len(returns) > 0 && returns[len(returns)-1].Type == "error"
+	wants := returns
+	if hasErr {
+		wants = returns[:len(returns)-1]
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("func %s(t *testing.T) {\n", testName))
+
+	if len(args) > 0 {
+		builder.WriteString("\ttype args struct {\n")
+		for _, arg := range args {
+			builder.WriteString(fmt.Sprintf("\t\t%s %s\n", arg.Name, arg.Type))
+		}
+		builder.WriteString("\t}\n")
+	}
+	if len(wants) > 0 {
+		builder.WriteString("\ttype want struct {\n")
+		for _, want := range wants {
+			builder.WriteString(fmt.Sprintf("\t\t%s %s\n", want.Name, want.Type))
+		}
+		builder.WriteString("\t}\n")
+	}
+
+	builder.WriteString("\ttests := []struct {\n")
+	builder.WriteString("\t\tname    string\n")
+	if len(args) > 0 {
+		builder.WriteString("\t\targs    args\n")
+	}
+	if len(wants) > 0 {
+		builder.WriteString("\t\twant    want\n")
+	}
+	if hasErr {
+		builder.WriteString("\t\twantErr bool\n")
+	}
+	builder.WriteString("\t}{\n")
+	builder.WriteString("\t\t// TODO: Add test cases.\n")
+	builder.WriteString("\t}\n")
+
+	builder.WriteString("\tfor _, tt := range tests {\n")
+	builder.WriteString("\t\tt.Run(tt.name, func(t *testing.T) {\n")
+
+	call := item.Name
+	if item.Receiver != "" {
+		builder.WriteString(tscg.receivers.Build(item, "\t\t\t"))
+		call = "receiver." + item.Name
+	}
+
+	callArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		expr := "tt.args." + arg.Name
+		if arg.Variadic {
+			expr += "..."
+		}
+		callArgs = append(callArgs, expr)
+	}
+
+	results := make([]string, 0, len(returns))
+	for i := range wants {
+		results = append(results, fmt.Sprintf("got%d", i))
+	}
+	if hasErr {
+		results = append(results, "err")
+	}
+
+	callExpr := fmt.Sprintf("%s(%s)", call, strings.Join(callArgs, ", "))
+	if len(results) > 0 {
+		builder.WriteString(fmt.Sprintf("\t\t\t%s := %s\n", strings.Join(results, ", "), callExpr))
+	} else {
+		builder.WriteString(fmt.Sprintf("\t\t\t%s\n", callExpr))
+	}
+
+	if hasErr {
+		builder.WriteString("\t\t\tif (err != nil) != tt.wantErr {\n")
+		builder.WriteString(fmt.Sprintf("\t\t\t\tt.Fatalf(\"%s() error = %%v, wantErr %%v\", err, tt.wantErr)\n", callName))
+		builder.WriteString("\t\t\t}\n")
+	}
+	for i, want := range wants {
+		builder.WriteString(fmt.Sprintf("\t\t\tif !reflect.DeepEqual(got%d, tt.want.%s) {\n", i, want.Name))
+		builder.WriteString(fmt.Sprintf("\t\t\t\tt.Errorf(\"%s() %s = %%v, want %%v\", got%d, tt.want.%s)\n", callName, want.Name, i, want.Name))
+		builder.WriteString("\t\t\t}\n")
+	}
+
+	builder.WriteString("\t\t})\n")
+	builder.WriteString("\t}\n")
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+type TestScaffoldNamer struct{}
+
+func (tsn *TestScaffoldNamer) GetImplementationName(item GoFunction) string {
+	if item.Receiver != "" {
+		return fmt.Sprintf("Test%s_%s", receiverBaseName(item.Receiver), item.Name)
+	}
+	return "Test" + item.Name
+}
+
+// ScaffoldIndex groups the analyzed structs and interfaces by package
+// directory and keeps one ReceiverBuilder per package, so test and fuzz
+// scaffolds of a run share a single set of fakes.
+type ScaffoldIndex struct {
+	structs    map[string]map[string]GoStruct
+	interfaces map[string]map[string]GoInterface
+	receivers  map[string]*ReceiverBuilder
+	packages   map[string]string
+	dirs       map[string]string
+	imports    *ImportIndex
+}
+
+func NewScaffoldIndex(opts AnalysisOptions, resolver PackageResolver) *ScaffoldIndex {
+	si := &ScaffoldIndex{
+		structs:    make(map[string]map[string]GoStruct),
+		interfaces: make(map[string]map[string]GoInterface),
+		receivers:  make(map[string]*ReceiverBuilder),
+		packages:   make(map[string]string),
+		dirs:       make(map[string]string),
+		imports:    opts.Imports,
+	}
+
+	for _, src := range opts.FunctionSources.CollectResults() {
+		si.dirs[resolver.ImportPath(src.Dir, src.Package)] = src.Dir
+	}
+
+	for _, src := range opts.StructSources.CollectResults() {
+		si.dirs[resolver.ImportPath(src.Dir, src.Package)] = src.Dir
+		if si.structs[src.Dir] == nil {
+			si.structs[src.Dir] = make(map[string]GoStruct)
+		}
+		for _, st := range src.Items {
+			si.structs[src.Dir][st.Name] = st
+		}
+	}
+
+	for _, src := range opts.InterfaceSources.CollectResults() {
+		si.dirs[resolver.ImportPath(src.Dir, src.Package)] = src.Dir
+		if si.interfaces[src.Dir] == nil {
+			si.interfaces[src.Dir] = make(map[string]GoInterface)
+		}
+		for _, iface := range src.Items {
+			si.interfaces[src.Dir][iface.Name] = iface
+		}
+	}
+
+	return si
+}
+
+func (si *ScaffoldIndex) Receivers(src SourceItems[GoFunction]) *ReceiverBuilder {
+	receivers, ok := si.receivers[src.Dir]
+	if !ok {
+		receivers = NewReceiverBuilder(si.structs[src.Dir], si.interfaces[src.Dir], si.resolveImported)
+		si.receivers[src.Dir] = receivers
+		si.packages[src.Dir] = src.Package
+	}
+	return receivers
+}
+
+// resolveImported returns the import path of the analyzed package typ, a
+// qualified type name in the file at position, refers to, and the
+// interface if it is one of the package's.
+func (si *ScaffoldIndex) resolveImported(position, typ string) (string, *GoInterface) {
+	if si.imports == nil {
+		return "", nil
+	}
+	_, name, _ := strings.Cut(typ, ".")
+	for _, path := range si.imports.ImportPathsFor(position, []string{typ}) {
+		dir, ok := si.dirs[path]
+		if !ok {
+			continue
+		}
+		if iface, ok := si.interfaces[dir][name]; ok {
+			return path, &iface
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+func (si *ScaffoldIndex) Struct(dir, name string) (GoStruct, bool) {
+	st, ok := si.structs[dir][name]
+	return st, ok
+}
+
+// WriteFakes writes NoOp fakes for every interface a generated receiver
+// used to noop_fakes_test.go in the package. The file is generated and
+// only rewritten when the fakes change; once its generated-code header is
+// removed it belongs to the user and is left alone.
+func (si *ScaffoldIndex) WriteFakes(imports *ImportIndex, writer FileWriter, out io.Writer) ([]string, error) {
+	dirs := make([]string, 0, len(si.receivers))
+	for dir := range si.receivers {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	written := make([]string, 0)
+	for _, dir := range dirs {
+		fakes, imported := si.receivers[dir].Fakes(), si.receivers[dir].ImportedFakes()
+		if len(fakes)+len(imported) == 0 {
+			continue
+		}
+
+		noOpGen := &InterfaceNoOpCodeGenerator{}
+		snippets := make([]string, 0, len(fakes)+len(imported))
+		specs := make([]string, 0)
+		for _, name := range fakes {
+			iface := si.interfaces[dir][name]
+			snippets = append(snippets, noOpGen.GenerateCode(iface))
+			specs = append(specs, imports.InterfaceImports(iface)...)
+		}
+		for _, fake := range imported {
+			snippets = append(snippets, noOpGen.GenerateCode(fake.iface))
+			specs = append(specs, imports.InterfaceImports(fake.source)...)
+			specs = append(specs, fake.qualifier.ImportSpec())
+		}
+
+		fakesFile := filepath.Join(dir, fakesTestFile)
+		content := generatedHeader + "\n\n" + renderTestFile(si.packages[dir], specs, snippets)
+		if existing, err := os.ReadFile(fakesFile); err == nil {
+			if !strings.HasPrefix(string(existing), generatedHeader) {
+				fmt.Fprintf(out, "Skipped fakes for %s: %s has no generated-code header\n", dir, fakesFile)
+				continue
+			}
+			if string(existing) == content {
+				continue
+			}
+		}
+		if err := writer.WriteToFile(content, fakesFile); err != nil {
+			return written, fmt.Errorf("failed to write %s: %v", fakesFile, err)
+		}
+		written = append(written, fakesFile)
+	}
+	return written, nil
+}
+
+// sourceFunctions returns the functions of a non-test source file in
+// declaration order.
+func sourceFunctions(src SourceItems[GoFunction]) []GoFunction {
+	if strings.HasSuffix(src.File, "_test.go") {
+		return nil
+	}
+
+	functions := append([]GoFunction{}, src.Items...)
+	sort.SliceStable(functions, func(i, j int) bool {
+		return positionLine(functions[i].Position) < positionLine(functions[j].Position)
+	})
+	return functions
+}
+
+// generateTestScaffolds writes a <file>_test.go skeleton next to every
+// analyzed source file with exported functions. Existing test files are
+// never overwritten.
+func generateTestScaffolds(opts AnalysisOptions, index *ScaffoldIndex, writer FileWriter) ([]string, error) {
+	written := make([]string, 0)
+
+	for _, src := range opts.FunctionSources.CollectResults() {
+		// Declarations after a syntax error are missing
+		if opts.Diagnostics.HasErrors(src.File) {
+			fmt.Fprintf(opts.Output, "Skipped test scaffold for %s: the file has errors\n", src.File)
+			continue
+		}
+		functions := sourceFunctions(src)
+		generator := NewGenericCodeGenerator[GoFunction](
+			NewTestScaffoldCodeGenerator(index.Receivers(src)),
+			&TestScaffoldNamer{},
+			writer,
+		)
+
+		tests := make([]string, 0)
+		typeStrs := make([]string, 0)
+		for _, fn := range functions {
+			if code := generator.GenerateImplementation(fn); code != "" {
+				tests = append(tests, code)
+				typeStrs = append(typeStrs, fn.Parameters...)
+				typeStrs = append(typeStrs, fn.Returns...)
+			}
+		}
+		if len(tests) == 0 {
+			continue
+		}
+
+		testFile := strings.TrimSuffix(src.File, ".go") + "_test.go"
+		if _, err := os.Stat(testFile); err == nil {
+			fmt.Fprintf(opts.Output, "Skipped test scaffold for %s: %s already exists\n", src.File, testFile)
+			continue
+		}
+
+		imports := []string{`"testing"`}
+		if strings.Contains(strings.Join(tests, ""), "reflect.DeepEqual") {
+			imports = append(imports, `"reflect"`)
+		}
+		imports = append(imports, opts.Imports.ImportsFor(functions[0].Position, typeStrs)...)
+
+		content := renderTestFile(src.Package, imports, tests)
+		if err := generator.WriteToFile(content, testFile); err != nil {
+			return written, fmt.Errorf("failed to write %s: %v", testFile, err)
+		}
+		written = append(written, testFile)
+	}
+
+	return written, nil
+}
+
+func renderTestFile(pkg string, imports []string, snippets []string) string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(imports))
+	for _, spec := range imports {
+		if !seen[spec] {
+			seen[spec] = true
+			unique = append(unique, spec)
+		}
+	}
+	sortImportSpecs(unique)
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("package %s\n\n", pkg))
+	if len(unique) > 0 {
+		builder.WriteString("import (\n")
+		for _, spec := range unique {
+			builder.WriteString("\t" + spec + "\n")
+		}
+		builder.WriteString(")\n\n")
+	}
+	builder.WriteString(strings.Join(snippets, "\n"))
+
+	// Scaffolds are meant to be edited, so hand them over gofmt'ed
+	if formatted, err := format.Source([]byte(builder.String())); err == nil {
+		return string(formatted)
+	}
+	return builder.String()
+}
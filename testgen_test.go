@@ -0,0 +1,141 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scaffoldModule has a service whose fields are interfaces of its own
+// package, of another analyzed package and of the standard library.
+var scaffoldModule = map[string]string{
+	"go.mod": "module example.com/m\n",
+	"domain/domain.go": `package domain
+
+import "time"
+
+type ID string
+
+type User struct {
+	Name string
+}
+
+type Repo interface {
+	Find(id ID, at time.Time) (*User, error)
+}
+
+type Locked interface {
+	lock()
+}
+
+type Cache[T any] interface {
+	Get() T
+}
+`,
+	"service/service.go": `package service
+
+import (
+	"io"
+
+	store "example.com/m/domain"
+)
+
+type Store interface {
+	Save() error
+}
+
+type Service struct {
+	Repo   store.Repo
+	store  Store
+	locked store.Locked
+	user   store.User
+	out    io.Writer
+	store.Cache[string]
+}
+
+func (s *Service) Run(id store.ID) error { return nil }
+`,
+}
+
+func TestGenerateTestScaffoldFakes(t *testing.T) {
+	root := writeTestModule(t, scaffoldModule)
+	opts, resolver := analyzeTestModule(t, root, "structs", "interfaces", "functions")
+	index := NewScaffoldIndex(opts, resolver)
+	writer := NewMemoryFileWriter()
+	if _, err := generateTestScaffolds(opts, index, writer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.WriteFakes(opts.Imports, writer, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want []string
+		not  []string
+	}{
+		{
+			file: "service/service_test.go",
+			want: []string{
+				"// TODO: provide store.Locked\n",
+				"// TODO: provide io.Writer\n",
+				"receiver := &Service{Repo: NewNoOpStoreRepo(0), store: NewNoOpStore(0)}\n",
+			},
+			not: []string{"provide store.User", "provide store.Cache"},
+		},
+		{
+			file: "service/noop_fakes_test.go",
+			want: []string{
+				"\tstore \"example.com/m/domain\"\n",
+				"\t\"time\"\n",
+				"type NoOpStore struct",
+				"type NoOpStoreRepo struct",
+				"func (n *NoOpStoreRepo) Find(store.ID, time.Time) (*store.User, error) {",
+			},
+			not: []string{"NoOpStoreLocked", "NoOpStoreCache"},
+		},
+	}
+
+	files := writer.Files()
+	for _, tt := range tests {
+		content, ok := files[filepath.Join(root, filepath.FromSlash(tt.file))]
+		if !ok {
+			t.Errorf("%s not written", tt.file)
+			continue
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), tt.file, content, 0); err != nil {
+			t.Errorf("%s: %v\n%s", tt.file, err, content)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s does not contain %q:\n%s", tt.file, want, content)
+			}
+		}
+		for _, not := range tt.not {
+			if strings.Contains(content, not) {
+				t.Errorf("%s contains %q:\n%s", tt.file, not, content)
+			}
+		}
+	}
+}
+
+func TestReceiverBuilderEmbeddedFields(t *testing.T) {
+	receivers := NewReceiverBuilder(
+		map[string]GoStruct{"Service": {Name: "Service", Fields: []string{"Store", "*Other", "x.Remote"}}},
+		map[string]GoInterface{"Store": {Name: "Store"}},
+		func(position, typ string) (string, *GoInterface) {
+			return "example.com/x", &GoInterface{Name: "Remote", Methods: []string{"Call() error"}}
+		},
+	)
+
+	want := "\treceiver := Service{Store: NewNoOpStore(0), Remote: NewNoOpXRemote(0)}\n"
+	if got := receivers.Build(GoFunction{Name: "Run", Receiver: "Service"}, "\t"); got != want {
+		t.Errorf("Build() = %q, want %q", got, want)
+	}
+	if fakes := receivers.ImportedFakes(); len(fakes) != 1 || fakes[0].iface.Methods[0] != "Call() error" || fakes[0].qualifier.ImportSpec() != `"example.com/x"` {
+		t.Errorf("ImportedFakes() = %+v", fakes)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// typedPackage is one type-checked package: its files share a directory
+// and package clause. Errors counts the type errors found; the checked
+// package and info are as complete as they could be made.
+type typedPackage struct {
+	path    string
+	files   []*ast.File
+	level   int
+	checked *types.Package
+	info    *types.Info
+	errors  int
+}
+
+// analyzedImporter returns the packages checked in this run, so types of
+// analyzed packages are identical everywhere, and falls back to importing
+// everything else from source.
+type analyzedImporter struct {
+	graph    *ImportGraph
+	checked  map[string]*types.Package
+	fallback types.Importer
+}
+
+func (ai *analyzedImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := ai.checked[ai.graph.Resolve(path)]; ok {
+		return pkg, nil
+	}
+	return ai.fallback.Import(path)
+}
+
+// loadTypedPackages parses every package under dirs with function bodies,
+// on opts.Jobs goroutines, and type-checks them in import graph order,
+// dependencies first.
+func loadTypedPackages(ctx context.Context, dirs []string, graph *ImportGraph, opts AnalysisOptions, resolver PackageResolver) (*token.FileSet, []*typedPackage, error) {
+	fset := token.NewFileSet()
+	packages := make(map[string]*typedPackage)
+
+	paths := make([]string, 0)
+	for _, dir := range dirs {
+		files, err := analyzableFiles(dir, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		paths = append(paths, files...)
+	}
+
+	err := runOrdered(ctx, len(paths), opts.Jobs,
+		func(ctx context.Context, i int) (*ast.File, error) {
+			file, err := parser.ParseFile(fset, paths[i], nil, parser.ParseComments)
+			if err != nil {
+				if err := opts.Diagnostics.Report(paths[i], err); err != nil {
+					return nil, fmt.Errorf("failed to parse %s: %v", paths[i], err)
+				}
+				if !hasPackageClause(file) {
+					return nil, nil
+				}
+			}
+			return file, nil
+		},
+		func(file *ast.File) error {
+			if file == nil {
+				return nil
+			}
+			pkgDir := filepath.Dir(fset.Position(file.Package).Filename)
+			importPath := resolver.ImportPath(pkgDir, strings.TrimSuffix(file.Name.Name, "_test"))
+			if strings.HasSuffix(file.Name.Name, "_test") {
+				importPath += "_test"
+			}
+			pkg, ok := packages[importPath]
+			if !ok {
+				pkg = &typedPackage{path: importPath}
+				if node, found := graph.Package(importPath); found {
+					pkg.level = node.Level
+				} else {
+					// External test packages may import anything, check them last
+					pkg.level = 1 << 30
+				}
+				packages[importPath] = pkg
+			}
+			pkg.files = append(pkg.files, file)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ordered := make([]*typedPackage, 0, len(packages))
+	for _, pkg := range packages {
+		ordered = append(ordered, pkg)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].level != ordered[j].level {
+			return ordered[i].level < ordered[j].level
+		}
+		return ordered[i].path < ordered[j].path
+	})
+
+	imp := &analyzedImporter{
+		graph:    graph,
+		checked:  make(map[string]*types.Package),
+		fallback: importer.ForCompiler(fset, "source", nil),
+	}
+	for _, pkg := range ordered {
+		pkg.info = &types.Info{
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		}
+		// Type errors only leave some identifiers unresolved
+		conf := types.Config{Importer: imp, Error: func(error) { pkg.errors++ }}
+		pkg.checked, _ = conf.Check(pkg.path, fset, pkg.files, pkg.info)
+		imp.checked[pkg.path] = pkg.checked
+	}
+	return fset, ordered, nil
+}
+
+// namedTypes returns the package-level named types of the packages that
+// are not interfaces.
+func namedTypes(packages []*typedPackage) []*types.TypeName {
+	named := make([]*types.TypeName, 0)
+	for _, pkg := range packages {
+		scope := pkg.checked.Scope()
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !types.IsInterface(tn.Type()) {
+				named = append(named, tn)
+			}
+		}
+	}
+	return named
+}
+
+// implementsInterface reports whether typ or a pointer to it implements
+// iface. Generic types are never matched.
+func implementsInterface(typ types.Type, iface *types.Interface) (types.Type, bool) {
+	if named, ok := typ.(*types.Named); ok && named.TypeParams().Len() > 0 {
+		return nil, false
+	}
+	if types.Implements(typ, iface) {
+		return typ, true
+	}
+	if ptr := types.NewPointer(typ); types.Implements(ptr, iface) {
+		return ptr, true
+	}
+	return nil, false
+}
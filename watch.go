@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchedPackage holds what was collected from the files of one directory.
+type watchedPackage struct {
+	structs    []SourceItems[GoStruct]
+	interfaces []SourceItems[GoInterface]
+	functions  []SourceItems[GoFunction]
+	variables  []SourceItems[GoVariable]
+	constants  []SourceItems[GoConstant]
+}
+
+// Watcher polls the analyzed trees for changed .go files, re-analyzes the
+// directories they are in and reports how the architecture changed since
+// the previous run. With a NoOp planner or template outputs, generated
+// files whose content changes are rewritten and those no longer generated
+// are removed.
+type Watcher struct {
+	dirs      []string
+	opts      AnalysisOptions
+	resolver  PackageResolver
+	noOp      *OutputPlanner[GoInterface]
+	templates *TemplateOutputs
+	out       io.Writer
+	stamps    map[string]fileStamp
+	packages  map[string]*watchedPackage
+	snapshot  *ArchitectureSnapshot
+}
+
+func NewWatcher(dirs []string, base AnalysisOptions, resolver PackageResolver, noOp *OutputPlanner[GoInterface], templates *TemplateOutputs, out io.Writer) *Watcher {
+	opts := base
+	opts.SelectedTypes = map[string]bool{
+		"structs":    true,
+		"interfaces": true,
+		"functions":  true,
+		"variables":  true,
+		"constants":  true,
+	}
+	opts.GenNoOp = false
+	opts.FunctionFilter = nil
+	opts.Changes = nil
+	if templates == nil {
+		opts.Imports = nil
+	}
+	opts.Output = io.Discard
+
+	return &Watcher{
+		dirs:      dirs,
+		opts:      opts,
+		resolver:  resolver,
+		noOp:      noOp,
+		templates: templates,
+		out:       out,
+		packages:  make(map[string]*watchedPackage),
+	}
+}
+
+// Run analyzes everything once, then polls every interval until ctx is
+// cancelled. Errors of a round are reported and the next round retried.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) error {
+	if err := w.start(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "\nWatching %s for changes (interrupt to stop)\n", strings.Join(w.dirs, ", "))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := w.poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(w.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// start analyzes everything and records the state later rounds compare to.
+func (w *Watcher) start(ctx context.Context) error {
+	stamps, err := w.scan()
+	if err != nil {
+		return err
+	}
+	if err := w.analyze(ctx, w.dirs, w.opts.NonRecursive); err != nil {
+		return err
+	}
+	if w.snapshot, err = w.takeSnapshot(); err != nil {
+		return err
+	}
+	w.stamps = stamps
+	return nil
+}
+
+func (w *Watcher) scan() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, dir := range w.dirs {
+		files, err := analyzableFiles(dir, w.opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				// Removed since it was listed; the next scan settles it
+				continue
+			}
+			stamps[file] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return stamps, nil
+}
+
+func (w *Watcher) poll(ctx context.Context) error {
+	stamps, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	changed := changedFiles(w.stamps, stamps)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	dirs := make([]string, 0)
+	for _, file := range changed {
+		dirs = appendUnique(dirs, filepath.Dir(file))
+	}
+	for _, dir := range dirs {
+		delete(w.packages, dir)
+	}
+	// Each round reports the errors of the files it parsed
+	if w.opts.Diagnostics != nil {
+		w.opts.Diagnostics = NewDiagnostics(w.opts.Diagnostics.Strict())
+	}
+	if err := w.analyze(ctx, dirs, true); err != nil {
+		return err
+	}
+
+	snapshot, err := w.takeSnapshot()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "\n[%s] %d file(s) changed: %s\n", time.Now().Format("15:04:05"), len(changed), strings.Join(changed, ", "))
+	if err := WriteArchitectureDiff(w.out, DiffArchitecture("previous", "current", w.snapshot, snapshot), "text"); err != nil {
+		return err
+	}
+	if w.opts.Diagnostics != nil {
+		WriteDiagnostics(w.out, w.opts.Diagnostics.List())
+	}
+	w.snapshot = snapshot
+	// Only a completed round moves on, so a failed one is retried
+	w.stamps = stamps
+
+	return w.regenerate()
+}
+
+// changedFiles returns the files added, removed or modified between two
+// scans, sorted.
+func changedFiles(old, current map[string]fileStamp) []string {
+	changed := make([]string, 0)
+	for file, stamp := range current {
+		if previous, ok := old[file]; !ok || previous != stamp {
+			changed = append(changed, file)
+		}
+	}
+	for file := range old {
+		if _, ok := current[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// analyze walks dirs and replaces what was collected for the directories
+// of the files found.
+func (w *Watcher) analyze(ctx context.Context, dirs []string, nonRecursive bool) error {
+	opts := w.opts
+	opts.NonRecursive = nonRecursive
+	opts.StructSources = NewSourceItemsCollector[GoStruct]()
+	opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	opts.VariableSources = NewSourceItemsCollector[GoVariable]()
+	opts.ConstantSources = NewSourceItemsCollector[GoConstant]()
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		if err := walkDirectory(ctx, dir, opts); err != nil {
+			return err
+		}
+	}
+
+	pkg := func(dir string) *watchedPackage {
+		if w.packages[dir] == nil {
+			w.packages[dir] = &watchedPackage{}
+		}
+		return w.packages[dir]
+	}
+	for _, src := range opts.StructSources.CollectResults() {
+		pkg(src.Dir).structs = append(pkg(src.Dir).structs, src)
+	}
+	for _, src := range opts.InterfaceSources.CollectResults() {
+		pkg(src.Dir).interfaces = append(pkg(src.Dir).interfaces, src)
+	}
+	for _, src := range opts.FunctionSources.CollectResults() {
+		pkg(src.Dir).functions = append(pkg(src.Dir).functions, src)
+	}
+	for _, src := range opts.VariableSources.CollectResults() {
+		pkg(src.Dir).variables = append(pkg(src.Dir).variables, src)
+	}
+	for _, src := range opts.ConstantSources.CollectResults() {
+		pkg(src.Dir).constants = append(pkg(src.Dir).constants, src)
+	}
+	return nil
+}
+
+// collected returns options holding everything collected, in directory
+// order.
+func (w *Watcher) collected() AnalysisOptions {
+	opts := w.opts
+	opts.StructSources = NewSourceItemsCollector[GoStruct]()
+	opts.InterfaceSources = NewSourceItemsCollector[GoInterface]()
+	opts.FunctionSources = NewSourceItemsCollector[GoFunction]()
+	opts.VariableSources = NewSourceItemsCollector[GoVariable]()
+	opts.ConstantSources = NewSourceItemsCollector[GoConstant]()
+
+	dirs := make([]string, 0, len(w.packages))
+	for dir := range w.packages {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		pkg := w.packages[dir]
+		for _, src := range pkg.structs {
+			opts.StructSources.AddResult(src)
+		}
+		for _, src := range pkg.interfaces {
+			opts.InterfaceSources.AddResult(src)
+		}
+		for _, src := range pkg.functions {
+			opts.FunctionSources.AddResult(src)
+		}
+		for _, src := range pkg.variables {
+			opts.VariableSources.AddResult(src)
+		}
+		for _, src := range pkg.constants {
+			opts.ConstantSources.AddResult(src)
+		}
+	}
+	return opts
+}
+
+// takeSnapshot rebuilds the import graph, which only reads import clauses,
+// and snapshots the architecture.
+func (w *Watcher) takeSnapshot() (*ArchitectureSnapshot, error) {
+	opts := w.collected()
+	graph, err := BuildImportGraph(w.dirs, opts, w.resolver)
+	if err != nil {
+		return nil, err
+	}
+	opts.Graph = graph
+	return newArchitectureSnapshot(opts, w.resolver), nil
+}
+
+// regenerate plans the NoOp and template outputs for everything collected,
+// rewrites the files whose content changed and removes the generated files
+// of the output directories that are no longer planned.
+func (w *Watcher) regenerate() error {
+	if w.noOp == nil && w.templates == nil {
+		return nil
+	}
+	opts := w.collected()
+	linkStructMethods(opts.StructSources.CollectResults(), opts.FunctionSources.CollectResults())
+
+	writer := NewUpdatingFileWriter(&SimpleFileWriter{})
+	outputs := make([]PlannedOutput, 0)
+	if w.noOp != nil {
+		output, err := planOutput[GoInterface](
+			"noop",
+			w.noOp,
+			opts.InterfaceSources.CollectResults(),
+			NewGenericCodeGenerator(&InterfaceNoOpCodeGenerator{}, &InterfaceImplementationNamer{}, writer),
+			&GeneratedFileRenderer[GoInterface]{},
+		)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, output)
+	}
+	if w.templates != nil {
+		planned, err := w.templates.Plan(opts, writer)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, planned...)
+	}
+	if err := checkPathCollisions(outputs); err != nil {
+		return err
+	}
+
+	for _, output := range outputs {
+		before := len(writer.Written())
+		_, err := output.Write()
+		for _, path := range writer.Written()[before:] {
+			if output.Label == "noop" {
+				fmt.Fprintf(w.out, "Regenerated NoOp implementations: %s\n", path)
+			} else {
+				fmt.Fprintf(w.out, "Regenerated %s: %s\n", output.Label, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	planned := make(map[string]string)
+	for _, output := range outputs {
+		for _, path := range output.Paths {
+			planned[filepath.Clean(path)] = ""
+		}
+	}
+	roots := make([]string, 0)
+	if w.noOp != nil {
+		roots = append(roots, w.noOp.layout.Root)
+	}
+	if w.templates != nil {
+		roots = appendUnique(roots, w.templates.layout.Root)
+	}
+	for _, root := range roots {
+		orphans, err := findOrphanedFiles(root, planned)
+		if err != nil {
+			return err
+		}
+		for _, path := range orphans {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			fmt.Fprintf(w.out, "Removed stale generated file: %s\n", path)
+		}
+	}
+	return nil
+}
+
+// UpdatingFileWriter passes on only files whose content differs from what
+// is on disk, and remembers which those were.
+type UpdatingFileWriter struct {
+	next    FileWriter
+	written []string
+}
+
+func NewUpdatingFileWriter(next FileWriter) *UpdatingFileWriter {
+	return &UpdatingFileWriter{next: next, written: make([]string, 0)}
+}
+
+func (ufw *UpdatingFileWriter) WriteToFile(content string, filename string) error {
+	if existing, err := os.ReadFile(filename); err == nil && string(existing) == content {
+		return nil
+	}
+	if err := ufw.next.WriteToFile(content, filename); err != nil {
+		return err
+	}
+	ufw.written = append(ufw.written, filename)
+	return nil
+}
+
+func (ufw *UpdatingFileWriter) Written() []string {
+	return ufw.written
+}
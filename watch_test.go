@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedFiles(t *testing.T) {
+	now := time.Now()
+	old := map[string]fileStamp{
+		"a.go": {size: 10, modTime: now},
+		"b.go": {size: 20, modTime: now},
+		"c.go": {size: 30, modTime: now},
+		"d.go": {size: 40, modTime: now},
+	}
+	current := map[string]fileStamp{
+		"a.go": {size: 10, modTime: now},
+		"b.go": {size: 21, modTime: now},
+		"c.go": {size: 30, modTime: now.Add(time.Second)},
+		"e.go": {size: 50, modTime: now},
+	}
+
+	want := []string{"b.go", "c.go", "d.go", "e.go"}
+	if got := changedFiles(old, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedFiles() = %v, want %v", got, want)
+	}
+	if got := changedFiles(old, old); len(got) != 0 {
+		t.Errorf("changedFiles() of the same scan = %v, want none", got)
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	root := writeTestModule(t, map[string]string{
+		"go.mod":         "module example.com/m\n",
+		"store/store.go": "package store\n\ntype Store interface {\n\tGet(id string) string\n}\n",
+		"app/app.go":     "package app\n\nfunc Run() {}\n",
+	})
+	mocks := t.TempDir()
+	resolver, err := NewPackageResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := OutputLayout{Mode: LayoutPerFile, Root: mocks, Kind: TemplateKindInterface, Template: "noop"}
+	planner, err := NewOutputPlanner(
+		layout,
+		defaultNameTemplate(layout.Mode, "noop", "interfaces"),
+		&InterfaceTypeNameProvider{},
+		newItemSorter[GoInterface](&InterfaceDependencyExtractor{}, &InterfaceTypeNameProvider{}, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	watcher := NewWatcher([]string{root}, AnalysisOptions{}, resolver, planner, nil, &out)
+	if err := watcher.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	storeDir, appDir := filepath.Join(root, "store"), filepath.Join(root, "app")
+	app := watcher.packages[appDir]
+	if app == nil || watcher.packages[storeDir] == nil {
+		t.Fatalf("packages after start: %v", watcher.packages)
+	}
+
+	// Generated by an earlier round for an interface that is gone, and a
+	// file astro didn't generate
+	stale := filepath.Join(mocks, "noop_old_interfaces.go")
+	kept := filepath.Join(mocks, "helpers.go")
+	for path, content := range map[string]string{stale: generatedHeader + "\n\npackage mocks\n", kept: "package mocks\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(storeDir, "store.go"), []byte("package store\n\ntype Repo interface {\n\tGet(id string) string\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if watcher.packages[appDir] != app {
+		t.Error("unchanged app package was analyzed again")
+	}
+	interfaces := watcher.packages[storeDir].interfaces
+	if len(interfaces) != 1 || len(interfaces[0].Items) != 1 || interfaces[0].Items[0].Name != "Repo" {
+		t.Errorf("store interfaces after poll: %+v, want Repo only", interfaces)
+	}
+
+	report := out.String()
+	generated := filepath.Join(mocks, "noop_store_interfaces.go")
+	for _, want := range []string{
+		"1 file(s) changed: " + filepath.Join(storeDir, "store.go"),
+		"- example.com/m/store: interface Store",
+		"+ example.com/m/store: interface Repo",
+		"Regenerated NoOp implementations: " + generated,
+		"Removed stale generated file: " + stale,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+	if content, err := os.ReadFile(generated); err != nil || !strings.Contains(string(content), "Repo") {
+		t.Errorf("%s: %v\n%s", generated, err, content)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", stale)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("%s was removed: %v", kept, err)
+	}
+
+	// Nothing changed since
+	out.Reset()
+	if err := watcher.poll(context.Background()); err != nil || out.Len() != 0 {
+		t.Errorf("poll() without changes = %v, reported:\n%s", err, out.String())
+	}
+}